@@ -0,0 +1,104 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux"
+	"github.com/go-ble/ble/linux/hci"
+	"github.com/go-ble/ble/linux/hci/cmd"
+)
+
+// hciSender は HCI コマンドを送信できるアダプタ（linux の *hci.HCI）
+type hciSender interface {
+	Send(c hci.Command, r hci.CommandRP) error
+}
+
+// senderFor はデバイスが HCI コマンドを直接送れる場合にその送信口を返します
+func senderFor(dev ble.Device) hciSender {
+	if d, ok := dev.(*linux.Device); ok && d.HCI != nil {
+		return d.HCI
+	}
+	return nil
+}
+
+// HCI 上のアドレス種別
+const (
+	hciAddrPublic uint8 = 0x00
+	hciAddrRandom uint8 = 0x01
+)
+
+// acceptListTypes は --pub / --rand から Filter Accept List に登録するアドレス種別を返します。
+// どちらも指定が無ければ種別が分からないため両方を返します
+func acceptListTypes(pub, rand bool) []uint8 {
+	switch {
+	case pub:
+		return []uint8{hciAddrPublic}
+	case rand:
+		return []uint8{hciAddrRandom}
+	}
+	return []uint8{hciAddrPublic, hciAddrRandom}
+}
+
+// programAcceptList はコントローラの Filter Accept List にアドレスを登録し、
+// スキャンフィルタポリシーを「リスト登録済みのみ」に切り替えます。
+// アドレス種別は HCI 上で区別されるため types の各種別で登録します。
+// 登録数がリスト容量を超える場合は何もせず ok=false を返し、ホスト側フィルタに任せます。
+// restore はスキャン終了後にポリシーを元に戻す関数です。
+func programAcceptList(s hciSender, addrs []string, types []uint8, params cmd.LESetScanParameters) (restore func(), ok bool, err error) {
+	if s == nil || len(addrs) == 0 || len(types) == 0 {
+		return func() {}, false, nil
+	}
+
+	var size cmd.LEReadWhiteListSizeRP
+	if err := s.Send(&cmd.LEReadWhiteListSize{}, &size); err != nil {
+		return func() {}, false, fmt.Errorf("failed to read accept list size: %w", err)
+	}
+	if len(addrs)*len(types) > int(size.WhiteListSize) {
+		return func() {}, false, nil
+	}
+
+	if err := s.Send(&cmd.LEClearWhiteList{}, nil); err != nil {
+		return func() {}, false, fmt.Errorf("failed to clear accept list: %w", err)
+	}
+	for _, a := range addrs {
+		b, err := hciAddrBytes(a)
+		if err != nil {
+			return func() {}, false, err
+		}
+		for _, typ := range types {
+			c := &cmd.LEAddDeviceToWhiteList{AddressType: typ, Address: b}
+			if err := s.Send(c, nil); err != nil {
+				return func() {}, false, fmt.Errorf("failed to add %s to accept list: %w", a, err)
+			}
+		}
+	}
+
+	p := params
+	p.ScanningFilterPolicy = 0x01
+	if err := s.Send(&p, nil); err != nil {
+		return func() {}, false, fmt.Errorf("failed to set scan parameters: %w", err)
+	}
+
+	restore = func() {
+		p := params
+		p.ScanningFilterPolicy = 0x00
+		_ = s.Send(&p, nil)
+		_ = s.Send(&cmd.LEClearWhiteList{}, nil)
+	}
+	return restore, true, nil
+}
+
+// hciAddrBytes は "aa:bb:cc:dd:ee:ff" を HCI のリトルエンディアン表現に変換します
+func hciAddrBytes(addr string) ([6]byte, error) {
+	var b [6]byte
+	hw, err := net.ParseMAC(addr)
+	if err != nil || len(hw) != 6 {
+		return b, fmt.Errorf("invalid address format: %s", addr)
+	}
+	for i := range b {
+		b[i] = hw[5-i]
+	}
+	return b, nil
+}
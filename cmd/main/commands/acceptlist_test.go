@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/go-ble/ble/linux/hci"
+	"github.com/go-ble/ble/linux/hci/cmd"
+)
+
+/* ---------- フェイク HCI ---------- */
+type fakeSender struct {
+	size uint8
+	sent []hci.Command
+}
+
+func (f *fakeSender) Send(c hci.Command, r hci.CommandRP) error {
+	f.sent = append(f.sent, c)
+	if rp, ok := r.(*cmd.LEReadWhiteListSizeRP); ok {
+		rp.WhiteListSize = f.size
+	}
+	return nil
+}
+
+/* ---------- 1. 登録とポリシー切替 ---------- */
+func TestProgramAcceptList(t *testing.T) {
+	s := &fakeSender{size: 8}
+	restore, ok, err := programAcceptList(s, []string{"01:23:45:67:89:ab"}, acceptListTypes(false, false), defaultScanParams)
+	if err != nil || !ok {
+		t.Fatalf("programAcceptList: ok=%v err=%v", ok, err)
+	}
+	var adds int
+	var policy uint8
+	for _, c := range s.sent {
+		switch v := c.(type) {
+		case *cmd.LEAddDeviceToWhiteList:
+			adds++
+			if v.Address != [6]byte{0xab, 0x89, 0x67, 0x45, 0x23, 0x01} {
+				t.Errorf("address not little endian: %x", v.Address)
+			}
+		case *cmd.LESetScanParameters:
+			policy = v.ScanningFilterPolicy
+		}
+	}
+	if adds != 2 || policy != 0x01 {
+		t.Fatalf("adds=%d policy=%d, want 2 and 1", adds, policy)
+	}
+
+	restore()
+	last := s.sent[len(s.sent)-2].(*cmd.LESetScanParameters)
+	if last.ScanningFilterPolicy != 0x00 {
+		t.Fatalf("restore should reset filter policy")
+	}
+}
+
+/* ---------- 2. 容量超過はホスト側にフォールバック ---------- */
+func TestProgramAcceptList_TooMany(t *testing.T) {
+	s := &fakeSender{size: 2}
+	_, ok, err := programAcceptList(s, []string{"01:23:45:67:89:ab", "01:23:45:67:89:ac"}, acceptListTypes(false, false), defaultScanParams)
+	if err != nil || ok {
+		t.Fatalf("expected fallback without error, ok=%v err=%v", ok, err)
+	}
+	if len(s.sent) != 1 {
+		t.Fatalf("only the size query should be sent, got %d commands", len(s.sent))
+	}
+}
+
+/* ---------- 3. アダプタ無し ---------- */
+func TestProgramAcceptList_NoSender(t *testing.T) {
+	restore, ok, err := programAcceptList(nil, []string{"01:23:45:67:89:ab"}, acceptListTypes(false, false), defaultScanParams)
+	if err != nil || ok {
+		t.Fatalf("nil sender should be a no-op")
+	}
+	restore()
+}
+
+/* ---------- 4. 既知のアドレス種別だけを登録 ---------- */
+func TestProgramAcceptList_KnownType(t *testing.T) {
+	s := &fakeSender{size: 2}
+	_, ok, err := programAcceptList(s, []string{"01:23:45:67:89:ab", "01:23:45:67:89:ac"}, acceptListTypes(false, true), defaultScanParams)
+	if err != nil || !ok {
+		t.Fatalf("programAcceptList: ok=%v err=%v", ok, err)
+	}
+	var adds int
+	for _, c := range s.sent {
+		if v, ok := c.(*cmd.LEAddDeviceToWhiteList); ok {
+			adds++
+			if v.AddressType != hciAddrRandom {
+				t.Errorf("address type = %d, want random", v.AddressType)
+			}
+		}
+	}
+	if adds != 2 {
+		t.Fatalf("adds=%d, want one entry per address", adds)
+	}
+}
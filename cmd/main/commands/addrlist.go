@@ -0,0 +1,214 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// addrList は --allow / --deny で読み込むアドレスリスト。
+// 1 行 1 エントリで、以下の形式を受け付けます（# 以降はコメント）。
+//
+//	aa:bb:cc:dd:ee:ff   完全一致
+//	a4:c1:38:*          プレフィックス（OUI など）
+//	0x004C / 76         Company ID
+//	Apple               Company 名
+type addrList struct {
+	path string
+	set  atomic.Pointer[addrSet]
+	// inController はリストがコントローラの Filter Accept List に登録済みで、
+	// ホスト側の判定を省けるとき true。再読込すると false に戻ります
+	inController atomic.Bool
+}
+
+// addrSet はパース済みのリスト内容。再読込時は丸ごと差し替えます
+type addrSet struct {
+	exact     map[string]struct{}
+	prefixes  []string
+	companies map[int]struct{}
+}
+
+var prefixPat = regexp.MustCompile(`^([0-9a-f]{2}:){0,5}\*$`)
+
+// loadAddrList はファイルを読み込んで addrList を返します
+func loadAddrList(path string) (*addrList, error) {
+	l := &addrList{path: path}
+	if err := l.reload(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reload はファイルを読み直します。失敗時は以前の内容を保持します
+func (l *addrList) reload() error {
+	f, err := os.Open(l.path)
+	if err != nil {
+		return fmt.Errorf("failed to open address list: %w", err)
+	}
+	defer f.Close()
+
+	s, err := parseAddrSet(f.Name(), bufio.NewScanner(f))
+	if err != nil {
+		return err
+	}
+	l.set.Store(s)
+	l.inController.Store(false)
+	return nil
+}
+
+// Len は登録エントリ数を返します
+func (l *addrList) Len() int {
+	s := l.set.Load()
+	return len(s.exact) + len(s.prefixes) + len(s.companies)
+}
+
+// exactAddrs はリストが完全一致のアドレスだけで構成されていればその一覧を返します
+func (l *addrList) exactAddrs() ([]string, bool) {
+	s := l.set.Load()
+	if len(s.exact) == 0 || len(s.prefixes) > 0 || len(s.companies) > 0 {
+		return nil, false
+	}
+	addrs := make([]string, 0, len(s.exact))
+	for a := range s.exact {
+		addrs = append(addrs, a)
+	}
+	sort.Strings(addrs)
+	return addrs, true
+}
+
+// Match はアドバタイズがリストのいずれかのエントリに該当するかを返します
+func (l *addrList) Match(a ble.Advertisement) bool {
+	s := l.set.Load()
+	addr := strings.ToLower(a.Addr().String())
+	if _, ok := s.exact[addr]; ok {
+		return true
+	}
+	for _, p := range s.prefixes {
+		if strings.HasPrefix(addr, p) {
+			return true
+		}
+	}
+	if len(s.companies) > 0 {
+		if _, ok := s.companies[peekbt.CompanyID(a)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddrSet(name string, sc *bufio.Scanner) (*addrSet, error) {
+	s := &addrSet{
+		exact:     make(map[string]struct{}),
+		companies: make(map[int]struct{}),
+	}
+	for line := 1; sc.Scan(); line++ {
+		entry := sc.Text()
+		if i := strings.IndexByte(entry, '#'); i >= 0 {
+			entry = entry[:i]
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		lower := strings.ToLower(entry)
+
+		switch {
+		case validateAddr(lower) == nil:
+			s.exact[lower] = struct{}{}
+		case prefixPat.MatchString(lower):
+			s.prefixes = append(s.prefixes, strings.TrimSuffix(lower, "*"))
+		default:
+			if id, err := strconv.ParseUint(lower, 0, 16); err == nil {
+				s.companies[int(id)] = struct{}{}
+			} else if id, ok := peekbt.CompanyIDByName(entry); ok {
+				s.companies[id] = struct{}{}
+			} else {
+				return nil, fmt.Errorf("%s:%d: unrecognized entry %q", name, line, entry)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read address list: %w", err)
+	}
+	return s, nil
+}
+
+// accessFilter は allow / deny リストを ble.AdvFilter にまとめます（どちらも無ければ nil）
+func accessFilter(allow, deny *addrList) ble.AdvFilter {
+	var allowF, denyF ble.AdvFilter
+	if allow != nil {
+		allowF = func(a ble.Advertisement) bool { return allow.inController.Load() || allow.Match(a) }
+	}
+	if deny != nil {
+		denyF = func(a ble.Advertisement) bool { return !deny.Match(a) }
+	}
+	return buildAdvFilter(allowF, denyF)
+}
+
+// acceptListAddrs は Filter Accept List に登録するアドレスを選びます。
+// --addr の指定を優先し、無ければ allow リストが完全一致だけのときにその内容を使います。
+// fromAllow は allow リストから選んだかどうかです
+func acceptListAddrs(addrs []string, allow *addrList) (list []string, fromAllow bool) {
+	if len(addrs) > 0 || allow == nil {
+		return addrs, false
+	}
+	return allow.exactAddrs()
+}
+
+// loadAccessLists は --allow / --deny のファイルを読み込みます（未指定は nil）
+func loadAccessLists(allowPath, denyPath string) (allow, deny *addrList, err error) {
+	if allowPath != "" {
+		if allow, err = loadAddrList(allowPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if denyPath != "" {
+		if deny, err = loadAddrList(denyPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	return allow, deny, nil
+}
+
+// reloadOnSIGHUP は ctx が終わるまで SIGHUP を受けるたびにリストを読み直します。
+// 読み込みに失敗した場合は onErr に通知し、以前の内容で続行します
+func reloadOnSIGHUP(ctx context.Context, onErr func(error), lists ...*addrList) {
+	var targets []*addrList
+	for _, l := range lists {
+		if l != nil {
+			targets = append(targets, l)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(ch)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ch:
+				for _, l := range targets {
+					if err := l.reload(); err != nil && onErr != nil {
+						onErr(err)
+					}
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,163 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+func writeList(t *testing.T, dir, body string) string {
+	t.Helper()
+	p := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(p, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+/* ---------- 1. エントリ形式 ---------- */
+func TestAddrList_Match(t *testing.T) {
+	p := writeList(t, t.TempDir(), `
+# lab devices
+AA:BB:CC:DD:EE:FF
+a4:c1:38:*      # Xiaomi thermometers
+0x0499
+Tile
+`)
+	l, err := loadAddrList(p)
+	if err != nil {
+		t.Fatalf("loadAddrList: %v", err)
+	}
+	if l.Len() != 4 {
+		t.Fatalf("Len() = %d, want 4", l.Len())
+	}
+
+	mk := func(addr string, mfg []byte) ble.Advertisement {
+		return filterAdv{stubAdv: stubAdv{addr: ble.NewAddr(addr)}, mfg: mfg}
+	}
+	cases := []struct {
+		adv  ble.Advertisement
+		want bool
+	}{
+		{mk("aa:bb:cc:dd:ee:ff", nil), true},
+		{mk("a4:c1:38:01:02:03", nil), true},
+		{mk("a4:c1:39:01:02:03", nil), false},
+		{mk("11:22:33:44:55:66", []byte{0x99, 0x04}), true},
+		{mk("11:22:33:44:55:66", []byte{0x7c, 0x06, 0x01}), true},
+		{mk("11:22:33:44:55:66", []byte{0x4c, 0x00}), false},
+	}
+	for i, c := range cases {
+		if got := l.Match(c.adv); got != c.want {
+			t.Errorf("case %d (%s): got %v, want %v", i, c.adv.Addr(), got, c.want)
+		}
+	}
+}
+
+/* ---------- 2. 不正エントリ ---------- */
+func TestAddrList_Invalid(t *testing.T) {
+	p := writeList(t, t.TempDir(), "aa:bb:cc:dd:ee:ff\nnot-a-company\n")
+	if _, err := loadAddrList(p); err == nil {
+		t.Fatalf("expected error on unrecognized entry")
+	}
+	if _, err := loadAddrList(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("expected error on missing file")
+	}
+}
+
+/* ---------- 3. allow / deny の合成 ---------- */
+func TestAccessFilter(t *testing.T) {
+	dir := t.TempDir()
+	allowPath := filepath.Join(dir, "allow")
+	denyPath := filepath.Join(dir, "deny")
+	os.WriteFile(allowPath, []byte("aa:bb:cc:*\n"), 0o644)
+	os.WriteFile(denyPath, []byte("aa:bb:cc:dd:ee:ff\n"), 0o644)
+
+	if f := accessFilter(nil, nil); f != nil {
+		t.Fatalf("no lists should yield nil filter")
+	}
+	allow, deny, err := loadAccessLists(allowPath, denyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := accessFilter(allow, deny)
+	if !f(stubAdv{addr: ble.NewAddr("aa:bb:cc:00:00:01")}) {
+		t.Errorf("allowed prefix rejected")
+	}
+	if f(stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff")}) {
+		t.Errorf("denied address accepted")
+	}
+	if f(stubAdv{addr: ble.NewAddr("11:bb:cc:dd:ee:ff")}) {
+		t.Errorf("address outside allowlist accepted")
+	}
+}
+
+/* ---------- 4. SIGHUP で再読込 ---------- */
+func TestReloadOnSIGHUP(t *testing.T) {
+	p := writeList(t, t.TempDir(), "aa:bb:cc:dd:ee:ff\n")
+	l, err := loadAddrList(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloadOnSIGHUP(ctx, nil, l)
+
+	os.WriteFile(p, []byte("11:22:33:44:55:66\n"), 0o644)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+
+	adv := stubAdv{addr: ble.NewAddr("11:22:33:44:55:66")}
+	deadline := time.Now().Add(2 * time.Second)
+	for !l.Match(adv) {
+		if time.Now().After(deadline) {
+			t.Fatalf("list was not reloaded on SIGHUP")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+/* ---------- 5. Filter Accept List への登録 ---------- */
+func TestAcceptListAddrs(t *testing.T) {
+	p := writeList(t, t.TempDir(), "AA:BB:CC:DD:EE:02\naa:bb:cc:dd:ee:01\n")
+	allow, err := loadAddrList(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// --addr の指定が優先
+	if got, fromAllow := acceptListAddrs([]string{"01:23:45:67:89:ab"}, allow); fromAllow || len(got) != 1 {
+		t.Fatalf("--addr should win over the allow list, got %v (fromAllow=%v)", got, fromAllow)
+	}
+	got, fromAllow := acceptListAddrs(nil, allow)
+	if !fromAllow || len(got) != 2 || got[0] != "aa:bb:cc:dd:ee:01" || got[1] != "aa:bb:cc:dd:ee:02" {
+		t.Fatalf("exact allow list not selected: %v (fromAllow=%v)", got, fromAllow)
+	}
+
+	// コントローラが絞り込む間はホスト側で判定しない
+	allow.inController.Store(true)
+	f := accessFilter(allow, nil)
+	if !f(stubAdv{addr: ble.NewAddr("11:22:33:44:55:66")}) {
+		t.Errorf("host-side check should be skipped while the accept list is programmed")
+	}
+	// 再読込後はコントローラの内容と一致しないためホスト側で判定する
+	if err := allow.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if f(stubAdv{addr: ble.NewAddr("11:22:33:44:55:66")}) {
+		t.Errorf("reloaded list should be checked on the host")
+	}
+
+	// プレフィックスや Company を含むリストは登録できない
+	p = writeList(t, t.TempDir(), "aa:bb:cc:dd:ee:01\na4:c1:38:*\n")
+	mixed, err := loadAddrList(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := acceptListAddrs(nil, mixed); ok || got != nil {
+		t.Fatalf("mixed list should stay on the host, got %v", got)
+	}
+}
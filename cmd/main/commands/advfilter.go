@@ -0,0 +1,68 @@
+package commands
+
+import (
+	"strings"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// buildAdvFilter は複数の条件を AND で結合した ble.AdvFilter を返します。
+// 条件が一つも無ければ nil を返し、ble.Scan 側の判定を省略させます
+func buildAdvFilter(conds ...ble.AdvFilter) ble.AdvFilter {
+	var fs []ble.AdvFilter
+	for _, c := range conds {
+		if c != nil {
+			fs = append(fs, c)
+		}
+	}
+	switch len(fs) {
+	case 0:
+		return nil
+	case 1:
+		return fs[0]
+	}
+	return func(a ble.Advertisement) bool {
+		for _, f := range fs {
+			if !f(a) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// addrTypeFilter は --pub / --rand に対応するフィルタを返します
+func addrTypeFilter(pub, rand bool) ble.AdvFilter {
+	switch {
+	case pub:
+		return func(a ble.Advertisement) bool { return peekbt.AddrKindOf(a) == peekbt.AddrPublic }
+	case rand:
+		return func(a ble.Advertisement) bool { return peekbt.AddrKindOf(a) == peekbt.AddrRandom }
+	default:
+		return nil
+	}
+}
+
+// addrSetFilter は指定アドレスのみを通すフィルタを返します（空なら nil）
+func addrSetFilter(addrs []string) ble.AdvFilter {
+	if len(addrs) == 0 {
+		return nil
+	}
+	set := make(map[string]struct{}, len(addrs))
+	for _, a := range addrs {
+		set[strings.ToLower(a)] = struct{}{}
+	}
+	return func(a ble.Advertisement) bool {
+		_, ok := set[strings.ToLower(a.Addr().String())]
+		return ok
+	}
+}
+
+// exprFilter はフィルタ式を ble.AdvFilter に変換します（nil なら nil）
+func exprFilter(f *filterExpr) ble.AdvFilter {
+	if f == nil {
+		return nil
+	}
+	return f.Match
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux/hci"
+)
+
+/* ---------- 1. 条件なしは nil ---------- */
+func TestBuildAdvFilter_Nil(t *testing.T) {
+	if f := buildAdvFilter(addrTypeFilter(false, false), addrSetFilter(nil), exprFilter(nil)); f != nil {
+		t.Fatalf("filter should be nil without conditions")
+	}
+}
+
+/* ---------- 2. AND 結合 ---------- */
+func TestBuildAdvFilter_And(t *testing.T) {
+	flt, _ := parseFilter("rssi > -70")
+	f := buildAdvFilter(
+		addrSetFilter([]string{"01:23:45:67:89:AB"}),
+		exprFilter(flt),
+	)
+	near := stubAdv{addr: ble.NewAddr("01:23:45:67:89:ab"), rssi: -50}
+	far := stubAdv{addr: ble.NewAddr("01:23:45:67:89:ab"), rssi: -90}
+	other := stubAdv{addr: ble.NewAddr("01:23:45:67:89:ac"), rssi: -50}
+	if !f(near) || f(far) || f(other) {
+		t.Fatalf("unexpected filter result: near=%v far=%v other=%v", f(near), f(far), f(other))
+	}
+}
+
+/* ---------- 3. --pub / --rand ---------- */
+func TestAddrTypeFilter(t *testing.T) {
+	// 先頭オクテットが 0xF4 でも HCI 上 Public なら --pub に含まれる
+	pub := stubAdv{addr: net.HardwareAddr{0xF4, 1, 2, 3, 4, 5}}
+	rnd := stubAdv{addr: hci.RandomAddress{Addr: net.HardwareAddr{0x00, 1, 2, 3, 4, 5}}}
+	if f := addrTypeFilter(true, false); !f(pub) || f(rnd) {
+		t.Errorf("--pub filter mismatch")
+	}
+	if f := addrTypeFilter(false, true); f(pub) || !f(rnd) {
+		t.Errorf("--rand filter mismatch")
+	}
+}
@@ -0,0 +1,250 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// /devices の sort で指定できるキー
+var apiSortKeys = map[string]func(a, b *peekbt.Entry) int{
+	"address":    func(a, b *peekbt.Entry) int { return strings.Compare(a.Info.Address, b.Info.Address) },
+	"name":       func(a, b *peekbt.Entry) int { return strings.Compare(a.Info.Name, b.Info.Name) },
+	"rssi":       func(a, b *peekbt.Entry) int { return a.Info.RSSI - b.Info.RSSI },
+	"count":      func(a, b *peekbt.Entry) int { return a.Count - b.Count },
+	"first_seen": func(a, b *peekbt.Entry) int { return a.FirstSeen.Compare(b.FirstSeen) },
+	"last_seen":  func(a, b *peekbt.Entry) int { return a.LastSeen.Compare(b.LastSeen) },
+}
+
+// apiServer は serve --http の REST API。セッションのデバイス表を返し、
+// その変化を /events (SSE) と /ws (WebSocket) に流します
+type apiServer struct {
+	table   *peekbt.DeviceTable // セッションのデバイス表（更新はセッションが行う）
+	hub     *eventHub
+	started time.Time
+	now     func() time.Time
+
+	allowOrigins []string // WebSocket の接続を許可する別オリジン
+
+	mu      sync.Mutex
+	scanErr error     // 直近のスキャン失敗
+	errAt   time.Time // scanErr の時刻（その後に受信があれば回復とみなす）
+}
+
+// newAPIServer は table を公開する API を作ります。interval は同じデバイスの update を配信する最短間隔です
+func newAPIServer(table *peekbt.DeviceTable, interval time.Duration) *apiServer {
+	return &apiServer{table: table, hub: newEventHub(interval), started: time.Now(), now: time.Now}
+}
+
+// Event はデバイス表の変化（new / update / lost）を購読者に配ります
+func (s *apiServer) Event(ev peekbt.Event) {
+	s.hub.publish(string(ev.Type), ev.Entry, ev.Time)
+}
+
+// ScanError はスキャンの失敗を /healthz に反映します
+func (s *apiServer) ScanError(err error) {
+	s.mu.Lock()
+	s.scanErr, s.errAt = err, s.now()
+	s.mu.Unlock()
+}
+
+// run は ctx が終わるまで定期的に保留中の update を送ります
+func (s *apiServer) run(ctx context.Context) {
+	if s.hub.interval <= 0 {
+		return
+	}
+	t := time.NewTicker(s.hub.interval / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.hub.flush(now)
+		}
+	}
+}
+
+// handler は API のルーティングを返します
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /devices", s.handleDevices)
+	mux.HandleFunc("GET /devices/{addr}", s.handleDevice)
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /events", s.handleEvents)
+	mux.HandleFunc("GET /ws", s.handleWS)
+	return mux
+}
+
+// deviceList は GET /devices の応答
+type deviceList struct {
+	Count   int                   `json:"count"`
+	Devices []peekbt.DeviceRecord `json:"devices"`
+}
+
+// handleDevices は GET /devices。クエリでの絞り込み・並べ替え・件数制限に対応します
+//
+//	filter=<式>  addrtype=<種別>  vendor=<名前>  name=<部分一致>  connectable=<bool>
+//	min_rssi=<dBm>  max_rssi=<dBm>  seen_within=<期間>  sort=[-]<キー>  limit=<件数>
+func (s *apiServer) handleDevices(w http.ResponseWriter, r *http.Request) {
+	match, err := s.deviceMatcher(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	q := r.URL.Query()
+	key, desc := strings.CutPrefix(q.Get("sort"), "-")
+	if key == "" {
+		key = "address"
+	}
+	cmp, ok := apiSortKeys[key]
+	if !ok {
+		writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid sort key %q", key))
+		return
+	}
+	limit := -1
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+	}
+
+	entries, total := s.table.Sorted(match, func(a, b *peekbt.Entry) int {
+		c := cmp(a, b)
+		if c == 0 {
+			c = strings.Compare(a.Info.Address, b.Info.Address)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	}, limit)
+	res := deviceList{Count: total, Devices: make([]peekbt.DeviceRecord, len(entries))}
+	for i := range entries {
+		res.Devices[i] = entries[i].Record()
+	}
+	writeAPIJSON(w, http.StatusOK, res)
+}
+
+// deviceMatcher はクエリパラメータから絞り込み条件を組み立てます
+func (s *apiServer) deviceMatcher(q url.Values) (func(*peekbt.Entry) bool, error) {
+	var conds []func(*peekbt.Entry) bool
+	intParam := func(name string, fn func(e *peekbt.Entry, v int) bool) error {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", name, v)
+			}
+			conds = append(conds, func(e *peekbt.Entry) bool { return fn(e, n) })
+		}
+		return nil
+	}
+
+	if v := q.Get("filter"); v != "" {
+		f, err := parseFilter(v)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, func(e *peekbt.Entry) bool { return f.Match(e.Adv) })
+	}
+	if v := q.Get("addrtype"); v != "" {
+		conds = append(conds, func(e *peekbt.Entry) bool { return strings.EqualFold(e.Info.AddressType, v) })
+	}
+	if v := q.Get("vendor"); v != "" {
+		conds = append(conds, func(e *peekbt.Entry) bool { return strings.EqualFold(e.Vendor, v) })
+	}
+	if v := strings.ToLower(q.Get("name")); v != "" {
+		conds = append(conds, func(e *peekbt.Entry) bool { return strings.Contains(strings.ToLower(e.Info.Name), v) })
+	}
+	if v := q.Get("connectable"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid connectable %q", v)
+		}
+		conds = append(conds, func(e *peekbt.Entry) bool { return e.Info.Connectable == b })
+	}
+	if err := intParam("min_rssi", func(e *peekbt.Entry, n int) bool { return e.Info.RSSI >= n }); err != nil {
+		return nil, err
+	}
+	if err := intParam("max_rssi", func(e *peekbt.Entry, n int) bool { return e.Info.RSSI <= n }); err != nil {
+		return nil, err
+	}
+	if v := q.Get("seen_within"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid seen_within %q", v)
+		}
+		since := s.now().Add(-d)
+		conds = append(conds, func(e *peekbt.Entry) bool { return !e.LastSeen.Before(since) })
+	}
+
+	return func(e *peekbt.Entry) bool {
+		for _, c := range conds {
+			if !c(e) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// handleDevice は GET /devices/{addr}
+func (s *apiServer) handleDevice(w http.ResponseWriter, r *http.Request) {
+	addr := strings.ToLower(r.PathValue("addr"))
+	if err := validateAddr(addr); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	e, ok := s.table.Get(addr)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("device %s not found", addr))
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, e.Record())
+}
+
+// health は GET /healthz の応答
+type health struct {
+	Status            string `json:"status"`
+	Error             string `json:"error,omitempty"`
+	Devices           int    `json:"devices"`
+	LastAdvertisement string `json:"lastAdvertisement,omitempty"`
+	Uptime            string `json:"uptime"`
+}
+
+// handleHealth は GET /healthz。直近のスキャンが失敗していれば 503 を返します
+func (s *apiServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	last := s.table.LastSeen()
+	h := health{Status: "ok", Devices: s.table.Len(), Uptime: s.now().Sub(s.started).Round(time.Second).String()}
+	if !last.IsZero() {
+		h.LastAdvertisement = last.Format(time.RFC3339)
+	}
+	code := http.StatusOK
+	s.mu.Lock()
+	if s.scanErr != nil && !last.After(s.errAt) {
+		h.Status, h.Error, code = "error", s.scanErr.Error(), http.StatusServiceUnavailable
+	}
+	s.mu.Unlock()
+	writeAPIJSON(w, code, h)
+}
+
+func writeAPIJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, err error) {
+	writeAPIJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,165 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// newTestAPIFeeder は 3 台を受信済みの API と、そのデバイス表を更新する sinkFeeder を返します
+func newTestAPIFeeder(t *testing.T) (*apiServer, *sinkFeeder, *httptest.Server) {
+	t.Helper()
+	table := peekbt.NewDeviceTable(time.Minute)
+	s := newAPIServer(table, time.Second)
+	f := newSinkFeeder(table, s, peekbt.EventNew, peekbt.EventUpdate, peekbt.EventLost)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	f.adv(filterAdv{stubAdv: stubAdv{addr: net.HardwareAddr{0xf4, 0x8c, 0x50, 1, 2, 3}, name: "Tile", rssi: -40},
+		mfg: []byte{0x4c, 0x00}, conn: true}, now.Add(-5*time.Second))
+	f.adv(stubAdv{addr: net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}, name: "ATC_010203", rssi: -80}, now.Add(-40*time.Second))
+	f.adv(stubAdv{addr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}, rssi: -60}, now)
+	srv := httptest.NewServer(s.handler())
+	t.Cleanup(srv.Close)
+	return s, f, srv
+}
+
+func newTestAPI(t *testing.T) (*apiServer, *httptest.Server) {
+	t.Helper()
+	s, _, srv := newTestAPIFeeder(t)
+	return s, srv
+}
+
+func getJSON(t *testing.T, srv *httptest.Server, path string, v any) int {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("GET %s: invalid JSON: %v", path, err)
+	}
+	return resp.StatusCode
+}
+
+func addresses(l deviceList) []string {
+	var out []string
+	for _, d := range l.Devices {
+		out = append(out, d.Address)
+	}
+	return out
+}
+
+/* ---------- 1. 一覧・絞り込み・並べ替え ---------- */
+func TestAPI_Devices(t *testing.T) {
+	_, srv := newTestAPI(t)
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"00:11:22:33:44:55", "a4:c1:38:01:02:03", "f4:8c:50:01:02:03"}},
+		{"?sort=-rssi", []string{"f4:8c:50:01:02:03", "00:11:22:33:44:55", "a4:c1:38:01:02:03"}},
+		{"?sort=last_seen&limit=2", []string{"a4:c1:38:01:02:03", "f4:8c:50:01:02:03"}},
+		{"?min_rssi=-70", []string{"00:11:22:33:44:55", "f4:8c:50:01:02:03"}},
+		{"?vendor=apple", []string{"f4:8c:50:01:02:03"}},
+		{"?name=atc", []string{"a4:c1:38:01:02:03"}},
+		{"?seen_within=10s&connectable=true", []string{"00:11:22:33:44:55", "f4:8c:50:01:02:03"}},
+		{"?filter=" + "company%20%3D%3D%200x004C%20%26%26%20connectable", []string{"f4:8c:50:01:02:03"}},
+	}
+	for _, c := range cases {
+		var l deviceList
+		if code := getJSON(t, srv, "/devices"+c.query, &l); code != http.StatusOK {
+			t.Fatalf("%s: status %d", c.query, code)
+		}
+		got := addresses(l)
+		if len(got) != len(c.want) {
+			t.Fatalf("%s: got %v, want %v", c.query, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%s: got %v, want %v", c.query, got, c.want)
+			}
+		}
+	}
+}
+
+/* ---------- 2. 不正なクエリ ---------- */
+func TestAPI_BadQuery(t *testing.T) {
+	_, srv := newTestAPI(t)
+	for _, q := range []string{"?sort=vendor", "?limit=-1", "?min_rssi=strong", "?seen_within=1y", "?filter=rssi%20%3E", "?connectable=maybe"} {
+		var e map[string]string
+		if code := getJSON(t, srv, "/devices"+q, &e); code != http.StatusBadRequest || e["error"] == "" {
+			t.Errorf("%s: status %d, body %v", q, code, e)
+		}
+	}
+}
+
+/* ---------- 3. 1 台分の詳細 ---------- */
+func TestAPI_Device(t *testing.T) {
+	_, srv := newTestAPI(t)
+	var rec peekbt.DeviceRecord
+	if code := getJSON(t, srv, "/devices/F4:8C:50:01:02:03", &rec); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if rec.Name != "Tile" || rec.Vendor != "Apple" || rec.Stats.Count != 1 || !rec.Connectable {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+
+	var e map[string]string
+	if code := getJSON(t, srv, "/devices/01:02:03:04:05:06", &e); code != http.StatusNotFound {
+		t.Errorf("unknown device: status %d", code)
+	}
+	if code := getJSON(t, srv, "/devices/nope", &e); code != http.StatusBadRequest {
+		t.Errorf("invalid address: status %d", code)
+	}
+}
+
+/* ---------- 4. ヘルスチェック ---------- */
+func TestAPI_Healthz(t *testing.T) {
+	s, f, srv := newTestAPIFeeder(t)
+	var h health
+	if code := getJSON(t, srv, "/healthz", &h); code != http.StatusOK || h.Status != "ok" || h.Devices != 3 {
+		t.Fatalf("status %d, %+v", code, h)
+	}
+
+	s.ScanError(errors.New("scan failed: hci0 down"))
+	if code := getJSON(t, srv, "/healthz", &h); code != http.StatusServiceUnavailable || h.Error == "" {
+		t.Fatalf("status %d, %+v", code, h)
+	}
+	// 受信が再開すれば回復する
+	f.adv(stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 0, 9}}, s.now().Add(time.Second))
+	if code := getJSON(t, srv, "/healthz", &h); code != http.StatusOK {
+		t.Fatalf("status %d after recovery", code)
+	}
+}
+
+/* ---------- 5. 件数の上限 ---------- */
+func TestAPI_MaxDevices(t *testing.T) {
+	s, f, srv := newTestAPIFeeder(t)
+	var l deviceList
+	if getJSON(t, srv, "/devices?limit=1", &l); l.Count != 3 || len(l.Devices) != 1 {
+		t.Fatalf("limit: count %d, %d devices", l.Count, len(l.Devices))
+	}
+
+	// 上限を超えたら最も長く見えていないデバイスから消え、lost を配る
+	sub := s.hub.subscribe(nil)
+	s.table.SetMaxDevices(2)
+	f.adv(stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 0, 9}}, s.now())
+	got := map[string]string{}
+	for len(sub.ch) > 0 {
+		ev := <-sub.ch
+		got[ev.Address] = ev.Event
+	}
+	if got["a4:c1:38:01:02:03"] != eventLost || got["f4:8c:50:01:02:03"] != eventLost || got["00:00:00:00:00:09"] != eventNew {
+		t.Errorf("events %v", got)
+	}
+	if getJSON(t, srv, "/devices", &l); l.Count != 2 {
+		t.Errorf("%d devices, want 2", l.Count)
+	}
+}
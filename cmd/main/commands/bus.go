@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// busBuffer は購読者毎に溜めておけるアドバタイズのイベント数
+const busBuffer = 1024
+
+// eventBus はセッションのイベントを出力先（TUI・NDJSON・記録・メトリクスなど）に配ります。
+// 購読者はそれぞれ専用の goroutine でイベントを受け取り、互いに独立して動きます。
+// 処理が追いつかない購読者の分は他を待たせないよう、アドバタイズ（EventAdv）に限って捨てて数えます。
+// デバイスの出現・変化・消失は捨てずに順序どおり届けます
+type eventBus struct {
+	subs []*busSub
+	wg   sync.WaitGroup
+
+	// lossless ならアドバタイズも捨てずに購読者を待つ（最大速度の再生など、待たせても受信を取りこぼさない場合）
+	lossless bool
+	log      io.Writer // close 時に捨てた数を報告する先
+
+	// mu は publish と close の排他。受信ハンドラは Scan が戻った後も動いていることがある
+	mu     sync.RWMutex
+	closed bool
+}
+
+// busSub は 1 つの購読者
+type busSub struct {
+	name    string
+	types   map[peekbt.EventType]bool // 空なら全種別
+	fn      func(peekbt.Event)
+	dropped atomic.Int64 // キューが一杯で捨てたアドバタイズの数
+
+	mu     sync.Mutex
+	cond   *sync.Cond // キューの追加・取り出し・終了を知らせる
+	queue  []peekbt.Event
+	closed bool
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{log: os.Stderr}
+}
+
+// subscribe は types（省略時は全種別）のイベントを fn で受け取る購読者を加えます。start より前に呼びます
+func (b *eventBus) subscribe(name string, fn func(peekbt.Event), types ...peekbt.EventType) {
+	s := &busSub{name: name, types: make(map[peekbt.EventType]bool), fn: fn}
+	s.cond = sync.NewCond(&s.mu)
+	for _, t := range types {
+		s.types[t] = true
+	}
+	b.subs = append(b.subs, s)
+}
+
+// start は購読者毎の goroutine を起動します
+func (b *eventBus) start() {
+	for _, s := range b.subs {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			for {
+				ev, ok := s.pop()
+				if !ok {
+					return
+				}
+				s.fn(ev)
+			}
+		}()
+	}
+}
+
+// publish はイベントを関心のある購読者に渡します。各購読者には届いた順に渡ります。
+// close の後に呼ばれたイベントは捨てます
+func (b *eventBus) publish(ev peekbt.Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return
+	}
+	for _, s := range b.subs {
+		if len(s.types) == 0 || s.types[ev.Type] {
+			s.push(ev, b.lossless)
+		}
+	}
+}
+
+// close は購読者が残りのイベントを処理し終えるまで待ち、捨てたイベントがあれば報告します
+func (b *eventBus) close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	for _, s := range b.subs {
+		s.mu.Lock()
+		s.closed = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+
+	for _, s := range b.subs {
+		if n := s.dropped.Load(); n > 0 {
+			fmt.Fprintf(b.log, "%s: dropped %d events (output too slow)\n", s.name, n)
+		}
+	}
+}
+
+// push は ev をキューに加えます。キューが一杯ならアドバタイズは捨てる（lossless なら空くまで待つ）。
+// それ以外の種別は一杯でも加えます
+func (s *busSub) push(ev peekbt.Event, lossless bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ev.Type == peekbt.EventAdv {
+		for lossless && len(s.queue) >= busBuffer {
+			s.cond.Wait()
+		}
+		if len(s.queue) >= busBuffer {
+			s.dropped.Add(1)
+			return
+		}
+	}
+	s.queue = append(s.queue, ev)
+	s.cond.Broadcast()
+}
+
+// pop はキューの先頭を取り出します。終了後にキューが空になれば false を返します
+func (s *busSub) pop() (peekbt.Event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if len(s.queue) == 0 {
+		return peekbt.Event{}, false
+	}
+	ev := s.queue[0]
+	s.queue[0] = peekbt.Event{}
+	s.queue = s.queue[1:]
+	s.cond.Broadcast()
+	return ev, true
+}
@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+/* ---------- 1. 種別での購読と順序 ---------- */
+func TestEventBus_Subscribe(t *testing.T) {
+	bus := newEventBus()
+	var mu sync.Mutex
+	got := map[string][]peekbt.EventType{}
+	record := func(name string) func(peekbt.Event) {
+		return func(ev peekbt.Event) {
+			mu.Lock()
+			got[name] = append(got[name], ev.Type)
+			mu.Unlock()
+		}
+	}
+	bus.subscribe("all", record("all"))
+	bus.subscribe("changes", record("changes"), peekbt.EventNew, peekbt.EventLost)
+	bus.start()
+	for _, typ := range []peekbt.EventType{peekbt.EventAdv, peekbt.EventNew, peekbt.EventAdv, peekbt.EventUpdate, peekbt.EventLost} {
+		bus.publish(peekbt.Event{Type: typ})
+	}
+	bus.close()
+
+	if len(got["all"]) != 5 || got["all"][3] != peekbt.EventUpdate {
+		t.Errorf("all: %v", got["all"])
+	}
+	if len(got["changes"]) != 2 || got["changes"][0] != peekbt.EventNew || got["changes"][1] != peekbt.EventLost {
+		t.Errorf("changes: %v", got["changes"])
+	}
+}
+
+/* ---------- 2. 遅い購読者は他を待たせない ---------- */
+func TestEventBus_Independent(t *testing.T) {
+	bus := newEventBus()
+	release := make(chan struct{})
+	fast := make(chan peekbt.Event, 10)
+	bus.subscribe("slow", func(peekbt.Event) { <-release })
+	bus.subscribe("fast", func(ev peekbt.Event) { fast <- ev })
+	bus.start()
+	for i := 0; i < 10; i++ {
+		bus.publish(peekbt.Event{Type: peekbt.EventAdv})
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case <-fast:
+		case <-time.After(time.Second):
+			t.Fatalf("fast subscriber blocked after %d events", i)
+		}
+	}
+	close(release)
+	bus.close()
+}
+
+/* ---------- 3. 複数の出力を同時に ---------- */
+func TestScanCommand_MultipleOutputs(t *testing.T) {
+	origScanner := DefaultScanner
+	defer func() {
+		DefaultScanner, replayMode, replayPath, replaySpeed = origScanner, false, "", "1x"
+		scanTime, scanJSON, scanCSV, scanRecord = 0, "", "", ""
+		scanOut.HTTP = ""
+	}()
+
+	dir := t.TempDir()
+	nd, csv, rec := filepath.Join(dir, "scan.ndjson"), filepath.Join(dir, "scan.csv"), filepath.Join(dir, "scan.pbt")
+	rootCommand.SetArgs([]string{"--replay", samplePcap(t), "--speed", "max",
+		"scan", "-t", "5", "--json", nd, "--csv", csv, "--record", rec, "--http", "127.0.0.1:0"})
+	if err := rootCommand.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	if evs := readEvents(t, nd); len(evs) != len(replaySamples) {
+		t.Errorf("NDJSON: %d events, want %d", len(evs), len(replaySamples))
+	}
+	if b, _ := os.ReadFile(csv); !strings.Contains(string(b), "f4:8c:50:01:02:03") {
+		t.Errorf("CSV missing device:\n%s", b)
+	}
+	if got, _ := readAll(t, rec); len(got) != len(replaySamples) {
+		t.Errorf("recording: %d advertisements, want %d", len(got), len(replaySamples))
+	}
+}
+
+/* ---------- 4. 溢れたイベントと close 後の publish ---------- */
+func TestEventBus_Overflow(t *testing.T) {
+	for _, lossless := range []bool{false, true} {
+		bus := newEventBus()
+		log := &syncBuffer{}
+		bus.log, bus.lossless = log, lossless
+		release := make(chan struct{})
+		var n atomic.Int64
+		bus.subscribe("slow", func(peekbt.Event) { <-release; n.Add(1) })
+		bus.start()
+
+		const total = busBuffer + 100
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for range total {
+				bus.publish(peekbt.Event{Type: peekbt.EventAdv})
+			}
+		}()
+		if !lossless {
+			// 遅い購読者がいても publish は待たない
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("publish blocked by a slow subscriber")
+			}
+		}
+		close(release)
+		<-done
+		bus.close()
+
+		// close の後に届いたイベント（Scan が戻った後の受信ハンドラ）は捨てる
+		bus.publish(peekbt.Event{Type: peekbt.EventAdv})
+
+		if lossless {
+			if n.Load() != total || log.String() != "" {
+				t.Errorf("lossless: handled %d of %d, log %q", n.Load(), total, log)
+			}
+			continue
+		}
+		dropped := bus.subs[0].dropped.Load()
+		if dropped == 0 || n.Load()+dropped != total {
+			t.Errorf("handled %d, dropped %d of %d", n.Load(), dropped, total)
+		}
+		if !strings.Contains(log.String(), "slow: dropped") {
+			t.Errorf("drops not reported: %q", log)
+		}
+	}
+}
+
+/* ---------- 5. 溢れても出現・消失は捨てない ---------- */
+func TestEventBus_OverflowKeepsChanges(t *testing.T) {
+	bus := newEventBus()
+	bus.log = &syncBuffer{}
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var got []peekbt.Event
+	bus.subscribe("slow", func(ev peekbt.Event) {
+		<-release
+		mu.Lock()
+		got = append(got, ev)
+		mu.Unlock()
+	})
+	bus.start()
+
+	// アドバタイズでキューを埋めた後に new / update / lost を流す
+	for range busBuffer + 10 {
+		bus.publish(peekbt.Event{Type: peekbt.EventAdv})
+	}
+	want := []peekbt.EventType{peekbt.EventNew, peekbt.EventUpdate, peekbt.EventLost}
+	for i, typ := range want {
+		bus.publish(peekbt.Event{Type: typ, Time: time.Unix(int64(i), 0)})
+		bus.publish(peekbt.Event{Type: peekbt.EventAdv})
+	}
+	close(release)
+	bus.close()
+
+	var changes []peekbt.EventType
+	for _, ev := range got {
+		if ev.Type != peekbt.EventAdv {
+			changes = append(changes, ev.Type)
+		}
+	}
+	if !slices.Equal(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+	if d := bus.subs[0].dropped.Load(); d != 10+int64(len(want)) {
+		t.Errorf("dropped %d advertisements, want %d", d, 10+len(want))
+	}
+}
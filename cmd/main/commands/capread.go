@@ -0,0 +1,297 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// capMaxPacket は pcap / btsnoop の 1 パケットの上限。壊れた長さで巨大な領域を確保しないようにします
+// （アドバタイズは HCI イベントでも 300 バイト未満）
+const capMaxPacket = 64 * 1024
+
+// advSource はキャプチャファイルからアドバタイズを順に読み出す口。
+// 終端では io.EOF を返します
+type advSource interface {
+	Next() (rawAdv, time.Time, error)
+	Close() error
+}
+
+// openAdvSource はファイル先頭のマジックから形式（btsnoop / pcap / .pbt）を判別して advSource を返します
+func openAdvSource(path string) (advSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture: %w", err)
+	}
+	r := bufio.NewReader(f)
+	magic, err := r.Peek(8)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read capture header: %w", err)
+	}
+
+	var src advSource
+	switch {
+	case bytes.Equal(magic, []byte("btsnoop\x00")):
+		src, err = newBtsnoopReader(f, r)
+	case isPcapMagic(magic[:4]):
+		src, err = newPcapReader(f, r)
+	case isPbtCandidate(magic):
+		src, err = newPbtReader(f, r)
+	default:
+		err = fmt.Errorf("unknown capture format: %s", path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+/* ---------- pcap ---------- */
+
+const (
+	linktypeBLELL     = 251 // LINKTYPE_BLUETOOTH_LE_LL
+	linktypeHCIH4PHDR = 201 // LINKTYPE_BLUETOOTH_HCI_H4_WITH_PHDR
+)
+
+// pcapReader は pcap から LL / HCI のアドバタイズを読み出します
+type pcapReader struct {
+	f        *os.File
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linktype uint32
+	pending  []rawAdv
+	pendTS   time.Time
+}
+
+func isPcapMagic(b []byte) bool {
+	switch binary.LittleEndian.Uint32(b) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return true
+	}
+	return false
+}
+
+func newPcapReader(f *os.File, r *bufio.Reader) (*pcapReader, error) {
+	h := make([]byte, 24)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, fmt.Errorf("failed to read pcap header: %w", err)
+	}
+	p := &pcapReader{f: f, r: r, order: binary.LittleEndian}
+	switch binary.LittleEndian.Uint32(h) {
+	case 0xd4c3b2a1:
+		p.order = binary.BigEndian
+	case 0xa1b23c4d:
+		p.nano = true
+	case 0x4d3cb2a1:
+		p.order, p.nano = binary.BigEndian, true
+	}
+	p.linktype = p.order.Uint32(h[20:])
+	switch p.linktype {
+	case linktypeBLELLWithPHDR, linktypeBLELL, linktypeHCIH4PHDR:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", p.linktype)
+	}
+	return p, nil
+}
+
+// Next は次のアドバタイズを返します。アドバタイズ以外のパケットは読み飛ばします
+func (p *pcapReader) Next() (rawAdv, time.Time, error) {
+	for {
+		if len(p.pending) > 0 {
+			a := p.pending[0]
+			p.pending = p.pending[1:]
+			return a, p.pendTS, nil
+		}
+
+		rec := make([]byte, 16)
+		if _, err := io.ReadFull(p.r, rec); err != nil {
+			return rawAdv{}, time.Time{}, eofOr(err)
+		}
+		frac := time.Duration(p.order.Uint32(rec[4:]))
+		if !p.nano {
+			frac *= time.Microsecond
+		}
+		ts := time.Unix(int64(p.order.Uint32(rec[0:])), int64(frac))
+		n := p.order.Uint32(rec[8:])
+		if n > capMaxPacket {
+			return rawAdv{}, time.Time{}, fmt.Errorf("corrupt pcap record: packet length %d exceeds %d", n, capMaxPacket)
+		}
+		pkt := make([]byte, n)
+		if _, err := io.ReadFull(p.r, pkt); err != nil {
+			return rawAdv{}, time.Time{}, eofOr(err)
+		}
+
+		switch p.linktype {
+		case linktypeBLELLWithPHDR:
+			if len(pkt) < 10 {
+				continue
+			}
+			var rssi int8
+			if binary.LittleEndian.Uint16(pkt[8:])&0x0002 != 0 {
+				rssi = int8(pkt[1])
+			}
+			if a, ok := parseLLPDU(pkt[10:], rssi); ok {
+				return a, ts, nil
+			}
+		case linktypeBLELL:
+			if a, ok := parseLLPDU(pkt, 0); ok {
+				return a, ts, nil
+			}
+		case linktypeHCIH4PHDR:
+			if len(pkt) < 4 {
+				continue
+			}
+			p.pending, p.pendTS = parseHCIEvent(pkt[4:]), ts
+		}
+	}
+}
+
+// Close はファイルを閉じます
+func (p *pcapReader) Close() error { return p.f.Close() }
+
+// parseLLPDU はアドバタイジングチャネルの LL パケットを rawAdv に変換します
+func parseLLPDU(b []byte, rssi int8) (rawAdv, bool) {
+	if len(b) < 4+2+6 || binary.LittleEndian.Uint32(b) != 0x8E89BED6 {
+		return rawAdv{}, false
+	}
+	hdr, n := b[4], int(b[5])
+	if len(b) < 6+n || n < 6 {
+		return rawAdv{}, false
+	}
+	var evt uint8
+	switch hdr & 0x0F {
+	case 0x0:
+		evt = evtAdvInd
+	case 0x1:
+		evt = evtAdvDirectInd
+	case 0x2:
+		evt = evtAdvNonconnInd
+	case 0x4:
+		evt = evtScanRsp
+	case 0x6:
+		evt = evtAdvScanInd
+	default:
+		return rawAdv{}, false // SCAN_REQ / CONNECT_IND など
+	}
+	payload := b[6 : 6+n]
+	a := rawAdv{EventType: evt, AddrType: (hdr >> 6) & 0x01, RSSI: rssi}
+	for i := 0; i < 6; i++ {
+		a.Addr[i] = payload[5-i]
+	}
+	if evt != evtAdvDirectInd {
+		a.Data = append([]byte{}, payload[6:]...)
+	}
+	return a, true
+}
+
+/* ---------- btsnoop ---------- */
+
+const btsnoopDatalinkHCI = 1001 // H4 の種別バイトなし
+
+// btsnoopReader は btsnoop から LE Advertising Report を読み出します
+type btsnoopReader struct {
+	f        *os.File
+	r        *bufio.Reader
+	datalink uint32
+	pending  []rawAdv
+	pendTS   time.Time
+}
+
+func newBtsnoopReader(f *os.File, r *bufio.Reader) (*btsnoopReader, error) {
+	h := make([]byte, 16)
+	if _, err := io.ReadFull(r, h); err != nil {
+		return nil, fmt.Errorf("failed to read btsnoop header: %w", err)
+	}
+	b := &btsnoopReader{f: f, r: r, datalink: binary.BigEndian.Uint32(h[12:])}
+	if b.datalink != btsnoopDatalinkH4 && b.datalink != btsnoopDatalinkHCI {
+		return nil, fmt.Errorf("unsupported btsnoop datalink %d", b.datalink)
+	}
+	return b, nil
+}
+
+// Next は次のアドバタイズを返します。アドバタイズ以外のレコードは読み飛ばします
+func (b *btsnoopReader) Next() (rawAdv, time.Time, error) {
+	for len(b.pending) == 0 {
+		rec := make([]byte, 24)
+		if _, err := io.ReadFull(b.r, rec); err != nil {
+			return rawAdv{}, time.Time{}, eofOr(err)
+		}
+		n := binary.BigEndian.Uint32(rec[4:])
+		if n > capMaxPacket {
+			return rawAdv{}, time.Time{}, fmt.Errorf("corrupt btsnoop record: packet length %d exceeds %d", n, capMaxPacket)
+		}
+		pkt := make([]byte, n)
+		if _, err := io.ReadFull(b.r, pkt); err != nil {
+			return rawAdv{}, time.Time{}, eofOr(err)
+		}
+		flags := binary.BigEndian.Uint32(rec[8:])
+		us := int64(binary.BigEndian.Uint64(rec[16:])) - btsnoopEpochDelta
+		b.pendTS = time.UnixMicro(us)
+
+		if b.datalink == btsnoopDatalinkHCI {
+			if flags != 0x03 { // 受信イベント以外
+				continue
+			}
+			pkt = append([]byte{0x04}, pkt...)
+		}
+		b.pending = parseHCIEvent(pkt)
+	}
+	a := b.pending[0]
+	b.pending = b.pending[1:]
+	return a, b.pendTS, nil
+}
+
+// Close はファイルを閉じます
+func (b *btsnoopReader) Close() error { return b.f.Close() }
+
+// parseHCIEvent は H4 形式の LE Advertising Report を rawAdv の列に変換します。
+// 複数レポートの場合、各パラメータは仕様どおり配列ごとに並んでいるものとして解釈します。
+// それ以外のパケットでは nil を返します
+func parseHCIEvent(b []byte) []rawAdv {
+	if len(b) < 5 || b[0] != 0x04 || b[1] != 0x3E || b[3] != 0x02 {
+		return nil
+	}
+	n := int(b[4])
+	p := b[5:]
+	if len(p) < 9*n {
+		return nil
+	}
+	advs := make([]rawAdv, n)
+	data := p[9*n:]
+	for i := range advs {
+		a := &advs[i]
+		a.EventType, a.AddrType = p[i], p[n+i]
+		addr := p[2*n+6*i:]
+		for j := 0; j < 6; j++ {
+			a.Addr[j] = addr[5-j]
+		}
+		l := int(p[8*n+i])
+		if len(data) < l {
+			return nil
+		}
+		a.Data = append([]byte{}, data[:l]...)
+		data = data[l:]
+	}
+	if len(data) < n {
+		return nil
+	}
+	for i := range advs {
+		advs[i].RSSI = int8(data[i])
+	}
+	return advs
+}
+
+// eofOr は途中で切れたレコードも含め終端を io.EOF に揃えます
+func eofOr(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return io.EOF
+	}
+	return err
+}
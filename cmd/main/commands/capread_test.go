@@ -0,0 +1,156 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var replaySamples = []rawAdv{
+	{EventType: evtAdvInd, AddrType: 0x00, Addr: [6]byte{0xf4, 0x8c, 0x50, 1, 2, 3}, RSSI: -40, Data: []byte{0x05, 0x09, 'T', 'i', 'l', 'e'}},
+	{EventType: evtAdvNonconnInd, AddrType: 0x01, Addr: [6]byte{0xc0, 1, 2, 3, 4, 5}, RSSI: -80, Data: []byte{0x03, 0xFF, 0x4c, 0x00}},
+	{EventType: evtScanRsp, AddrType: 0x00, Addr: [6]byte{0xf4, 0x8c, 0x50, 1, 2, 3}, RSSI: -41, Data: []byte{0x03, 0x03, 0x0f, 0x18}},
+}
+
+// writeSampleCapture は replaySamples を 100ms 間隔で w に書き込みます
+func writeSampleCapture(t *testing.T, w captureWriter) {
+	t.Helper()
+	ts := time.Unix(1700000000, 0)
+	for i, r := range replaySamples {
+		if err := w.WriteAdv(r, ts.Add(time.Duration(i)*100*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAll(t *testing.T, path string) ([]rawAdv, []time.Time) {
+	t.Helper()
+	src, err := openAdvSource(path)
+	if err != nil {
+		t.Fatalf("openAdvSource: %v", err)
+	}
+	defer src.Close()
+	var advs []rawAdv
+	var tss []time.Time
+	for {
+		a, ts, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return advs, tss
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		advs = append(advs, a)
+		tss = append(tss, ts)
+	}
+}
+
+func assertSamples(t *testing.T, got []rawAdv, tss []time.Time) {
+	t.Helper()
+	if len(got) != len(replaySamples) {
+		t.Fatalf("read %d advertisements, want %d", len(got), len(replaySamples))
+	}
+	for i, want := range replaySamples {
+		g := got[i]
+		if g.EventType != want.EventType || g.AddrType != want.AddrType || g.Addr != want.Addr || g.RSSI != want.RSSI || !bytes.Equal(g.Data, want.Data) {
+			t.Errorf("record %d: got %+v, want %+v", i, g, want)
+		}
+	}
+	if d := tss[1].Sub(tss[0]); d != 100*time.Millisecond {
+		t.Errorf("timestamp delta %v, want 100ms", d)
+	}
+}
+
+/* ---------- 1. pcap の往復 ---------- */
+func TestPcapReader_RoundTrip(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "a.pcap")
+	w, err := newPcapWriter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSampleCapture(t, w)
+	got, tss := readAll(t, p)
+	assertSamples(t, got, tss)
+}
+
+/* ---------- 2. btsnoop の往復 ---------- */
+func TestBtsnoopReader_RoundTrip(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "a.btsnoop")
+	w, err := newBtsnoopWriter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSampleCapture(t, w)
+	got, tss := readAll(t, p)
+	assertSamples(t, got, tss)
+}
+
+/* ---------- 3. 複数レポートの HCI イベント ---------- */
+func TestParseHCIEvent_MultipleReports(t *testing.T) {
+	ev := []byte{0x04, 0x3E, 0, 0x02, 2,
+		0x00, 0x03, // event types
+		0x00, 0x01, // address types
+		1, 2, 3, 4, 5, 6, 6, 5, 4, 3, 2, 1, // addresses
+		1, 0, // data lengths
+		0xAA,       // data
+		0xC0, 0xB0, // RSSI
+	}
+	ev[2] = byte(len(ev) - 3)
+	advs := parseHCIEvent(ev)
+	if len(advs) != 2 {
+		t.Fatalf("got %d reports, want 2", len(advs))
+	}
+	if advs[0].Addr != [6]byte{6, 5, 4, 3, 2, 1} || !bytes.Equal(advs[0].Data, []byte{0xAA}) || advs[0].RSSI != -64 {
+		t.Errorf("report 0 mismatch: %+v", advs[0])
+	}
+	if advs[1].EventType != 0x03 || advs[1].AddrType != 0x01 || len(advs[1].Data) != 0 || advs[1].RSSI != -80 {
+		t.Errorf("report 1 mismatch: %+v", advs[1])
+	}
+}
+
+/* ---------- 4. 不明な形式 ---------- */
+func TestOpenAdvSource_Unknown(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "x.bin")
+	os.WriteFile(p, []byte("not a capture file"), 0o644)
+	if _, err := openAdvSource(p); err == nil {
+		t.Fatalf("expected error on unknown format")
+	}
+
+	// 対応外のリンクタイプ
+	h := make([]byte, 24)
+	binary.LittleEndian.PutUint32(h, 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(h[20:], 1)
+	os.WriteFile(p, h, 0o644)
+	if _, err := openAdvSource(p); err == nil {
+		t.Fatalf("expected error on unsupported link type")
+	}
+}
+
+/* ---------- 5. 壊れたパケット長 ---------- */
+func TestPcapReader_CorruptLength(t *testing.T) {
+	p := samplePcap(t)
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 最初のレコードの incl_len を巨大な値にする
+	binary.LittleEndian.PutUint32(data[24+8:], 0xfffffff0)
+	os.WriteFile(p, data, 0o644)
+	src, err := openAdvSource(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	if _, _, err := src.Next(); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("want corrupt record error, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// linktypeBLELLWithPHDR は LINKTYPE_BLUETOOTH_LE_LL_WITH_PHDR
+	linktypeBLELLWithPHDR = 256
+	// btsnoopDatalinkH4 は btsnoop の HCI UART (H4) データリンク
+	btsnoopDatalinkH4 = 1002
+	// btsnoopEpochDelta は 0000-01-01 から 1970-01-01 までのマイクロ秒
+	btsnoopEpochDelta = 0x00dcddb30f2f8000
+)
+
+// captureWriter はアドバタイズをキャプチャファイルに書き出す口
+type captureWriter interface {
+	WriteAdv(r rawAdv, ts time.Time) error
+	Close() error
+}
+
+// captureFile は書き込み排他とバッファリングを共通化したファイル
+type captureFile struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *bufio.Writer
+}
+
+func createCaptureFile(path string, header []byte) (*captureFile, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+	c := &captureFile{f: f, w: bufio.NewWriter(f)}
+	if _, err := c.w.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write capture header: %w", err)
+	}
+	return c, nil
+}
+
+func (c *captureFile) write(parts ...[]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range parts {
+		if _, err := c.w.Write(p); err != nil {
+			return fmt.Errorf("failed to write capture record: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close はバッファをフラッシュしてファイルを閉じます
+func (c *captureFile) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.w.Flush(); err != nil {
+		c.f.Close()
+		return fmt.Errorf("failed to write capture file: %w", err)
+	}
+	return c.f.Close()
+}
+
+/* ---------- pcap ---------- */
+
+// pcapWriter は LINKTYPE_BLUETOOTH_LE_LL_WITH_PHDR の pcap を書き出します
+type pcapWriter struct {
+	*captureFile
+}
+
+// newPcapWriter は pcap のグローバルヘッダを書き込んで writer を返します
+func newPcapWriter(path string) (*pcapWriter, error) {
+	h := make([]byte, 24)
+	binary.LittleEndian.PutUint32(h[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(h[4:], 2)
+	binary.LittleEndian.PutUint16(h[6:], 4)
+	binary.LittleEndian.PutUint32(h[16:], 65535)
+	binary.LittleEndian.PutUint32(h[20:], linktypeBLELLWithPHDR)
+	c, err := createCaptureFile(path, h)
+	if err != nil {
+		return nil, err
+	}
+	return &pcapWriter{c}, nil
+}
+
+// WriteAdv は疑似ヘッダ付きの LL パケットを 1 レコードとして書き込みます
+func (p *pcapWriter) WriteAdv(r rawAdv, ts time.Time) error {
+	// 疑似ヘッダ: RF Channel, Signal Power, Noise Power, AA Offenses, Ref AA, Flags
+	// チャネルは HCI から得られないため 0 (= ch37) とし、de-whitened | signal valid を立てます
+	phdr := make([]byte, 10)
+	phdr[1] = byte(r.RSSI)
+	binary.LittleEndian.PutUint16(phdr[8:], 0x0003)
+	pkt := append(phdr, r.llPDU()...)
+
+	rec := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec[0:], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(pkt)))
+	return p.write(rec, pkt)
+}
+
+/* ---------- btsnoop ---------- */
+
+// btsnoopWriter は HCI H4 の btsnoop を書き出します
+type btsnoopWriter struct {
+	*captureFile
+}
+
+// newBtsnoopWriter は btsnoop のファイルヘッダを書き込んで writer を返します
+func newBtsnoopWriter(path string) (*btsnoopWriter, error) {
+	h := append([]byte("btsnoop\x00"), 0, 0, 0, 1)
+	h = binary.BigEndian.AppendUint32(h, btsnoopDatalinkH4)
+	c, err := createCaptureFile(path, h)
+	if err != nil {
+		return nil, err
+	}
+	return &btsnoopWriter{c}, nil
+}
+
+// WriteAdv は LE Advertising Report イベントを受信方向のレコードとして書き込みます
+func (b *btsnoopWriter) WriteAdv(r rawAdv, ts time.Time) error {
+	pkt := r.hciEvent()
+	rec := make([]byte, 24)
+	binary.BigEndian.PutUint32(rec[0:], uint32(len(pkt)))
+	binary.BigEndian.PutUint32(rec[4:], uint32(len(pkt)))
+	binary.BigEndian.PutUint32(rec[8:], 0x03) // received | event
+	binary.BigEndian.PutUint64(rec[16:], uint64(ts.UnixMicro()+btsnoopEpochDelta))
+	return b.write(rec, pkt)
+}
+
+// openCaptures は --pcap / --btsnoop の出力を開きます（未指定のものは含みません）
+func openCaptures(pcapPath, btsnoopPath string) ([]captureWriter, error) {
+	var ws []captureWriter
+	if pcapPath != "" {
+		w, err := newPcapWriter(pcapPath)
+		if err != nil {
+			return nil, err
+		}
+		ws = append(ws, w)
+	}
+	if btsnoopPath != "" {
+		w, err := newBtsnoopWriter(btsnoopPath)
+		if err != nil {
+			closeCaptures(ws)
+			return nil, err
+		}
+		ws = append(ws, w)
+	}
+	return ws, nil
+}
+
+// closeCaptures はすべてのキャプチャを閉じ、最初のエラーを返します
+func closeCaptures(ws []captureWriter) error {
+	var first error
+	for _, w := range ws {
+		if err := w.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var sampleRaw = rawAdv{EventType: evtAdvInd, Addr: [6]byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, RSSI: -60, Data: []byte{0x02, 0x01, 0x06}}
+
+/* ---------- 1. pcap ---------- */
+func TestPcapWriter(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "out.pcap")
+	w, err := newPcapWriter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 123456000)
+	w.WriteAdv(sampleRaw, ts)
+	w.WriteAdv(sampleRaw, ts)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(p)
+	if binary.LittleEndian.Uint32(b[0:]) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(b[20:]) != linktypeBLELLWithPHDR {
+		t.Fatalf("bad pcap header: % x", b[:24])
+	}
+	rec := b[24:]
+	if binary.LittleEndian.Uint32(rec[0:]) != 1700000000 || binary.LittleEndian.Uint32(rec[4:]) != 123456 {
+		t.Fatalf("bad record timestamp: % x", rec[:8])
+	}
+	n := binary.LittleEndian.Uint32(rec[8:])
+	if int(n) != 10+len(sampleRaw.llPDU()) || int8(rec[16+1]) != -60 {
+		t.Fatalf("bad record: len=%d phdr=% x", n, rec[16:26])
+	}
+	if len(b) != 24+2*(16+int(n)) {
+		t.Fatalf("file size %d does not match 2 records", len(b))
+	}
+}
+
+/* ---------- 2. btsnoop ---------- */
+func TestBtsnoopWriter(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "out.btsnoop")
+	w, err := newBtsnoopWriter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Unix(1700000000, 0)
+	w.WriteAdv(sampleRaw, ts)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(p)
+	if !bytes.Equal(b[:8], []byte("btsnoop\x00")) || binary.BigEndian.Uint32(b[12:]) != btsnoopDatalinkH4 {
+		t.Fatalf("bad btsnoop header: % x", b[:16])
+	}
+	rec := b[16:]
+	pkt := sampleRaw.hciEvent()
+	if binary.BigEndian.Uint32(rec[0:]) != uint32(len(pkt)) || binary.BigEndian.Uint32(rec[8:]) != 0x03 {
+		t.Fatalf("bad record header: % x", rec[:24])
+	}
+	if got := int64(binary.BigEndian.Uint64(rec[16:])) - btsnoopEpochDelta; got != ts.UnixMicro() {
+		t.Fatalf("timestamp %d, want %d", got, ts.UnixMicro())
+	}
+	if !bytes.Equal(rec[24:], pkt) {
+		t.Fatalf("packet mismatch: % x", rec[24:])
+	}
+}
+
+/* ---------- 3. openCaptures ---------- */
+func TestOpenCaptures(t *testing.T) {
+	dir := t.TempDir()
+	ws, err := openCaptures(filepath.Join(dir, "a.pcap"), filepath.Join(dir, "a.btsnoop"))
+	if err != nil || len(ws) != 2 {
+		t.Fatalf("openCaptures: %d writers, %v", len(ws), err)
+	}
+	if err := closeCaptures(ws); err != nil {
+		t.Fatal(err)
+	}
+	if ws, _ := openCaptures("", ""); len(ws) != 0 {
+		t.Fatalf("no paths should open no writers")
+	}
+}
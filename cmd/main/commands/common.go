@@ -2,7 +2,11 @@ package commands
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/go-ble/ble"
@@ -11,23 +15,83 @@ import (
 
 var defaultDev ble.Device
 
-// InitDefaultAdapter は一度だけ linux.NewDevice() を呼び出し、以後は再利用します。
+var (
+	// hciDevice は --hci で指定したアダプタ番号（-1 なら hci0 から順に使えるものを選ぶ）
+	hciDevice = -1
+	// adapterIndex は開いたアダプタの番号（未初期化・再生中は -1）
+	adapterIndex = -1
+)
+
+// hciMaxDevices は自動選択で試すアダプタ番号の上限（カーネルの HCI_MAX_DEV と同じ）
+const hciMaxDevices = 16
+
+// replayMode は --replay によりアダプタを使わず再生している状態
+var replayMode bool
+
+// InitDefaultAdapter は一度だけアダプタを開き、以後は再利用します。
+// 開いたアダプタの番号は adapterIndex に残します。再生モードではアダプタを開かず nil を返します
 func InitDefaultAdapter() (ble.Device, error) {
+	if replayMode {
+		return nil, nil
+	}
 	// すでに作成済みなら再利用
 	if defaultDev != nil {
 		return defaultDev, nil
 	}
 
 	// 初回のみアダプタを生成
-	dev, err := linux.NewDevice()
+	dev, id, err := openAdapter(hciDevice)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize BLE device: %w", err)
 	}
 	ble.SetDefaultDevice(dev)
-	defaultDev = dev
+	defaultDev, adapterIndex = dev, id
 	return dev, nil
 }
 
+// openAdapter は番号 id のアダプタを開きます。
+// id が負なら hci0 から順に試し、最初に開けたアダプタとその番号を返します
+func openAdapter(id int) (*linux.Device, int, error) {
+	if id >= 0 {
+		dev, err := linux.NewDevice(ble.OptDeviceID(id))
+		if err != nil {
+			return nil, -1, fmt.Errorf("hci%d: %w", id, err)
+		}
+		return dev, id, nil
+	}
+	var errs []error
+	for id := range hciMaxDevices {
+		dev, err := linux.NewDevice(ble.OptDeviceID(id))
+		if err == nil {
+			return dev, id, nil
+		}
+		// 存在しない番号は報告しない
+		if !isNoDevice(err) {
+			errs = append(errs, fmt.Errorf("hci%d: %w", id, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil, -1, errors.New("no HCI adapter found")
+	}
+	return nil, -1, errors.Join(errs...)
+}
+
+// isNoDevice は err が存在しないアダプタを開こうとした失敗かを返します
+// （go-ble は pkg/errors で包むため Unwrap ではなく Cause を辿る）
+func isNoDevice(err error) bool {
+	for err != nil {
+		if errors.Is(err, syscall.ENODEV) {
+			return true
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
 // NewTimeoutCtx 秒指定で Context を生成（0 ならキャンセル型）
 func NewTimeoutCtx(seconds int) (context.Context, context.CancelFunc) {
 	if seconds == 0 {
@@ -35,3 +99,9 @@ func NewTimeoutCtx(seconds int) (context.Context, context.CancelFunc) {
 	}
 	return context.WithTimeout(context.Background(), time.Duration(seconds)*time.Second)
 }
+
+// withInterrupt は SIGINT / SIGTERM でキャンセルされる Context を返します。
+// 中断時もスキャン処理を通常終了させ、出力ファイルを確実にフラッシュするために使います
+func withInterrupt(ctx context.Context) (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+}
@@ -0,0 +1,207 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// CSV 出力の粒度
+const (
+	csvModeObservation = "obs"    // アドバタイズ毎に 1 行
+	csvModeDevice      = "device" // 終了時にデバイス毎の集計を 1 行
+)
+
+var (
+	csvObservationHeader = []string{"timestamp", "address", "address_type", "name", "rssi", "vendor", "services"}
+	csvDeviceHeader      = []string{"address", "address_type", "name", "rssi_last", "rssi_min", "rssi_max", "rssi_avg", "count", "vendor", "services", "first_seen", "last_seen"}
+)
+
+// csvDevice はデバイス毎の集計値
+type csvDevice struct {
+	info              peekbt.DeviceInfo
+	vendor            string
+	rssiMin, rssiMax  int
+	rssiSum, count    int
+	firstSeen, lastAt time.Time
+}
+
+// rowWriter は CSV / TSV の 1 行書き込み口
+type rowWriter interface {
+	Write(record []string) error
+	Flush()
+	Error() error
+}
+
+// tsvWriter はクォートを行わず、区切り文字と改行を空白に置き換える TSV 書き込み口
+type tsvWriter struct {
+	w   *bufio.Writer
+	err error // 最初に発生した書き込みエラー
+}
+
+var tsvEscaper = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// Write は 1 行書き込みます。bufio.Writer のエラーは持続するため行末の書き込みで検出できます
+func (t *tsvWriter) Write(record []string) error {
+	if t.err != nil {
+		return t.err
+	}
+	for i, f := range record {
+		if i > 0 {
+			t.w.WriteByte('\t')
+		}
+		t.w.WriteString(tsvEscaper.Replace(f))
+	}
+	if err := t.w.WriteByte('\n'); err != nil {
+		t.err = err
+	}
+	return t.err
+}
+
+func (t *tsvWriter) Flush() {
+	if err := t.w.Flush(); err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+// Error は Write / Flush で発生した最初のエラーを返します
+func (t *tsvWriter) Error() error { return t.err }
+
+// csvExporter はスキャン結果を CSV（RFC 4180）または TSV で書き出します。
+// device モードの集計行はデバイスがテーブルから消えたとき（Lost）か終了時に書き出し、
+// 保持するのはテーブルにいるデバイスだけです
+type csvExporter struct {
+	mu      sync.Mutex
+	file    *os.File
+	w       rowWriter
+	mode    string
+	devices map[string]*csvDevice
+}
+
+// newCSVExporter は path に出力するエクスポータを作り、ヘッダ行を書き込みます
+func newCSVExporter(path, mode string, tsv bool) (*csvExporter, error) {
+	if mode != csvModeObservation && mode != csvModeDevice {
+		return nil, fmt.Errorf("invalid CSV mode %q (want %s or %s)", mode, csvModeObservation, csvModeDevice)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSV file: %w", err)
+	}
+	var w rowWriter
+	if tsv {
+		w = &tsvWriter{w: bufio.NewWriter(f)}
+	} else {
+		w = csv.NewWriter(f)
+	}
+	e := &csvExporter{file: f, w: w, mode: mode, devices: make(map[string]*csvDevice)}
+
+	header := csvDeviceHeader
+	if mode == csvModeObservation {
+		header = csvObservationHeader
+	}
+	if err := w.Write(header); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return e, nil
+}
+
+// Advertisement は受信したアドバタイズを記録します（e が nil なら何もしません）
+func (e *csvExporter) Advertisement(a ble.Advertisement, now time.Time) error {
+	if e == nil {
+		return nil
+	}
+	info := peekbt.NewDeviceInfo(a)
+	vendor := peekbt.CompanyName(peekbt.CompanyID(a))
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.mode == csvModeObservation {
+		return e.w.Write([]string{
+			now.Format(time.RFC3339Nano), info.Address, info.AddressType, info.Name,
+			strconv.Itoa(info.RSSI), vendor, strings.Join(info.ServicesUUID, " "),
+		})
+	}
+
+	d, ok := e.devices[info.Address]
+	if !ok {
+		d = &csvDevice{rssiMin: info.RSSI, rssiMax: info.RSSI, firstSeen: now}
+		e.devices[info.Address] = d
+	}
+	// 名前・ベンダ・サービスは空で上書きしない（スキャンレスポンスにしか載らない場合がある）
+	if info.Name == "" {
+		info.Name = d.info.Name
+	}
+	if len(info.ServicesUUID) == 0 {
+		info.ServicesUUID = d.info.ServicesUUID
+	}
+	if vendor != "" {
+		d.vendor = vendor
+	}
+	d.info = info
+	d.rssiMin = min(d.rssiMin, info.RSSI)
+	d.rssiMax = max(d.rssiMax, info.RSSI)
+	d.rssiSum += info.RSSI
+	d.count++
+	d.lastAt = now
+	return nil
+}
+
+// Lost はテーブルから消えたデバイスの集計行を書き出し、集計を破棄します（device モードのみ）
+func (e *csvExporter) Lost(addr string) error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	d, ok := e.devices[addr]
+	if !ok {
+		return nil
+	}
+	delete(e.devices, addr)
+	if err := e.w.Write(d.row()); err != nil {
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return nil
+}
+
+// Close は残っている集計行を最初の受信順に書き出してファイルをフラッシュ・クローズします
+func (e *csvExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	devs := make([]*csvDevice, 0, len(e.devices))
+	for _, d := range e.devices {
+		devs = append(devs, d)
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i].firstSeen.Before(devs[j].firstSeen) })
+	for _, d := range devs {
+		e.w.Write(d.row())
+	}
+	e.devices = nil
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		e.file.Close()
+		return fmt.Errorf("failed to write CSV: %w", err)
+	}
+	return e.file.Close()
+}
+
+// row は集計値を csvDeviceHeader の並びにします
+func (d *csvDevice) row() []string {
+	return []string{
+		d.info.Address, d.info.AddressType, d.info.Name,
+		strconv.Itoa(d.info.RSSI), strconv.Itoa(d.rssiMin), strconv.Itoa(d.rssiMax),
+		strconv.FormatFloat(float64(d.rssiSum)/float64(d.count), 'f', 1, 64),
+		strconv.Itoa(d.count), d.vendor, strings.Join(d.info.ServicesUUID, " "),
+		d.firstSeen.Format(time.RFC3339), d.lastAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+/* ---------- 1. デバイス毎の集計 ---------- */
+func TestCSVExporter_Device(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "scan.csv")
+	e, err := newCSVExporter(p, csvModeDevice, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	mk := func(name string, rssi int) ble.Advertisement {
+		return filterAdv{stubAdv: stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff"), name: name, rssi: rssi}, mfg: []byte{0x4c, 0x00}}
+	}
+	e.Advertisement(mk(`Tile, "Mate"`, -40), now)
+	e.Advertisement(mk("", -60), now.Add(time.Second))
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := os.Open(p)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+	if len(rows) != 2 || rows[0][0] != "address" {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+	got := rows[1]
+	want := map[int]string{0: "aa:bb:cc:dd:ee:ff", 2: `Tile, "Mate"`, 3: "-60", 4: "-60", 5: "-40", 6: "-50.0", 7: "2", 8: "Apple"}
+	for i, w := range want {
+		if got[i] != w {
+			t.Errorf("column %s = %q, want %q", csvDeviceHeader[i], got[i], w)
+		}
+	}
+}
+
+/* ---------- 2. 観測毎の TSV ---------- */
+func TestCSVExporter_ObservationTSV(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "scan.tsv")
+	e, err := newCSVExporter(p, csvModeObservation, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adv := stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff"), name: "a\tb", rssi: -40}
+	e.Advertisement(adv, time.Now())
+	e.Advertisement(adv, time.Now())
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(p)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), b)
+	}
+	cols := strings.Split(lines[1], "\t")
+	if len(cols) != len(csvObservationHeader) || cols[3] != "a b" {
+		t.Fatalf("unexpected TSV row: %q", lines[1])
+	}
+}
+
+/* ---------- 3. 不正なモード ---------- */
+func TestCSVExporter_InvalidMode(t *testing.T) {
+	if _, err := newCSVExporter(filepath.Join(t.TempDir(), "x.csv"), "rows", false); err == nil {
+		t.Fatalf("expected error on invalid mode")
+	}
+}
+
+/* ---------- 4. Lost で集計行を書き出して破棄 ---------- */
+func TestCSVExporter_Lost(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "scan.csv")
+	e, err := newCSVExporter(p, csvModeDevice, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	e.Advertisement(stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:01"), rssi: -40}, now)
+	e.Advertisement(stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:02"), rssi: -50}, now.Add(time.Second))
+	if err := e.Lost("aa:bb:cc:dd:ee:01"); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.devices) != 1 {
+		t.Fatalf("lost device should be dropped from the aggregate, %d left", len(e.devices))
+	}
+	// 再び現れたデバイスは新しい行になる
+	e.Advertisement(stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:01"), rssi: -60}, now.Add(2*time.Second))
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, _ := os.Open(p)
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range rows[1:] {
+		got = append(got, r[0]+" "+r[3])
+	}
+	want := []string{"aa:bb:cc:dd:ee:01 -40", "aa:bb:cc:dd:ee:02 -50", "aa:bb:cc:dd:ee:01 -60"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("rows %v, want %v", got, want)
+	}
+}
+
+/* ---------- 5. TSV の書き込みエラー ---------- */
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, os.ErrClosed }
+
+func TestTSVWriter_Error(t *testing.T) {
+	w := &tsvWriter{w: bufio.NewWriterSize(failWriter{}, 16)}
+	if err := w.Error(); err != nil {
+		t.Fatalf("unexpected error before writing: %v", err)
+	}
+	w.Write([]string{"aa:bb:cc:dd:ee:ff", "a long enough row to overflow the buffer"})
+	w.Flush()
+	if !errors.Is(w.Error(), os.ErrClosed) {
+		t.Fatalf("Error() = %v, want the underlying write error", w.Error())
+	}
+}
@@ -0,0 +1,518 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// filterExpr はパース済みのフィルタ式。Match でアドバタイズを評価します。
+//
+// 例: rssi > -70 && name =~ "^Tile" && company == 0x004C && has(service, "180f") && connectable
+type filterExpr struct {
+	src string
+	fn  func(ble.Advertisement) bool
+}
+
+// Match はアドバタイズが式を満たすかを返します
+func (f *filterExpr) Match(a ble.Advertisement) bool {
+	return f.fn(a)
+}
+
+// String は元の式を返します
+func (f *filterExpr) String() string {
+	return f.src
+}
+
+// FilterError は式の構文・型エラーを位置付きで表します
+type FilterError struct {
+	Expr string
+	Pos  int
+	Msg  string
+}
+
+// Error は式と ^ による位置マーカーを含むメッセージを返します
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("invalid filter expression: %s at position %d\n  %s\n  %s^",
+		e.Msg, e.Pos+1, e.Expr, strings.Repeat(" ", e.Pos))
+}
+
+// parseFilter はフィルタ式をパースします。空文字列なら nil を返します
+func parseFilter(src string) (*filterExpr, error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, nil
+	}
+	toks, err := lexFilter(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{src: src, toks: toks}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t.pos, "unexpected %s", t)
+	}
+	if n.typ != typBool {
+		return nil, p.errorf(n.pos, "expression must be boolean, got %s", n.typ)
+	}
+	return &filterExpr{src: src, fn: n.boolFn}, nil
+}
+
+/* ---------- 字句解析 ---------- */
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokInt
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokKind
+	pos  int
+	text string
+	num  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// filterOps は長いものから順に並べた演算子
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!"}
+
+func lexFilter(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(':
+			toks = append(toks, token{kind: tokLParen, pos: i, text: "("})
+			i++
+		case c == ')':
+			toks = append(toks, token{kind: tokRParen, pos: i, text: ")"})
+			i++
+		case c == ',':
+			toks = append(toks, token{kind: tokComma, pos: i, text: ","})
+			i++
+		case c == '"':
+			start := i
+			i++
+			var sb strings.Builder
+			for i < len(src) && src[i] != '"' {
+				// \" と \\ 以外のバックスラッシュは正規表現のエスケープとして残す
+				if src[i] == '\\' && i+1 < len(src) && (src[i+1] == '"' || src[i+1] == '\\') {
+					i++
+				}
+				sb.WriteByte(src[i])
+				i++
+			}
+			if i >= len(src) {
+				return nil, &FilterError{Expr: src, Pos: start, Msg: "unterminated string"}
+			}
+			i++
+			toks = append(toks, token{kind: tokString, pos: start, text: sb.String()})
+		case c == '-' || (c >= '0' && c <= '9'):
+			start := i
+			i++
+			for i < len(src) && (isIdentChar(src[i])) {
+				i++
+			}
+			text := src[start:i]
+			n, err := strconv.ParseInt(text, 0, 64)
+			if err != nil {
+				return nil, &FilterError{Expr: src, Pos: start, Msg: fmt.Sprintf("invalid number %q", text)}
+			}
+			toks = append(toks, token{kind: tokInt, pos: start, text: text, num: int(n)})
+		case isIdentChar(c):
+			start := i
+			for i < len(src) && isIdentChar(src[i]) {
+				i++
+			}
+			toks = append(toks, token{kind: tokIdent, pos: start, text: src[start:i]})
+		default:
+			op := ""
+			for _, o := range filterOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &FilterError{Expr: src, Pos: i, Msg: fmt.Sprintf("unexpected character %q", c)}
+			}
+			toks = append(toks, token{kind: tokOp, pos: i, text: op})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+/* ---------- 構文解析 ---------- */
+
+type valType int
+
+const (
+	typBool valType = iota
+	typInt
+	typString
+	typList
+)
+
+func (t valType) String() string {
+	return [...]string{"bool", "int", "string", "list"}[t]
+}
+
+// node は型付きの式ノード。typ に応じた関数のみが設定されます
+type node struct {
+	typ    valType
+	pos    int
+	lit    *token // リテラルの場合のみ
+	boolFn func(ble.Advertisement) bool
+	intFn  func(ble.Advertisement) int
+	strFn  func(ble.Advertisement) string
+	listFn func(ble.Advertisement) []string
+}
+
+type filterParser struct {
+	src  string
+	toks []token
+	i    int
+}
+
+func (p *filterParser) peek() token { return p.toks[p.i] }
+
+func (p *filterParser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *filterParser) errorf(pos int, format string, args ...any) error {
+	return &FilterError{Expr: p.src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) expectBool(n *node) error {
+	if n.typ != typBool {
+		return p.errorf(n.pos, "expected boolean, got %s", n.typ)
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (*node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && t.text == "||"; t = p.peek() {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectBool(left); err != nil {
+			return nil, err
+		}
+		if err := p.expectBool(right); err != nil {
+			return nil, err
+		}
+		l, r := left.boolFn, right.boolFn
+		left = &node{typ: typBool, pos: left.pos, boolFn: func(a ble.Advertisement) bool { return l(a) || r(a) }}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (*node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && t.text == "&&"; t = p.peek() {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectBool(left); err != nil {
+			return nil, err
+		}
+		if err := p.expectBool(right); err != nil {
+			return nil, err
+		}
+		l, r := left.boolFn, right.boolFn
+		left = &node{typ: typBool, pos: left.pos, boolFn: func(a ble.Advertisement) bool { return l(a) && r(a) }}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (*node, error) {
+	if t := p.peek(); t.kind == tokOp && t.text == "!" {
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectBool(n); err != nil {
+			return nil, err
+		}
+		f := n.boolFn
+		return &node{typ: typBool, pos: t.pos, boolFn: func(a ble.Advertisement) bool { return !f(a) }}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (*node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	if t.kind != tokOp || t.text == "&&" || t.text == "||" || t.text == "!" {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t.text {
+	case "=~", "!~":
+		return p.buildMatch(t, left, right)
+	}
+
+	if left.typ != right.typ {
+		return nil, p.errorf(right.pos, "cannot compare %s with %s", left.typ, right.typ)
+	}
+	switch left.typ {
+	case typInt:
+		return p.buildIntCmp(t, left, right)
+	case typString:
+		return p.buildStrCmp(t, left, right)
+	case typBool:
+		if t.text != "==" && t.text != "!=" {
+			return nil, p.errorf(t.pos, "operator %s not defined on bool", t.text)
+		}
+		l, r, neq := left.boolFn, right.boolFn, t.text == "!="
+		return &node{typ: typBool, pos: left.pos, boolFn: func(a ble.Advertisement) bool { return (l(a) == r(a)) != neq }}, nil
+	default:
+		return nil, p.errorf(t.pos, "operator %s not defined on %s", t.text, left.typ)
+	}
+}
+
+func (p *filterParser) buildMatch(op token, left, right *node) (*node, error) {
+	if left.typ != typString {
+		return nil, p.errorf(left.pos, "operator %s requires a string on the left, got %s", op.text, left.typ)
+	}
+	if right.lit == nil || right.lit.kind != tokString {
+		return nil, p.errorf(right.pos, "operator %s requires a string literal pattern", op.text)
+	}
+	re, err := regexp.Compile(right.lit.text)
+	if err != nil {
+		return nil, p.errorf(right.pos, "invalid regular expression: %v", err)
+	}
+	l, neg := left.strFn, op.text == "!~"
+	return &node{typ: typBool, pos: left.pos, boolFn: func(a ble.Advertisement) bool { return re.MatchString(l(a)) != neg }}, nil
+}
+
+func (p *filterParser) buildIntCmp(op token, left, right *node) (*node, error) {
+	l, r := left.intFn, right.intFn
+	var cmp func(x, y int) bool
+	switch op.text {
+	case "==":
+		cmp = func(x, y int) bool { return x == y }
+	case "!=":
+		cmp = func(x, y int) bool { return x != y }
+	case "<":
+		cmp = func(x, y int) bool { return x < y }
+	case "<=":
+		cmp = func(x, y int) bool { return x <= y }
+	case ">":
+		cmp = func(x, y int) bool { return x > y }
+	case ">=":
+		cmp = func(x, y int) bool { return x >= y }
+	default:
+		return nil, p.errorf(op.pos, "operator %s not defined on int", op.text)
+	}
+	return &node{typ: typBool, pos: left.pos, boolFn: func(a ble.Advertisement) bool { return cmp(l(a), r(a)) }}, nil
+}
+
+func (p *filterParser) buildStrCmp(op token, left, right *node) (*node, error) {
+	l, r := left.strFn, right.strFn
+	switch op.text {
+	case "==":
+		return &node{typ: typBool, pos: left.pos, boolFn: func(a ble.Advertisement) bool { return strings.EqualFold(l(a), r(a)) }}, nil
+	case "!=":
+		return &node{typ: typBool, pos: left.pos, boolFn: func(a ble.Advertisement) bool { return !strings.EqualFold(l(a), r(a)) }}, nil
+	default:
+		return nil, p.errorf(op.pos, "operator %s not defined on string", op.text)
+	}
+}
+
+func (p *filterParser) parsePrimary() (*node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.next(); c.kind != tokRParen {
+			return nil, p.errorf(c.pos, "expected \")\", got %s", c)
+		}
+		return n, nil
+	case tokInt:
+		v := t.num
+		return &node{typ: typInt, pos: t.pos, lit: &t, intFn: func(ble.Advertisement) int { return v }}, nil
+	case tokString:
+		v := t.text
+		return &node{typ: typString, pos: t.pos, lit: &t, strFn: func(ble.Advertisement) string { return v }}, nil
+	case tokIdent:
+		if n := p.peek(); n.kind == tokLParen {
+			return p.parseCall(t)
+		}
+		return p.parseIdent(t)
+	default:
+		return nil, p.errorf(t.pos, "unexpected %s", t)
+	}
+}
+
+// parseIdent はフィールド名・真偽値リテラルを解決します
+func (p *filterParser) parseIdent(t token) (*node, error) {
+	switch strings.ToLower(t.text) {
+	case "true", "false":
+		v := strings.EqualFold(t.text, "true")
+		return &node{typ: typBool, pos: t.pos, boolFn: func(ble.Advertisement) bool { return v }}, nil
+	}
+	f, ok := filterFields[strings.ToLower(t.text)]
+	if !ok {
+		return nil, p.errorf(t.pos, "unknown field %q", t.text)
+	}
+	n := f
+	n.pos = t.pos
+	return &n, nil
+}
+
+// parseCall は関数呼び出し（現状 has のみ）を解析します
+func (p *filterParser) parseCall(name token) (*node, error) {
+	p.next() // "("
+	var args []*node
+	if p.peek().kind != tokRParen {
+		for {
+			a, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if c := p.next(); c.kind != tokRParen {
+		return nil, p.errorf(c.pos, "expected \")\", got %s", c)
+	}
+
+	switch strings.ToLower(name.text) {
+	case "has":
+		if len(args) != 2 {
+			return nil, p.errorf(name.pos, "has() takes 2 arguments, got %d", len(args))
+		}
+		if args[0].typ != typList {
+			return nil, p.errorf(args[0].pos, "has() requires a list as first argument, got %s", args[0].typ)
+		}
+		if args[1].typ != typString {
+			return nil, p.errorf(args[1].pos, "has() requires a string as second argument, got %s", args[1].typ)
+		}
+		list, want := args[0].listFn, args[1].strFn
+		return &node{typ: typBool, pos: name.pos, boolFn: func(a ble.Advertisement) bool {
+			w := normalizeUUID(want(a))
+			for _, s := range list(a) {
+				if normalizeUUID(s) == w {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	default:
+		return nil, p.errorf(name.pos, "unknown function %q", name.text)
+	}
+}
+
+/* ---------- フィールド ---------- */
+
+// filterFields は式から参照できるアドバタイズのフィールド
+var filterFields = map[string]node{
+	"addr":        {typ: typString, strFn: func(a ble.Advertisement) string { return strings.ToLower(a.Addr().String()) }},
+	"addrtype":    {typ: typString, strFn: peekbt.AddressType},
+	"name":        {typ: typString, strFn: func(a ble.Advertisement) string { return a.LocalName() }},
+	"rssi":        {typ: typInt, intFn: func(a ble.Advertisement) int { return a.RSSI() }},
+	"txpower":     {typ: typInt, intFn: func(a ble.Advertisement) int { return a.TxPowerLevel() }},
+	"company":     {typ: typInt, intFn: peekbt.CompanyID},
+	"connectable": {typ: typBool, boolFn: func(a ble.Advertisement) bool { return a.Connectable() }},
+	"service":     {typ: typList, listFn: serviceStrings},
+}
+
+// serviceStrings はサービス UUID とサービスデータ UUID を文字列で返します
+func serviceStrings(a ble.Advertisement) []string {
+	var s []string
+	for _, u := range a.Services() {
+		s = append(s, u.String())
+	}
+	for _, sd := range a.ServiceData() {
+		s = append(s, sd.UUID.String())
+	}
+	return s
+}
+
+// normalizeUUID は "0x180F" や "0000180f-..." 形式の表記揺れを吸収します。
+// 16 / 32 ビットの UUID は Bluetooth Base UUID で 128 ビットに広げて比べます
+func normalizeUUID(s string) string {
+	s = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"))
+	s = strings.ReplaceAll(s, "-", "")
+	if u, err := ble.Parse(s); err == nil {
+		return longUUID(u)
+	}
+	return s
+}
+
+// longUUID は 16 / 32 ビットの UUID を Bluetooth Base UUID で 128 ビットに広げた文字列を返します
+func longUUID(u ble.UUID) string {
+	switch len(u) {
+	case 2:
+		return "0000" + u.String() + "00001000800000805f9b34fb"
+	case 4:
+		return u.String() + "00001000800000805f9b34fb"
+	}
+	return u.String()
+}
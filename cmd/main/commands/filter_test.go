@@ -0,0 +1,149 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-ble/ble"
+)
+
+/* ---------- フィルタ評価用のアドバタイズ ---------- */
+type filterAdv struct {
+	stubAdv
+	mfg      []byte
+	services []ble.UUID
+	conn     bool
+}
+
+func (f filterAdv) ManufacturerData() []byte { return f.mfg }
+func (f filterAdv) Services() []ble.UUID     { return f.services }
+func (f filterAdv) Connectable() bool        { return f.conn }
+
+func newFilterAdv() filterAdv {
+	return filterAdv{
+		stubAdv:  stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff"), name: "Tile Mate", rssi: -60},
+		mfg:      []byte{0x4c, 0x00, 0x02, 0x15},
+		services: []ble.UUID{ble.UUID16(0x180f)},
+		conn:     true,
+	}
+}
+
+/* ---------- 1. 評価結果 ---------- */
+func TestParseFilter_Match(t *testing.T) {
+	adv := newFilterAdv()
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`rssi > -70 && name =~ "^Tile" && company == 0x004C && has(service, "180f") && connectable`, true},
+		{`rssi > -50`, false},
+		{`rssi >= -60 && rssi <= -60`, true},
+		{`name !~ "^Tile"`, false},
+		{`name == "tile mate"`, true},
+		{`addr == "AA:BB:CC:DD:EE:FF"`, true},
+		{`!connectable || company == 76`, true},
+		{`has(service, "0x180F")`, true},
+		{`has(service, "0000180F-0000-1000-8000-00805F9B34FB")`, true},
+		{`has(service, "0000180f00001000800000805f9b34fc")`, false},
+		{`has(service, "180a")`, false},
+		{`addrtype == "unknown"`, true},
+		{`(rssi < -90 || name =~ "Mate$") && connectable == true`, true},
+	}
+	for _, c := range cases {
+		f, err := parseFilter(c.expr)
+		if err != nil {
+			t.Fatalf("parseFilter(%q): %v", c.expr, err)
+		}
+		if got := f.Match(adv); got != c.want {
+			t.Errorf("%q => %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestParseFilter_LongServiceUUID(t *testing.T) {
+	// 16 ビットの UUID を 128 ビットの形で広告するデバイス
+	adv := newFilterAdv()
+	adv.services = []ble.UUID{ble.MustParse("0000180f-0000-1000-8000-00805f9b34fb")}
+	f, err := parseFilter(`has(service, "180f")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Match(adv) {
+		t.Error("128-bit base UUID not matched by its 16-bit form")
+	}
+}
+
+func TestParseFilter_RegexEscapes(t *testing.T) {
+	adv := newFilterAdv()
+	adv.name = "Tile42"
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{`name =~ "^Tile\d+$"`, true},
+		{`name =~ "^Tiled+$"`, false},
+		{`name =~ "^Tile\\\\d"`, false},
+		{`name == "Tile42" || name == "say \"hi\""`, true},
+	}
+	for _, c := range cases {
+		f, err := parseFilter(c.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.expr, err)
+		}
+		if got := f.Match(adv); got != c.want {
+			t.Errorf("%s = %v, want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestLexFilter_StringEscapes(t *testing.T) {
+	toks, err := lexFilter(`"a\"b\\c\d"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `a"b\c\d`; toks[0].text != want {
+		t.Errorf("string token = %q, want %q", toks[0].text, want)
+	}
+}
+
+/* ---------- 2. 空式 ---------- */
+func TestParseFilter_Empty(t *testing.T) {
+	f, err := parseFilter("  ")
+	if err != nil || f != nil {
+		t.Fatalf("empty expression should yield nil filter, got %v, %v", f, err)
+	}
+}
+
+/* ---------- 3. エラー位置 ---------- */
+func TestParseFilter_Errors(t *testing.T) {
+	cases := []struct {
+		expr string
+		pos  int
+	}{
+		{`rssi > `, 7},
+		{`rssi > "x"`, 7},
+		{`foo == 1`, 0},
+		{`name =~ "["`, 8},
+		{`rssi`, 0},
+		{`(rssi > 1`, 9},
+		{`name == "abc`, 8},
+		{`has(name, "x")`, 4},
+		{`rssi > -70 )`, 11},
+		{`rssi @ 1`, 5},
+	}
+	for _, c := range cases {
+		_, err := parseFilter(c.expr)
+		fe, ok := err.(*FilterError)
+		if !ok {
+			t.Errorf("%q: expected *FilterError, got %v", c.expr, err)
+			continue
+		}
+		if fe.Pos != c.pos {
+			t.Errorf("%q: error pos %d, want %d (%s)", c.expr, fe.Pos, c.pos, fe.Msg)
+		}
+		marker := strings.Repeat(" ", c.pos) + "^"
+		if !strings.Contains(fe.Error(), marker) {
+			t.Errorf("%q: error message lacks position marker:\n%s", c.expr, fe.Error())
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// 出力形式
+const (
+	formatText     = "text"
+	formatJSON     = "json"
+	formatYAML     = "yaml"
+	formatTable    = "table"
+	formatTemplate = "template"
+)
+
+var outputFormats = []string{formatText, formatJSON, formatYAML, formatTable, formatTemplate}
+
+// fielder は text / table 形式で表示できる値（ラベルと値の組を順に返す）
+type fielder interface {
+	Fields() [][2]string
+}
+
+// outputSpec は --format / --template / -o の組
+type outputSpec struct {
+	Format   string
+	Template string
+	Path     string // "" または "-" なら標準出力
+
+	tmpl *template.Template
+}
+
+// addOutputFlags は --format / --template / -o をコマンドに登録します
+func addOutputFlags(c *cobra.Command, o *outputSpec) {
+	c.Flags().StringVar(&o.Format, "format", "", "Output format: "+strings.Join(outputFormats, "|")+" (default text)")
+	c.Flags().StringVar(&o.Template, "template", "", "Go text/template for --format template (e.g. '{{.Address}} {{.RSSI}}')")
+	c.Flags().StringVarP(&o.Path, "output", "o", "", `Write output to the specified file ("-" for stdout)`)
+}
+
+// resolve はフラグを検証し、テンプレートをパースします。
+// --template のみ指定された場合は template 形式とみなします
+func (o *outputSpec) resolve() error {
+	if o.Format == "" {
+		o.Format = formatText
+		if o.Template != "" {
+			o.Format = formatTemplate
+		}
+	}
+	valid := false
+	for _, f := range outputFormats {
+		valid = valid || o.Format == f
+	}
+	if !valid {
+		return fmt.Errorf("invalid format %q (want %s)", o.Format, strings.Join(outputFormats, "|"))
+	}
+	if o.Format == formatTemplate {
+		if o.Template == "" {
+			return fmt.Errorf("--format template requires --template")
+		}
+		t, err := template.New("output").Parse(o.Template)
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+		o.tmpl = t
+	}
+	return nil
+}
+
+// toStdout は出力先が標準出力かを返します
+func (o *outputSpec) toStdout() bool {
+	return o.Path == "" || o.Path == "-"
+}
+
+// write は v を指定形式で出力先に書き出します
+func (o *outputSpec) write(v fielder) error {
+	if o.toStdout() {
+		return o.render(os.Stdout, v)
+	}
+	f, err := os.Create(o.Path)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	if err := o.render(f, v); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// render は v を指定形式で w に書き出します
+func (o *outputSpec) render(w io.Writer, v fielder) error {
+	switch o.Format {
+	case formatJSON:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal JSON: %w", err)
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	case formatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("failed to marshal YAML: %w", err)
+		}
+		return enc.Close()
+	case formatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		var head, row []string
+		for _, f := range v.Fields() {
+			head = append(head, strings.ToUpper(f[0]))
+			row = append(row, f[1])
+		}
+		fmt.Fprintln(tw, strings.Join(head, "\t"))
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+		return tw.Flush()
+	case formatTemplate:
+		if err := o.tmpl.Execute(w, v); err != nil {
+			return fmt.Errorf("failed to execute template: %w", err)
+		}
+		_, err := fmt.Fprintln(w)
+		return err
+	default:
+		for _, f := range v.Fields() {
+			if _, err := fmt.Fprintf(w, "%-15s: %s\n", f[0], f[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+var sampleInfo = peekbt.DeviceInfo{
+	Address:      "aa:bb:cc:dd:ee:ff",
+	AddressType:  "Public",
+	Name:         "dev",
+	RSSI:         -42,
+	ServicesUUID: []string{"180f"},
+	Connectable:  true,
+}
+
+func renderString(t *testing.T, o outputSpec) string {
+	t.Helper()
+	if err := o.resolve(); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := o.render(&buf, sampleInfo); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	return buf.String()
+}
+
+/* ---------- 1. 各形式 ---------- */
+func TestOutputSpec_Formats(t *testing.T) {
+	if got := renderString(t, outputSpec{}); !strings.Contains(got, "RSSI           : -42 dBm\n") {
+		t.Errorf("text output mismatch:\n%s", got)
+	}
+
+	var j peekbt.DeviceInfo
+	if err := json.Unmarshal([]byte(renderString(t, outputSpec{Format: formatJSON})), &j); err != nil || j.Address != sampleInfo.Address {
+		t.Errorf("json output mismatch: %+v, %v", j, err)
+	}
+
+	var y peekbt.DeviceInfo
+	if err := yaml.Unmarshal([]byte(renderString(t, outputSpec{Format: formatYAML})), &y); err != nil || y.RSSI != -42 || y.ServicesUUID[0] != "180f" {
+		t.Errorf("yaml output mismatch: %+v, %v", y, err)
+	}
+
+	table := strings.Split(strings.TrimSpace(renderString(t, outputSpec{Format: formatTable})), "\n")
+	if len(table) != 2 || !strings.HasPrefix(table[0], "ADDRESS") || !strings.HasPrefix(table[1], "aa:bb:cc:dd:ee:ff") {
+		t.Errorf("table output mismatch: %q", table)
+	}
+}
+
+/* ---------- 2. テンプレート ---------- */
+func TestOutputSpec_Template(t *testing.T) {
+	got := renderString(t, outputSpec{Template: "{{.Address}} {{.RSSI}}"})
+	if got != "aa:bb:cc:dd:ee:ff -42\n" {
+		t.Errorf("template output = %q", got)
+	}
+}
+
+/* ---------- 3. 不正な指定 ---------- */
+func TestOutputSpec_Invalid(t *testing.T) {
+	cases := []outputSpec{
+		{Format: "xml"},
+		{Format: formatTemplate},
+		{Template: "{{.Address"},
+	}
+	for _, c := range cases {
+		if err := c.resolve(); err == nil {
+			t.Errorf("%+v should be rejected", c)
+		}
+	}
+}
+
+/* ---------- 4. ファイル出力 ---------- */
+func TestOutputSpec_WriteFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "out.yaml")
+	o := outputSpec{Format: formatYAML, Path: p}
+	if err := o.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.write(sampleInfo); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(p)
+	if !bytes.Contains(b, []byte("address: aa:bb:cc:dd:ee:ff")) {
+		t.Errorf("unexpected file content:\n%s", b)
+	}
+}
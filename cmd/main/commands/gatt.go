@@ -0,0 +1,298 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux/hci"
+	"github.com/spf13/cobra"
+)
+
+// Dialer は GATT クライアントの接続元。テストではフェイクの ble.Client を返すものに差し替えます
+type Dialer func(ctx context.Context, a ble.Addr) (ble.Client, error)
+
+// DefaultDialer はアダプタを初期化して接続します
+var DefaultDialer Dialer = func(ctx context.Context, a ble.Addr) (ble.Client, error) {
+	if _, err := InitDefaultAdapter(); err != nil {
+		return nil, err
+	}
+	return ble.Dial(ctx, a)
+}
+
+// connOptions は GATT で接続するコマンド（gatt / read / write / notify）共通のフラグ
+type connOptions struct {
+	Random   bool
+	Connect  time.Duration
+	Discover time.Duration
+}
+
+// addConnFlags は接続のフラグを c に登録します
+func addConnFlags(c *cobra.Command, o *connOptions) {
+	c.Flags().BoolVar(&o.Random, "random", false, "The address is a random (not public) device address")
+	c.Flags().DurationVar(&o.Connect, "connect-timeout", 10*time.Second, "Timeout for establishing the connection")
+	c.Flags().DurationVar(&o.Discover, "discover-timeout", 30*time.Second, "Timeout for discovering services, characteristics and descriptors")
+}
+
+// dial は addr のデバイスに接続します。再生モードでは接続できないためエラーにします
+func (o *connOptions) dial(ctx context.Context, addr string) (ble.Client, error) {
+	addr = strings.ToLower(addr)
+	if err := validateAddr(addr); err != nil {
+		return nil, err
+	}
+	if o.Connect <= 0 || o.Discover <= 0 {
+		return nil, fmt.Errorf("timeouts must be positive")
+	}
+	if replayMode {
+		return nil, errors.New("cannot connect to a device while replaying a capture")
+	}
+	hw, err := net.ParseMAC(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address format: %s", addr)
+	}
+	var a ble.Addr = hw
+	if o.Random {
+		a = hci.RandomAddress{Addr: hw}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.Connect)
+	defer cancel()
+	cln, err := DefaultDialer(ctx, a)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("failed to connect to %s: timed out after %v", addr, o.Connect)
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	return cln, nil
+}
+
+// withTimeout は go-ble のブロックする呼び出し fn を timeout 付きで実行します。
+// 時間切れか ctx の終了で接続を切って fn を戻らせます（go-ble の CancelConnection は
+// 実行中の要求が終わるまでロックを待つため、別の goroutine で呼びます）
+func withTimeout(ctx context.Context, cln ble.Client, timeout time.Duration, what string, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to %s: %w", what, err)
+		}
+		return nil
+	case <-ctx.Done():
+		go cln.CancelConnection()
+		return fmt.Errorf("failed to %s: %w", what, ctx.Err())
+	case <-time.After(timeout):
+		go cln.CancelConnection()
+		return fmt.Errorf("failed to %s: timed out after %v", what, timeout)
+	}
+}
+
+var (
+	gattConn connOptions
+	gattJSON bool
+)
+
+var gattCommand = &cobra.Command{
+	Use:   "gatt [flags] <ADDR>",
+	Short: "Connect to a device and list its GATT services, characteristics and descriptors.",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runGattCommand,
+}
+
+func init() {
+	addConnFlags(gattCommand, &gattConn)
+	gattCommand.Flags().BoolVar(&gattJSON, "json", false, "Print the GATT tree as JSON")
+	rootCommand.AddCommand(gattCommand)
+}
+
+func runGattCommand(cmd *cobra.Command, args []string) error {
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
+	cln, err := gattConn.dial(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer cln.CancelConnection()
+
+	p, err := discoverGATT(ctx, cln, gattConn.Discover)
+	if err != nil {
+		return err
+	}
+	if gattJSON {
+		return writeGATTJSON(cmd.OutOrStdout(), p)
+	}
+	return writeGATTTree(cmd.OutOrStdout(), p)
+}
+
+// gattDescriptor は JSON / ツリー出力用のディスクリプタ
+type gattDescriptor struct {
+	UUID   string `json:"uuid"`
+	Name   string `json:"name,omitempty"`
+	Handle uint16 `json:"handle"`
+}
+
+// gattCharacteristic は JSON / ツリー出力用のキャラクタリスティック
+type gattCharacteristic struct {
+	UUID        string           `json:"uuid"`
+	Name        string           `json:"name,omitempty"`
+	Handle      uint16           `json:"handle"`
+	ValueHandle uint16           `json:"valueHandle"`
+	Properties  []string         `json:"properties"`
+	Descriptors []gattDescriptor `json:"descriptors"`
+}
+
+// gattService は JSON / ツリー出力用のサービス
+type gattService struct {
+	UUID            string               `json:"uuid"`
+	Name            string               `json:"name,omitempty"`
+	Handle          uint16               `json:"handle"`
+	EndHandle       uint16               `json:"endHandle"`
+	Characteristics []gattCharacteristic `json:"characteristics"`
+}
+
+// gattProfile は接続したデバイスの GATT の全体
+type gattProfile struct {
+	Address  string        `json:"address"`
+	Name     string        `json:"name,omitempty"`
+	Services []gattService `json:"services"`
+}
+
+// gattPropertyNames はキャラクタリスティックのプロパティの表示名（ビット順）
+var gattPropertyNames = []struct {
+	bit  ble.Property
+	name string
+}{
+	{ble.CharBroadcast, "broadcast"},
+	{ble.CharRead, "read"},
+	{ble.CharWriteNR, "write-without-response"},
+	{ble.CharWrite, "write"},
+	{ble.CharNotify, "notify"},
+	{ble.CharIndicate, "indicate"},
+	{ble.CharSignedWrite, "authenticated-signed-writes"},
+	{ble.CharExtended, "extended-properties"},
+}
+
+// propertyNames はプロパティのビットを名前の一覧にします
+func propertyNames(p ble.Property) []string {
+	names := []string{}
+	for _, n := range gattPropertyNames {
+		if p&n.bit != 0 {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// discoverGATT はサービス・キャラクタリスティック・ディスクリプタを全て探索します
+func discoverGATT(ctx context.Context, cln ble.Client, timeout time.Duration) (*gattProfile, error) {
+	svcs, err := discoverServices(ctx, cln, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return newGATTProfile(cln, svcs), nil
+}
+
+// discoverServices は探索したサービスを返します。キャラクタリスティックと
+// ディスクリプタ（CCCD を含む）は各サービスに格納されます
+func discoverServices(ctx context.Context, cln ble.Client, timeout time.Duration) ([]*ble.Service, error) {
+	var svcs []*ble.Service
+	err := withTimeout(ctx, cln, timeout, "discover GATT", func() error {
+		var err error
+		if svcs, err = cln.DiscoverServices(nil); err != nil {
+			return err
+		}
+		for _, s := range svcs {
+			chars, err := cln.DiscoverCharacteristics(nil, s)
+			if err != nil {
+				return fmt.Errorf("service %s: %w", s.UUID, err)
+			}
+			for _, c := range chars {
+				if _, err := cln.DiscoverDescriptors(nil, c); err != nil {
+					return fmt.Errorf("characteristic %s: %w", c.UUID, err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return svcs, nil
+}
+
+// newGATTProfile は探索結果を JSON / ツリー出力用に変換します
+func newGATTProfile(cln ble.Client, svcs []*ble.Service) *gattProfile {
+	p := &gattProfile{Address: cln.Addr().String(), Name: cln.Name(), Services: []gattService{}}
+	for _, s := range svcs {
+		gs := gattService{UUID: s.UUID.String(), Name: ble.Name(s.UUID), Handle: s.Handle, EndHandle: s.EndHandle,
+			Characteristics: []gattCharacteristic{}}
+		for _, c := range s.Characteristics {
+			gc := gattCharacteristic{UUID: c.UUID.String(), Name: ble.Name(c.UUID), Handle: c.Handle, ValueHandle: c.ValueHandle,
+				Properties: propertyNames(c.Property), Descriptors: []gattDescriptor{}}
+			for _, d := range c.Descriptors {
+				gc.Descriptors = append(gc.Descriptors, gattDescriptor{UUID: d.UUID.String(), Name: ble.Name(d.UUID), Handle: d.Handle})
+			}
+			gs.Characteristics = append(gs.Characteristics, gc)
+		}
+		p.Services = append(p.Services, gs)
+	}
+	return p
+}
+
+// writeGATTJSON は GATT の全体を JSON で書き出します
+func writeGATTJSON(w io.Writer, p *gattProfile) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// writeGATTTree は GATT の全体をツリーで書き出します
+//
+//	aa:bb:cc:dd:ee:ff (Thermometer)
+//	└── 180f Battery Service [0x0010-0x0014]
+//	    └── 2a19 Battery Level [0x0012] read, notify
+//	        └── 2902 Client Characteristic Configuration [0x0013]
+func writeGATTTree(w io.Writer, p *gattProfile) error {
+	var b strings.Builder
+	if p.Name != "" {
+		fmt.Fprintf(&b, "%s (%s)\n", p.Address, p.Name)
+	} else {
+		fmt.Fprintln(&b, p.Address)
+	}
+	branch := func(prefix string, last bool) (string, string) {
+		if last {
+			return prefix + "└── ", prefix + "    "
+		}
+		return prefix + "├── ", prefix + "│   "
+	}
+	label := func(uuid, name string) string {
+		if name == "" {
+			name = "Unknown"
+		}
+		return uuid + " " + name
+	}
+	for i, s := range p.Services {
+		head, sp := branch("", i == len(p.Services)-1)
+		fmt.Fprintf(&b, "%s%s [0x%04x-0x%04x]\n", head, label(s.UUID, s.Name), s.Handle, s.EndHandle)
+		for j, c := range s.Characteristics {
+			head, cp := branch(sp, j == len(s.Characteristics)-1)
+			fmt.Fprintf(&b, "%s%s [0x%04x] %s\n", head, label(c.UUID, c.Name), c.ValueHandle, strings.Join(c.Properties, ", "))
+			for k, d := range c.Descriptors {
+				head, _ := branch(cp, k == len(c.Descriptors)-1)
+				fmt.Fprintf(&b, "%s%s [0x%04x]\n", head, label(d.UUID, d.Name), d.Handle)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,291 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux/hci"
+)
+
+// fakeClient はサーバ側の GATT（services）を持つフェイクの ble.Client。
+// 探索は実機の go-ble と同じく、渡されたサービス / キャラクタリスティックに結果を追加します
+type fakeClient struct {
+	ble.Client // 使わないメソッド
+
+	addr     ble.Addr
+	name     string
+	services []*ble.Service
+	block    chan struct{} // nil でなければ探索を閉じるまで止める
+
+	mu           sync.Mutex
+	values       map[uint16][]byte // 値のハンドル毎の値
+	writes       []fakeWrite
+	subs         map[uint16]ble.NotificationHandler
+	unsubscribed int
+	subscribed   chan struct{} // Subscribe の度に通知する
+	disconnected chan struct{}
+	canceled     bool
+}
+
+// fakeWrite は fakeClient への書き込み 1 回
+type fakeWrite struct {
+	handle uint16
+	value  []byte
+	noRsp  bool
+}
+
+func newFakeClient(services ...*ble.Service) *fakeClient {
+	return &fakeClient{
+		addr:         net.HardwareAddr{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff},
+		name:         "Thermometer",
+		services:     services,
+		values:       map[uint16][]byte{},
+		subs:         map[uint16]ble.NotificationHandler{},
+		subscribed:   make(chan struct{}, 1),
+		disconnected: make(chan struct{}),
+	}
+}
+
+func (f *fakeClient) Addr() ble.Addr { return f.addr }
+func (f *fakeClient) Name() string   { return f.name }
+
+func (f *fakeClient) DiscoverServices(filter []ble.UUID) ([]*ble.Service, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	var out []*ble.Service
+	for _, s := range f.services {
+		out = append(out, &ble.Service{UUID: s.UUID, Handle: s.Handle, EndHandle: s.EndHandle})
+	}
+	return out, nil
+}
+
+func (f *fakeClient) DiscoverCharacteristics(filter []ble.UUID, s *ble.Service) ([]*ble.Characteristic, error) {
+	for _, srv := range f.services {
+		if srv.Handle != s.Handle {
+			continue
+		}
+		for _, c := range srv.Characteristics {
+			s.Characteristics = append(s.Characteristics, &ble.Characteristic{UUID: c.UUID, Property: c.Property,
+				Handle: c.Handle, ValueHandle: c.ValueHandle, EndHandle: c.EndHandle})
+		}
+	}
+	return s.Characteristics, nil
+}
+
+func (f *fakeClient) DiscoverDescriptors(filter []ble.UUID, c *ble.Characteristic) ([]*ble.Descriptor, error) {
+	for _, srv := range f.services {
+		for _, sc := range srv.Characteristics {
+			if sc.Handle != c.Handle {
+				continue
+			}
+			for _, d := range sc.Descriptors {
+				nd := &ble.Descriptor{UUID: d.UUID, Handle: d.Handle}
+				c.Descriptors = append(c.Descriptors, nd)
+				if d.UUID.Equal(ble.ClientCharacteristicConfigUUID) {
+					c.CCCD = nd
+				}
+			}
+		}
+	}
+	return c.Descriptors, nil
+}
+
+func (f *fakeClient) ReadLongCharacteristic(c *ble.Characteristic) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	v, ok := f.values[c.ValueHandle]
+	if !ok {
+		return nil, ble.ErrReadNotPerm
+	}
+	return v, nil
+}
+
+func (f *fakeClient) WriteCharacteristic(c *ble.Characteristic, v []byte, noRsp bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes = append(f.writes, fakeWrite{handle: c.ValueHandle, value: v, noRsp: noRsp})
+	f.values[c.ValueHandle] = v
+	return nil
+}
+
+func (f *fakeClient) Subscribe(c *ble.Characteristic, ind bool, h ble.NotificationHandler) error {
+	if c.CCCD == nil {
+		return errors.New("CCCD not found")
+	}
+	f.mu.Lock()
+	f.subs[c.ValueHandle] = h
+	f.mu.Unlock()
+	select {
+	case f.subscribed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (f *fakeClient) Unsubscribe(c *ble.Characteristic, ind bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, c.ValueHandle)
+	f.unsubscribed++
+	return nil
+}
+
+// notify はサーバからの通知を handle の購読者に届けます
+func (f *fakeClient) notify(handle uint16, v []byte) {
+	f.mu.Lock()
+	h := f.subs[handle]
+	f.mu.Unlock()
+	if h != nil {
+		h(v)
+	}
+}
+
+func (f *fakeClient) CancelConnection() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.canceled {
+		f.canceled = true
+		close(f.disconnected)
+	}
+	return nil
+}
+
+func (f *fakeClient) Disconnected() <-chan struct{} { return f.disconnected }
+
+// sampleGATT は Generic Access と Battery Service とベンダ独自サービスを持つデバイス
+func sampleGATT() []*ble.Service {
+	return []*ble.Service{
+		{UUID: ble.GAPUUID, Handle: 0x0001, EndHandle: 0x0005, Characteristics: []*ble.Characteristic{
+			{UUID: ble.DeviceNameUUID, Property: ble.CharRead, Handle: 0x0002, ValueHandle: 0x0003},
+		}},
+		{UUID: ble.BatteryUUID, Handle: 0x0010, EndHandle: 0x0014, Characteristics: []*ble.Characteristic{
+			{UUID: ble.UUID16(0x2a19), Property: ble.CharRead | ble.CharNotify, Handle: 0x0011, ValueHandle: 0x0012,
+				Descriptors: []*ble.Descriptor{{UUID: ble.ClientCharacteristicConfigUUID, Handle: 0x0013}}},
+		}},
+		{UUID: ble.MustParse("6e400001-b5a3-f393-e0a9-e50e24dcca9e"), Handle: 0x0020, EndHandle: 0x0025, Characteristics: []*ble.Characteristic{
+			{UUID: ble.MustParse("6e400002-b5a3-f393-e0a9-e50e24dcca9e"), Property: ble.CharWrite | ble.CharWriteNR, Handle: 0x0021, ValueHandle: 0x0022},
+		}},
+	}
+}
+
+// useFakeDialer は DefaultDialer を cln を返すものに差し替えます
+func useFakeDialer(t *testing.T, cln ble.Client) *ble.Addr {
+	t.Helper()
+	var dialed ble.Addr
+	orig := DefaultDialer
+	DefaultDialer = func(ctx context.Context, a ble.Addr) (ble.Client, error) {
+		dialed = a
+		return cln, nil
+	}
+	t.Cleanup(func() { DefaultDialer = orig })
+	return &dialed
+}
+
+/* ---------- 1. ツリー表示 ---------- */
+func TestGattCommand_Tree(t *testing.T) {
+	cln := newFakeClient(sampleGATT()...)
+	dialed := useFakeDialer(t, cln)
+	buf := &bytes.Buffer{}
+	rootCommand.SetOut(buf)
+	defer rootCommand.SetOut(nil)
+	rootCommand.SetArgs([]string{"gatt", "AA:BB:CC:DD:EE:FF", "--random"})
+	defer func() { gattConn.Random = false }()
+	if err := rootCommand.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `aa:bb:cc:dd:ee:ff (Thermometer)
+├── 1800 Generic Access [0x0001-0x0005]
+│   └── 2a00 Device Name [0x0003] read
+├── 180f Battery Service [0x0010-0x0014]
+│   └── 2a19 Battery Level [0x0012] read, notify
+│       └── 2902 Client Characteristic Configuration [0x0013]
+└── 6e400001b5a3f393e0a9e50e24dcca9e Unknown [0x0020-0x0025]
+    └── 6e400002b5a3f393e0a9e50e24dcca9e Unknown [0x0022] write-without-response, write
+`
+	if got := buf.String(); got != want {
+		t.Errorf("tree:\n%s\nwant:\n%s", got, want)
+	}
+	if (*dialed).String() != "aa:bb:cc:dd:ee:ff" || !cln.canceled {
+		t.Errorf("dialed %v, disconnected %v", *dialed, cln.canceled)
+	}
+	if _, ok := (*dialed).(hci.RandomAddress); !ok {
+		t.Errorf("--random not applied: %T", *dialed)
+	}
+}
+
+/* ---------- 2. JSON ---------- */
+func TestDiscoverGATT_JSON(t *testing.T) {
+	p, err := discoverGATT(context.Background(), newFakeClient(sampleGATT()...), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := writeGATTJSON(buf, p); err != nil {
+		t.Fatal(err)
+	}
+	var got gattProfile
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Services) != 3 || got.Name != "Thermometer" {
+		t.Fatalf("unexpected profile %+v", got)
+	}
+	c := got.Services[1].Characteristics[0]
+	if c.UUID != "2a19" || c.Name != "Battery Level" || c.ValueHandle != 0x12 ||
+		strings.Join(c.Properties, ",") != "read,notify" || len(c.Descriptors) != 1 || c.Descriptors[0].UUID != "2902" {
+		t.Errorf("unexpected characteristic %+v", c)
+	}
+	if got.Services[2].Name != "" || got.Services[2].Characteristics[0].Descriptors == nil {
+		t.Errorf("unexpected vendor service %+v", got.Services[2])
+	}
+}
+
+/* ---------- 3. タイムアウトと不正な引数 ---------- */
+func TestDiscoverGATT_Timeout(t *testing.T) {
+	cln := newFakeClient(sampleGATT()...)
+	cln.block = make(chan struct{})
+	defer close(cln.block)
+	_, err := discoverGATT(context.Background(), cln, 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("want timeout, got %v", err)
+	}
+	select {
+	case <-cln.Disconnected():
+	case <-time.After(time.Second):
+		t.Error("connection not canceled")
+	}
+}
+
+func TestConnOptions_Dial(t *testing.T) {
+	o := connOptions{Connect: time.Second, Discover: time.Second}
+	if _, err := o.dial(context.Background(), "not-an-address"); err == nil {
+		t.Error("want error for invalid address")
+	}
+
+	orig := DefaultDialer
+	defer func() { DefaultDialer = orig }()
+	DefaultDialer = func(ctx context.Context, a ble.Addr) (ble.Client, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	o.Connect = 20 * time.Millisecond
+	if _, err := o.dial(context.Background(), "aa:bb:cc:dd:ee:ff"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("want connection timeout, got %v", err)
+	}
+
+	replayMode = true
+	defer func() { replayMode = false }()
+	if _, err := o.dial(context.Background(), "aa:bb:cc:dd:ee:ff"); err == nil {
+		t.Error("want error in replay mode")
+	}
+}
@@ -0,0 +1,180 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// defaultHAPrefix は Home Assistant の MQTT discovery の既定プレフィックス
+const defaultHAPrefix = "homeassistant"
+
+// haSensor は peekbt.SensorReading の各値に対応する Home Assistant のセンサー定義
+type haSensor struct {
+	key    string // peekbt.SensorReading の JSON キー
+	name   string
+	class  string
+	unit   string
+	exists func(*peekbt.SensorReading) bool
+}
+
+var haSensors = []haSensor{
+	{"temperature", "Temperature", "temperature", "°C", func(r *peekbt.SensorReading) bool { return r.Temperature != nil }},
+	{"humidity", "Humidity", "humidity", "%", func(r *peekbt.SensorReading) bool { return r.Humidity != nil }},
+	{"battery", "Battery", "battery", "%", func(r *peekbt.SensorReading) bool { return r.Battery != nil }},
+}
+
+// haDevice は discovery の device ブロック
+type haDevice struct {
+	Identifiers []string    `json:"identifiers"`
+	Connections [][2]string `json:"connections"`
+	Name        string      `json:"name"`
+	Model       string      `json:"model,omitempty"`
+}
+
+// haConfig は device_tracker / sensor の discovery config（使う項目のみ）
+type haConfig struct {
+	Name                string   `json:"name"`
+	UniqueID            string   `json:"unique_id"`
+	StateTopic          string   `json:"state_topic"`
+	ValueTemplate       string   `json:"value_template"`
+	JSONAttributesTopic string   `json:"json_attributes_topic,omitempty"`
+	AvailabilityTopic   string   `json:"availability_topic"`
+	PayloadAvailable    string   `json:"payload_available"`
+	PayloadNotAvailable string   `json:"payload_not_available"`
+	SourceType          string   `json:"source_type,omitempty"`
+	PayloadHome         string   `json:"payload_home,omitempty"`
+	PayloadNotHome      string   `json:"payload_not_home,omitempty"`
+	DeviceClass         string   `json:"device_class,omitempty"`
+	StateClass          string   `json:"state_class,omitempty"`
+	Unit                string   `json:"unit_of_measurement,omitempty"`
+	Device              haDevice `json:"device"`
+}
+
+// haDiscovery は Home Assistant 向けに discovery config を publish します。
+// watch に含まれるアドレスは device_tracker に、センサー値を読み取れたデバイスは sensor になります
+type haDiscovery struct {
+	prefix string
+	status string // availability トピック
+	watch  map[string]bool
+
+	// announced はアドレス毎の送信済みの config トピック。
+	// デバイス表と同じ大きさに収まるよう、ウォッチ対象以外は lost で忘れる（再び見えたら送り直す）
+	mu        sync.Mutex
+	announced map[string]map[string]bool
+}
+
+// enableDiscovery は mqttSink に Home Assistant discovery を追加し、
+// ウォッチ対象の device_tracker を未受信でも登録しておきます
+func (m *mqttSink) enableDiscovery(prefix string, watch []string) error {
+	st, err := parseTopic(m.opts.StatusTopic)
+	if err != nil {
+		return err
+	}
+	status, err := execTopic(st, newTopicData(m.host, ""))
+	if err != nil {
+		return err
+	}
+	ha := &haDiscovery{prefix: prefix, status: status,
+		watch: make(map[string]bool), announced: make(map[string]map[string]bool)}
+	for _, a := range watch {
+		ha.watch[a] = true
+	}
+	m.ha = ha
+	for _, a := range watch {
+		m.announce(peekbt.DeviceInfo{Address: a})
+	}
+	return nil
+}
+
+// announce はまだ送っていない config を publish します（discovery 無効時は何もしない）
+func (m *mqttSink) announce(info peekbt.DeviceInfo) {
+	ha := m.ha
+	if ha == nil {
+		return
+	}
+	state, err := execTopic(m.topic, newTopicData(m.host, info.Address))
+	if err != nil {
+		m.onErr(err)
+		return
+	}
+	for topic, cfg := range ha.configs(info, state) {
+		ha.mu.Lock()
+		sent := ha.announced[info.Address][topic]
+		if ha.announced[info.Address] == nil {
+			ha.announced[info.Address] = make(map[string]bool)
+		}
+		ha.announced[info.Address][topic] = true
+		ha.mu.Unlock()
+		if sent {
+			continue
+		}
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			m.onErr(fmt.Errorf("failed to marshal JSON: %w", err))
+			continue
+		}
+		if err := m.pub.Publish(topic, byte(m.opts.QoS), true, b); err != nil {
+			m.onErr(err)
+		}
+	}
+}
+
+// forget は消失したデバイスの送信済みの記録を捨てます（ウォッチ対象は残す）
+func (ha *haDiscovery) forget(addr string) {
+	if ha.watch[addr] {
+		return
+	}
+	ha.mu.Lock()
+	delete(ha.announced, addr)
+	ha.mu.Unlock()
+}
+
+// configs は info に対して必要な config をトピック毎に返します
+func (ha *haDiscovery) configs(info peekbt.DeviceInfo, state string) map[string]haConfig {
+	id := "peekbt_" + newTopicData("", info.Address).ID
+	name := info.Name
+	if name == "" {
+		name = "BLE " + info.Address
+	}
+	dev := haDevice{
+		Identifiers: []string{id},
+		Connections: [][2]string{{"mac", info.Address}},
+		Name:        name,
+	}
+	if info.Sensor != nil {
+		dev.Model = info.Sensor.Format
+	}
+	base := haConfig{
+		StateTopic:          state,
+		AvailabilityTopic:   ha.status,
+		PayloadAvailable:    mqttOnline,
+		PayloadNotAvailable: mqttOffline,
+		Device:              dev,
+	}
+
+	out := make(map[string]haConfig)
+	if ha.watch[info.Address] {
+		c := base
+		c.Name, c.UniqueID = "Presence", id+"_tracker"
+		c.ValueTemplate = "{{ 'not_home' if value_json.event == '" + eventLost + "' else 'home' }}"
+		c.JSONAttributesTopic = state
+		c.SourceType, c.PayloadHome, c.PayloadNotHome = "bluetooth_le", "home", "not_home"
+		out[fmt.Sprintf("%s/device_tracker/%s/config", ha.prefix, id)] = c
+	}
+	if r := info.Sensor; r != nil {
+		for _, s := range haSensors {
+			if !s.exists(r) {
+				continue
+			}
+			c := base
+			c.Name, c.UniqueID = s.name, id+"_"+s.key
+			c.ValueTemplate = "{{ value_json.sensor." + s.key + " }}"
+			c.DeviceClass, c.StateClass, c.Unit = s.class, "measurement", s.unit
+			out[fmt.Sprintf("%s/sensor/%s_%s/config", ha.prefix, id, s.key)] = c
+		}
+	}
+	return out
+}
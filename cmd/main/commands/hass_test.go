@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// sensorAdv はサービスデータ / Manufacturer Data を持つアドバタイズ
+type sensorAdv struct {
+	stubAdv
+	sd  []ble.ServiceData
+	mfg []byte
+}
+
+func (s sensorAdv) ServiceData() []ble.ServiceData { return s.sd }
+func (s sensorAdv) ManufacturerData() []byte       { return s.mfg }
+
+func findMsg(pub *fakePublisher, topic string) (published, bool) {
+	pub.mu.Lock()
+	defer pub.mu.Unlock()
+	for _, m := range pub.msgs {
+		if m.topic == topic {
+			return m, true
+		}
+	}
+	return published{}, false
+}
+
+/* ---------- 1. ウォッチ対象の device_tracker ---------- */
+func TestHADiscovery_Tracker(t *testing.T) {
+	m, pub := newTestMQTT(t, mqttOptions{StatusTopic: defaultMQTTStatusTopic})
+	if err := m.enableDiscovery("homeassistant", []string{"f4:8c:50:01:02:03"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 受信前から登録される
+	cfg, ok := findMsg(pub, "homeassistant/device_tracker/peekbt_f48c50010203/config")
+	if !ok {
+		t.Fatalf("tracker config not published: %+v", pub.msgs)
+	}
+	if !cfg.retained || cfg.payload["state_topic"] != "peekbt/pi/f4:8c:50:01:02:03/state" ||
+		cfg.payload["availability_topic"] != "peekbt/pi/status" || cfg.payload["source_type"] != "bluetooth_le" {
+		t.Fatalf("unexpected tracker config: %+v", cfg)
+	}
+	if !strings.Contains(cfg.payload["value_template"].(string), "'lost'") {
+		t.Fatalf("value_template = %v", cfg.payload["value_template"])
+	}
+
+	// 受信しても重複して送らない
+	feedMQTT(m).adv(stubAdv{addr: net.HardwareAddr{0xf4, 0x8c, 0x50, 1, 2, 3}}, time.Now())
+	n := 0
+	for _, msg := range pub.msgs {
+		if strings.HasPrefix(msg.topic, "homeassistant/") {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("published %d discovery configs, want 1", n)
+	}
+}
+
+/* ---------- 2. センサー ---------- */
+func TestHADiscovery_Sensors(t *testing.T) {
+	m, pub := newTestMQTT(t, mqttOptions{StatusTopic: defaultMQTTStatusTopic})
+	if err := m.enableDiscovery("ha", nil); err != nil {
+		t.Fatal(err)
+	}
+	a := sensorAdv{stubAdv: stubAdv{addr: net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}, name: "GVH5075"},
+		mfg: []byte{0x88, 0xEC, 0x00, 0x03, 0xA5, 0x1F, 0x64, 0x00}}
+	feedMQTT(m).adv(a, time.Now())
+
+	for _, key := range []string{"temperature", "humidity", "battery"} {
+		cfg, ok := findMsg(pub, "ha/sensor/peekbt_a4c138010203_"+key+"/config")
+		if !ok {
+			t.Fatalf("%s config not published", key)
+		}
+		dev := cfg.payload["device"].(map[string]any)
+		if cfg.payload["device_class"] != key || cfg.payload["value_template"] != "{{ value_json.sensor."+key+" }}" ||
+			dev["name"] != "GVH5075" || dev["model"] != peekbt.SensorGovee {
+			t.Fatalf("unexpected %s config: %+v", key, cfg.payload)
+		}
+	}
+	if _, ok := findMsg(pub, "ha/device_tracker/peekbt_a4c138010203/config"); ok {
+		t.Fatalf("tracker published for a device outside the watchlist")
+	}
+	// config は状態より先に送る
+	if !strings.HasPrefix(pub.msgs[0].topic, "ha/") || pub.msgs[len(pub.msgs)-1].payload["event"] != eventNew {
+		t.Fatalf("discovery configs must precede the state message")
+	}
+}
+
+/* ---------- 3. センサー値を含まないパケット ---------- */
+func TestHADiscovery_SensorCarriedForward(t *testing.T) {
+	m, pub := newTestMQTT(t, mqttOptions{StatusTopic: defaultMQTTStatusTopic})
+	if err := m.enableDiscovery("ha", nil); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	addr := net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}
+	f := feedMQTT(m)
+	f.adv(sensorAdv{stubAdv: stubAdv{addr: addr}, mfg: []byte{0x88, 0xEC, 0x00, 0x03, 0xA5, 0x1F, 0x64, 0x00}}, now)
+	// 名前だけを含むスキャンレスポンス
+	f.adv(stubAdv{addr: addr, name: "GVH5075"}, now.Add(time.Minute))
+
+	last := pub.msgs[len(pub.msgs)-1]
+	if last.payload["event"] != eventUpdate || last.payload["name"] != "GVH5075" {
+		t.Fatalf("unexpected last message: %+v", last)
+	}
+	if s, ok := last.payload["sensor"].(map[string]any); !ok || s["temperature"] == nil {
+		t.Fatalf("sensor dropped from state: %+v", last.payload)
+	}
+}
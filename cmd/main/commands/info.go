@@ -1,64 +1,137 @@
 package commands
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
 	"github.com/spf13/cobra"
 )
 
 var (
 	infoTimeout int
 	infoJSON    string
+	infoFilter  string
+	infoAllow   string
+	infoDeny    string
+	infoOut     outputSpec
 )
 
 func init() {
 	infoCmd.Flags().IntVarP(&infoTimeout, "timeout", "t", 10, "Scan timeout in seconds")
 	infoCmd.Flags().StringVarP(&infoJSON, "json", "j", "", "Write JSON output to the specified file")
+	infoCmd.Flags().StringVar(&infoFilter, "filter", "", "Select the first device matching the filter expression instead of <ADDR>")
+	infoCmd.Flags().StringVar(&infoAllow, "allow", "", "Consider only devices listed in the file (addresses, prefixes, company IDs or names)")
+	infoCmd.Flags().StringVar(&infoDeny, "deny", "", "Ignore devices listed in the file (addresses, prefixes, company IDs or names)")
+	addOutputFlags(infoCmd, &infoOut)
 	rootCommand.AddCommand(infoCmd)
 }
 
 var infoCmd = &cobra.Command{
 	Use:   "info [flags] <ADDR>",
 	Short: "Show detailed information for a specific Bluetooth device.",
-	Args:  cobra.ExactArgs(1),
+	Args:  cobra.MaximumNArgs(1),
 	RunE:  runInfoCommand,
 }
 
 // runInfoCommand はフラグ取得と各処理の呼び出しだけを行います
 func runInfoCommand(cmd *cobra.Command, args []string) error {
-	addr := strings.ToLower(args[0])
-	if err := validateAddr(addr); err != nil {
+	target, match, err := buildInfoMatcher(args, infoFilter)
+	if err != nil {
+		return err
+	}
+	if infoJSON != "" {
+		// -j FILE は --format json -o FILE の短縮形
+		if infoOut.Format != "" && infoOut.Format != formatJSON {
+			return fmt.Errorf("--json cannot be combined with --format %s", infoOut.Format)
+		}
+		infoOut.Format, infoOut.Path = formatJSON, infoJSON
+	}
+	if err := infoOut.resolve(); err != nil {
 		return err
 	}
+	allow, deny, err := loadAccessLists(infoAllow, infoDeny)
+	if err != nil {
+		return err
+	}
+	match = buildAdvFilter(match, accessFilter(allow, deny))
 
 	// BLE デバイス初期化
 	if _, err := InitDefaultAdapter(); err != nil {
 		return err
 	}
 
-	// アドバタイズ取得
-	fmt.Printf("Scanning for device %s (timeout %ds)...\n", addr, infoTimeout)
-	adv, err := scanAdvertisement(addr, time.Duration(infoTimeout)*time.Second)
+	// アドレス指定（無ければ完全一致だけの allow リスト）はコントローラ側でも絞り込む
+	var addrs []string
+	if len(args) > 0 {
+		addrs = []string{strings.ToLower(args[0])}
+	}
+	accept, fromAllow := acceptListAddrs(addrs, allow)
+	restore, programmed, err := programAcceptList(senderFor(defaultDev), accept, acceptListTypes(false, false), defaultScanParams)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	if programmed && fromAllow {
+		allow.inController.Store(true)
+	}
+
+	// アドバタイズ取得（機械可読な形式を標準出力に書く場合、進捗は標準エラーへ）
+	progress := os.Stdout
+	if infoOut.toStdout() && infoOut.Format != formatText {
+		progress = os.Stderr
+	}
+	fmt.Fprintf(progress, "Scanning for device %s (timeout %ds)...\n", target, infoTimeout)
+	adv, err := scanAdvertisement(target, match, time.Duration(infoTimeout)*time.Second)
 	if err != nil {
 		return err
 	}
 
 	// 構造体組み立て
-	info := buildDeviceInfo(adv)
+	info := peekbt.NewDeviceInfo(adv)
+	info.LastSeen = scanClock(DefaultScanner)().Format(time.RFC3339)
 
-	// JSON or Key:Value
-	if infoJSON != "" {
-		return writeJSON(info, infoJSON)
+	// 指定形式で出力
+	return infoOut.write(info)
+}
+
+// buildInfoMatcher はアドレス指定またはフィルタ式から対象の判定関数を組み立てます
+func buildInfoMatcher(args []string, expr string) (string, ble.AdvFilter, error) {
+	var addr string
+	if len(args) > 0 {
+		addr = strings.ToLower(args[0])
+		if err := validateAddr(addr); err != nil {
+			return "", nil, err
+		}
 	}
-	printInfo(info)
-	return nil
+	flt, err := parseFilter(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	if addr == "" && flt == nil {
+		return "", nil, fmt.Errorf("either <ADDR> or --filter must be specified")
+	}
+
+	target := addr
+	switch {
+	case flt == nil:
+	case addr == "":
+		target = fmt.Sprintf("matching %q", flt)
+	default:
+		target = fmt.Sprintf("%s matching %q", addr, flt)
+	}
+	return target, func(a ble.Advertisement) bool {
+		if addr != "" && !strings.EqualFold(a.Addr().String(), addr) {
+			return false
+		}
+		return flt == nil || flt.Match(a)
+	}, nil
 }
 
 // validateAddr は引数がMACアドレス形式かをチェックします
@@ -70,98 +143,38 @@ func validateAddr(addr string) error {
 	return nil
 }
 
-// scanAdvertisement はタイムアウト内に Addr が見つかるまでスキャンします
-func scanAdvertisement(addr string, timeout time.Duration) (ble.Advertisement, error) {
+// scanAdvertisement はタイムアウト内に match を満たすデバイスが見つかるまでスキャンします
+func scanAdvertisement(target string, match ble.AdvFilter, timeout time.Duration) (ble.Advertisement, error) {
 	ctx, cancel := NewTimeoutCtx(int(timeout.Seconds()))
 	defer cancel()
 
 	ch := make(chan ble.Advertisement, 1)
+	done := make(chan error, 1)
 	go func() {
-		DefaultScanner.Scan(ctx, true, func(a ble.Advertisement) {
-			if strings.EqualFold(a.Addr().String(), addr) {
-				select {
-				case ch <- a:
-					cancel()
-				default:
-				}
+		done <- DefaultScanner.Scan(ctx, true, func(a ble.Advertisement) {
+			select {
+			case ch <- a:
+				cancel()
+			default:
 			}
-		}, nil)
+		}, match)
 	}()
 
 	select {
 	case adv := <-ch:
 		return adv, nil
+	case err := <-done:
+		// 再生が終端に達した場合など、タイムアウト前にスキャンが終わったとき
+		select {
+		case adv := <-ch:
+			return adv, nil
+		default:
+		}
+		if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("device %s not found", target)
 	case <-ctx.Done():
-		return nil, fmt.Errorf("device %s not found within %v", addr, timeout)
-	}
-}
-
-// deviceInfo は出力用の構造体
-type deviceInfo struct {
-	Address      string   `json:"address"`
-	AddressType  string   `json:"addressType"`
-	Name         string   `json:"name"`
-	RSSI         int      `json:"rssi"`
-	ServicesUUID []string `json:"serviceUUIDs"`
-	LastSeen     string   `json:"lastSeen"`
-	Connectable  bool     `json:"connectable"`
-}
-
-// buildDeviceInfo は Advertisement から deviceInfo を組み立てます
-func buildDeviceInfo(a ble.Advertisement) deviceInfo {
-	uuids := a.Services()
-	s := make([]string, len(uuids))
-	for i, u := range uuids {
-		s[i] = u.String()
-	}
-	return deviceInfo{
-		Address:      a.Addr().String(),
-		AddressType:  getAddressType(a.Addr().String()),
-		Name:         a.LocalName(),
-		RSSI:         a.RSSI(),
-		ServicesUUID: s,
-		LastSeen:     time.Now().Format(time.RFC3339),
-		Connectable:  a.Connectable(),
-	}
-}
-
-// writeJSON は JSON 形式でファイルに書き出します
-func writeJSON(info deviceInfo, file string) error {
-	data, err := json.MarshalIndent(info, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal JSON: %w", err)
-	}
-	data = append(data, '\n')
-	if err := os.WriteFile(file, data, 0o644); err != nil {
-		return fmt.Errorf("failed to write JSON file: %w", err)
-	}
-	return nil
-}
-
-// printInfo は key: value 形式で標準出力します
-func printInfo(info deviceInfo) {
-	fmt.Printf("Address        : %s\n", info.Address)
-	fmt.Printf("Address Type   : %s\n", info.AddressType)
-	fmt.Printf("Name           : %s\n", info.Name)
-	fmt.Printf("RSSI           : %d dBm\n", info.RSSI)
-	fmt.Printf("Services UUIDs : %v\n", info.ServicesUUID)
-	fmt.Printf("Last Seen      : %s\n", info.LastSeen)
-	fmt.Printf("Connectable    : %t\n", info.Connectable)
-}
-
-// getAddressType はアドレスの MSB から種別を返します
-func getAddressType(addrStr string) string {
-	b, _ := strconv.ParseUint(strings.Split(addrStr, ":")[0], 16, 8)
-	switch b & 0xC0 {
-	case 0x00:
-		return "Public"
-	case 0x40:
-		return "Resolvable Private"
-	case 0x80:
-		return "Non-Resolvable Private"
-	case 0xC0:
-		return "Static Random"
-	default:
-		return "Unknown"
+		return nil, fmt.Errorf("device %s not found within %v", target, timeout)
 	}
 }
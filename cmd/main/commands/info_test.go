@@ -3,13 +3,13 @@ package commands
 import (
 	"bytes"
 	"encoding/json"
-	"io"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
 	"github.com/spf13/cobra"
 )
 
@@ -44,29 +44,7 @@ func TestValidateAddr(t *testing.T) {
 
 /*
 	-------------------------------------------------------------
-	  2. getAddressType
-
-----------------------------------------------------------------
-*/
-func TestGetAddressType(t *testing.T) {
-	cases := []struct {
-		addr, want string
-	}{
-		{"00:00:00:00:00:00", "Public"},
-		{"40:00:00:00:00:00", "Resolvable Private"},
-		{"80:00:00:00:00:00", "Non-Resolvable Private"},
-		{"C0:00:00:00:00:00", "Static Random"},
-	}
-	for _, c := range cases {
-		if got := getAddressType(c.addr); got != c.want {
-			t.Errorf("%s => %s, want %s", c.addr, got, c.want)
-		}
-	}
-}
-
-/*
-	-------------------------------------------------------------
-	  3. NewTimeoutCtx
+	  2. NewTimeoutCtx
 
 ----------------------------------------------------------------
 */
@@ -86,7 +64,7 @@ func TestNewTimeoutCtx(t *testing.T) {
 
 /*
 	-------------------------------------------------------------
-	  4. buildDeviceInfo
+	  3. stubAdv
 
 ----------------------------------------------------------------
 */
@@ -109,30 +87,26 @@ func (s stubAdv) SolicitedService() []ble.UUID      { return nil }
 func (s stubAdv) OverflowService() []ble.UUID       { return nil }
 func (s stubAdv) Raw() []byte                       { return nil }
 
-func TestBuildDeviceInfo(t *testing.T) {
-	mac := "01:23:45:67:89:ab"
-	adv := stubAdv{addr: ble.NewAddr(mac), name: "dev", rssi: -50}
-	if got := buildDeviceInfo(adv); got.Address != mac || got.Name != "dev" {
-		t.Errorf("unexpected buildDeviceInfo result: %+v", got)
-	}
-}
-
 /*
 	-------------------------------------------------------------
-	  5. writeJSON
+	  5. JSON ファイル出力
 
 ----------------------------------------------------------------
 */
-func TestWriteJSON(t *testing.T) {
+func TestInfoOutput_JSONFile(t *testing.T) {
 	tmp := t.TempDir()
 	p := filepath.Join(tmp, "d.json")
-	info := deviceInfo{Address: "aa:bb:cc:dd:ee:ff", Name: "foo"}
+	info := peekbt.DeviceInfo{Address: "aa:bb:cc:dd:ee:ff", Name: "foo"}
 
-	if err := writeJSON(info, p); err != nil {
-		t.Fatalf("writeJSON: %v", err)
+	out := outputSpec{Format: formatJSON, Path: p}
+	if err := out.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.write(info); err != nil {
+		t.Fatalf("write: %v", err)
 	}
 	b, _ := os.ReadFile(p)
-	var got deviceInfo
+	var got peekbt.DeviceInfo
 	_ = json.Unmarshal(b, &got)
 	if got.Address != info.Address || got.Name != info.Name {
 		t.Errorf("mismatch: %+v vs %+v", got, info)
@@ -141,27 +115,28 @@ func TestWriteJSON(t *testing.T) {
 
 /*
 	-------------------------------------------------------------
-	  6. printInfo 出力
+	  6. text 形式の出力
 
 ----------------------------------------------------------------
 */
-func TestPrintInfo(t *testing.T) {
-	info := deviceInfo{Address: "aa:bb:cc:dd:ee:ff"}
-	r, w, _ := os.Pipe()
-	old := os.Stdout
-	os.Stdout = w
-	printInfo(info)
-	w.Close()
-	os.Stdout = old
-	out, _ := io.ReadAll(r)
-	if !bytes.Contains(out, []byte("aa:bb:cc:dd:ee:ff")) {
-		t.Fatalf("addr missing in output: %s", out)
+func TestInfoOutput_Text(t *testing.T) {
+	info := peekbt.DeviceInfo{Address: "aa:bb:cc:dd:ee:ff"}
+	out := outputSpec{}
+	if err := out.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := out.render(&buf, info); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("aa:bb:cc:dd:ee:ff")) {
+		t.Fatalf("addr missing in output: %s", buf.String())
 	}
 }
 
 /*
 	-------------------------------------------------------------
-	  7. runInfoCommand : invalid MAC
+	  6. runInfoCommand : invalid MAC
 
 ----------------------------------------------------------------
 */
@@ -170,3 +145,33 @@ func TestRunInfoCommand_InvalidAddr(t *testing.T) {
 		t.Fatalf("expected error on invalid addr")
 	}
 }
+
+/*
+	-------------------------------------------------------------
+	  7. buildInfoMatcher : アドレス／フィルタ指定
+
+----------------------------------------------------------------
+*/
+func TestBuildInfoMatcher(t *testing.T) {
+	adv := stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff"), name: "Tile", rssi: -40}
+
+	if _, _, err := buildInfoMatcher(nil, ""); err == nil {
+		t.Fatalf("expected error when neither addr nor filter is given")
+	}
+	if _, _, err := buildInfoMatcher(nil, "rssi >"); err == nil {
+		t.Fatalf("expected error on invalid filter")
+	}
+
+	_, match, err := buildInfoMatcher(nil, `name =~ "^Ti" && rssi > -50`)
+	if err != nil || !match(adv) {
+		t.Fatalf("filter should match: %v", err)
+	}
+	_, match, _ = buildInfoMatcher([]string{"AA:BB:CC:DD:EE:FF"}, "rssi < -50")
+	if match(adv) {
+		t.Fatalf("addr + filter should not match when filter fails")
+	}
+	_, match, _ = buildInfoMatcher([]string{"aa:bb:cc:dd:ee:ff"}, "")
+	if !match(adv) {
+		t.Fatalf("addr only should match")
+	}
+}
@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	descDevices = prometheus.NewDesc("peekbt_devices",
+		"Number of devices in the device table (seen within the device TTL).", []string{"addr_type", "vendor"}, nil)
+	descWatchRSSI = prometheus.NewDesc("peekbt_device_rssi_dbm",
+		"Last RSSI of a watched device.", []string{"address"}, nil)
+	descWatchSeen = prometheus.NewDesc("peekbt_device_last_seen_timestamp_seconds",
+		"Time a watched device was last seen.", []string{"address"}, nil)
+)
+
+// watchStat はウォッチ対象のデバイスの最後の受信
+type watchStat struct {
+	rssi int
+	seen time.Time
+}
+
+// metricsSink はアドバタイズを Prometheus のメトリクスに集計する eventSink。
+// デバイス数はスクレイプ時にセッションのデバイス表から数えます。
+// ラベルの組み合わせが増えすぎないよう、アドレス毎の値は watch に含まれるものだけ出力します
+type metricsSink struct {
+	reg        *prometheus.Registry
+	advs       *prometheus.CounterVec
+	scanErrors prometheus.Counter
+	table      *peekbt.DeviceTable
+
+	mu    sync.Mutex
+	watch map[string]*watchStat // 未受信なら nil
+}
+
+func newMetricsSink(watch []string, table *peekbt.DeviceTable) *metricsSink {
+	m := &metricsSink{
+		reg: prometheus.NewRegistry(),
+		advs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "peekbt_advertisements_total",
+			Help: "Advertisements received.",
+		}, []string{"addr_type"}),
+		scanErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "peekbt_scan_errors_total",
+			Help: "Scans that failed and were restarted.",
+		}),
+		table: table,
+		watch: make(map[string]*watchStat),
+	}
+	for _, a := range watch {
+		m.watch[a] = nil
+	}
+	m.reg.MustRegister(m.advs, m.scanErrors, m,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// vendorLabel はデバイス表で判明した Company ID をラベル値にします（一覧に無いものは other にまとめる）。
+// Company ID を含まないスキャンレスポンスでラベルが揺れないよう、アドバタイズ単位では判定しません
+func vendorLabel(e *peekbt.Entry) string {
+	switch {
+	case e.Vendor != "":
+		return e.Vendor
+	case e.CompanyID >= 0:
+		return "other"
+	}
+	return "none"
+}
+
+// Event はアドバタイズ（EventAdv）を数え、ウォッチ対象の最後の受信を記録します
+func (m *metricsSink) Event(ev peekbt.Event) {
+	if ev.Type != peekbt.EventAdv {
+		return
+	}
+	m.advs.WithLabelValues(peekbt.AddressType(ev.Adv)).Inc()
+
+	addr := ev.Entry.Info.Address
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.watch[addr]; ok {
+		m.watch[addr] = &watchStat{rssi: ev.Adv.RSSI(), seen: ev.Time}
+	}
+}
+
+// ScanError はスキャンの失敗を数えます
+func (m *metricsSink) ScanError(error) {
+	m.scanErrors.Inc()
+}
+
+// Describe は prometheus.Collector の実装です
+func (m *metricsSink) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descDevices
+	ch <- descWatchRSSI
+	ch <- descWatchSeen
+}
+
+// Collect は prometheus.Collector の実装で、スクレイプ時点の値を出力します
+func (m *metricsSink) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[[2]string]int)
+	for _, e := range m.table.List(nil) {
+		counts[[2]string{e.Info.AddressType, vendorLabel(&e)}]++
+	}
+	for k, n := range counts {
+		ch <- prometheus.MustNewConstMetric(descDevices, prometheus.GaugeValue, float64(n), k[0], k[1])
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, st := range m.watch {
+		if st == nil {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(descWatchRSSI, prometheus.GaugeValue, float64(st.rssi), addr)
+		ch <- prometheus.MustNewConstMetric(descWatchSeen, prometheus.GaugeValue,
+			float64(st.seen.UnixNano())/float64(time.Second), addr)
+	}
+}
+
+// handler は /metrics を返す HTTP ハンドラです
+func (m *metricsSink) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `peekbt exporter: see /metrics`)
+	})
+	return mux
+}
@@ -0,0 +1,98 @@
+package commands
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble/linux/hci"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+func newTestMetrics(watch []string) (*metricsSink, *sinkFeeder) {
+	table := peekbt.NewDeviceTable(time.Minute)
+	m := newMetricsSink(watch, table)
+	return m, newSinkFeeder(table, m, peekbt.EventAdv)
+}
+
+func scrape(t *testing.T, m *metricsSink) string {
+	t.Helper()
+	srv := httptest.NewServer(m.handler())
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
+
+/* ---------- 1. カウンタとデバイス数 ---------- */
+func TestMetricsSink_Counts(t *testing.T) {
+	now := time.Now()
+	m, f := newTestMetrics([]string{"f4:8c:50:01:02:03", "00:00:00:00:00:01"})
+	pub := net.HardwareAddr{0xf4, 0x8c, 0x50, 1, 2, 3}
+	apple := filterAdv{stubAdv: stubAdv{addr: hci.RandomAddress{Addr: net.HardwareAddr{0xc0, 1, 2, 3, 4, 5}}}, mfg: []byte{0x4c, 0x00, 0x10}}
+
+	f.adv(stubAdv{addr: pub, rssi: -50}, now)
+	f.adv(stubAdv{addr: pub, rssi: -42}, now)
+	f.adv(apple, now)
+	m.ScanError(nil)
+
+	out := scrape(t, m)
+	for _, want := range []string{
+		`peekbt_advertisements_total{addr_type="Public"} 2`,
+		`peekbt_advertisements_total{addr_type="Static Random"} 1`,
+		`peekbt_devices{addr_type="Public",vendor="none"} 1`,
+		`peekbt_devices{addr_type="Static Random",vendor="Apple"} 1`,
+		`peekbt_device_rssi_dbm{address="f4:8c:50:01:02:03"} -42`,
+		`peekbt_scan_errors_total 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+	// ウォッチ対象外・未受信のアドレスは出力しない
+	if strings.Contains(out, `address="c0:01:02:03:04:05"`) || strings.Contains(out, `address="00:00:00:00:00:01"`) {
+		t.Errorf("unexpected per-device series:\n%s", out)
+	}
+}
+
+/* ---------- 2. TTL 経過で消える ---------- */
+func TestMetricsSink_TTL(t *testing.T) {
+	now := time.Now()
+	m, f := newTestMetrics([]string{"f4:8c:50:01:02:03"})
+	f.adv(stubAdv{addr: net.HardwareAddr{0xf4, 0x8c, 0x50, 1, 2, 3}, rssi: -60}, now)
+	f.expire(now.Add(2 * time.Minute))
+
+	out := scrape(t, m)
+	if strings.Contains(out, "peekbt_devices{") {
+		t.Errorf("stale device still counted:\n%s", out)
+	}
+	// ウォッチ対象の最終 RSSI は残す
+	if !strings.Contains(out, `peekbt_device_rssi_dbm{address="f4:8c:50:01:02:03"} -60`) {
+		t.Errorf("watched device RSSI dropped")
+	}
+}
+
+/* ---------- 3. ベンダーのラベルを保持する ---------- */
+func TestMetricsSink_VendorSticky(t *testing.T) {
+	now := time.Now()
+	m, f := newTestMetrics(nil)
+	addr := hci.RandomAddress{Addr: net.HardwareAddr{0xc0, 1, 2, 3, 4, 5}}
+	f.adv(filterAdv{stubAdv: stubAdv{addr: addr}, mfg: []byte{0x4c, 0x00, 0x10}}, now)
+	// Company ID を含まないスキャンレスポンス
+	f.adv(stubAdv{addr: addr}, now)
+	// 一覧に無い Company ID
+	f.adv(filterAdv{stubAdv: stubAdv{addr: net.HardwareAddr{0xf4, 0x8c, 0x50, 1, 2, 3}}, mfg: []byte{0xfe, 0xff}}, now)
+
+	out := scrape(t, m)
+	if !strings.Contains(out, `peekbt_devices{addr_type="Static Random",vendor="Apple"} 1`) ||
+		!strings.Contains(out, `peekbt_devices{addr_type="Public",vendor="other"} 1`) || strings.Contains(out, `vendor="none"`) {
+		t.Errorf("vendor label flapped:\n%s", out)
+	}
+}
@@ -0,0 +1,360 @@
+package commands
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+const (
+	defaultMQTTTopic       = "peekbt/{{.Host}}/{{.Address}}/state"
+	defaultMQTTStatusTopic = "peekbt/{{.Host}}/status"
+	mqttOnline             = "online"
+	mqttOffline            = "offline"
+	// mqttPasswordEnv は --mqtt-password を ps に出さずに渡すための環境変数
+	mqttPasswordEnv = "PEEKBT_MQTT_PASSWORD"
+)
+
+// mqttOptions は --mqtt-* の組
+type mqttOptions struct {
+	Broker      string // tcp://host:1883, ssl://host:8883 など
+	ClientID    string
+	Username    string
+	Password    string
+	QoS         int
+	Retain      bool
+	Topic       string // text/template（.Host / .Address / .ID）
+	StatusTopic string
+	Interval    time.Duration // デバイス毎の更新の最短間隔
+	CAFile      string
+	CertFile    string
+	KeyFile     string
+	Insecure    bool
+}
+
+// mqttTopicData はトピックのテンプレートに渡す値
+type mqttTopicData struct {
+	Host    string
+	Address string // aa:bb:cc:dd:ee:ff
+	ID      string // aabbccddeeff（":" を含められない用途向け）
+}
+
+func newTopicData(host, addr string) mqttTopicData {
+	return mqttTopicData{Host: host, Address: addr, ID: strings.ReplaceAll(addr, ":", "")}
+}
+
+// mqttPublisher は MQTT クライアントのうち sink が使う部分（テストで差し替える）
+type mqttPublisher interface {
+	Publish(topic string, qos byte, retained bool, payload []byte) error
+	Close()
+}
+
+// mqttSink はセッションのデバイス表の出現・変化・消失を MQTT に publish する eventSink。
+// 変化のたびに送ると流量が多いため、同じデバイスの update は Interval 毎にまとめます
+type mqttSink struct {
+	pub   mqttPublisher
+	opts  mqttOptions
+	host  string
+	topic *template.Template
+	onErr func(error)
+	ha    *haDiscovery // Home Assistant discovery（無効なら nil）
+
+	// mu は状態の更新と publish の順序を守るロック。
+	// どちらのマップもデバイス表にあるデバイスだけを持ち、lost で消えます
+	mu      sync.Mutex
+	sent    map[string]time.Time         // デバイス毎に最後に送った時刻
+	pending map[string]peekbt.DeviceInfo // Interval 内に届いた update のうち最新のもの
+}
+
+func newMQTTSink(pub mqttPublisher, opts mqttOptions, host string) (*mqttSink, error) {
+	t, err := parseTopic(opts.Topic)
+	if err != nil {
+		return nil, err
+	}
+	return &mqttSink{
+		pub:     pub,
+		opts:    opts,
+		host:    host,
+		topic:   t,
+		onErr:   func(err error) { fmt.Fprintln(os.Stderr, err) },
+		sent:    make(map[string]time.Time),
+		pending: make(map[string]peekbt.DeviceInfo),
+	}, nil
+}
+
+func parseTopic(s string) (*template.Template, error) {
+	t, err := template.New("topic").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT topic template: %w", err)
+	}
+	return t, nil
+}
+
+func execTopic(t *template.Template, d mqttTopicData) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("invalid MQTT topic template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// Event は new と lost を即座に、update を Interval 毎に publish します
+func (m *mqttSink) Event(ev peekbt.Event) {
+	info := ev.Entry.Info
+	addr := info.Address
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch ev.Type {
+	case peekbt.EventNew:
+		m.sent[addr] = ev.Time
+	case peekbt.EventUpdate:
+		if ev.Time.Sub(m.sent[addr]) < m.opts.Interval {
+			m.pending[addr] = info
+			return
+		}
+		m.sent[addr] = ev.Time
+	case peekbt.EventLost:
+		delete(m.sent, addr)
+	default:
+		return
+	}
+	delete(m.pending, addr)
+	m.publish(string(ev.Type), info, ev.Time)
+	if ev.Type == peekbt.EventLost && m.ha != nil {
+		m.ha.forget(addr)
+	}
+}
+
+// flush は Interval を過ぎた保留中の update を publish します
+func (m *mqttSink) flush(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, info := range m.pending {
+		if now.Sub(m.sent[addr]) >= m.opts.Interval {
+			m.sent[addr] = now
+			delete(m.pending, addr)
+			m.publish(eventUpdate, info, now)
+		}
+	}
+}
+
+// run は ctx が終わるまで定期的に保留中の update を送ります
+func (m *mqttSink) run(ctx context.Context) {
+	if m.opts.Interval <= 0 {
+		return
+	}
+	t := time.NewTicker(m.opts.Interval / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			m.flush(now)
+		}
+	}
+}
+
+// publish は 1 件を送ります（mu を保持して呼ぶ）
+func (m *mqttSink) publish(event string, info peekbt.DeviceInfo, now time.Time) {
+	topic, err := execTopic(m.topic, newTopicData(m.host, info.Address))
+	if err != nil {
+		m.onErr(err)
+		return
+	}
+	m.announce(info)
+	payload, err := json.Marshal(scanEvent{Event: event, Timestamp: now.Format(time.RFC3339Nano), DeviceInfo: info})
+	if err != nil {
+		m.onErr(fmt.Errorf("failed to marshal JSON: %w", err))
+		return
+	}
+	if err := m.pub.Publish(topic, byte(m.opts.QoS), m.opts.Retain, payload); err != nil {
+		m.onErr(err)
+	}
+}
+
+// Close はブローカーとの接続を閉じます
+func (m *mqttSink) Close() {
+	m.pub.Close()
+}
+
+/* ---------- paho クライアント ---------- */
+
+// 完了を確認する publish の上限と、1 件の完了を待つ時間。
+// ブローカーに届かない間も確認待ちが際限なく溜まらないようにする
+const (
+	mqttPendingMax     = 256
+	mqttPublishTimeout = 30 * time.Second
+)
+
+// pahoPublisher は paho.mqtt.golang による mqttPublisher
+type pahoPublisher struct {
+	c      mqtt.Client
+	status string
+	qos    byte
+
+	// 完了待ちの publish（1 つの goroutine が順に確認して失敗を log に報告する）
+	pending   chan pendingPublish
+	untracked atomic.Int64 // pending が溢れて確認しなかった件数
+	log       io.Writer
+	quit      chan struct{}
+}
+
+// pendingPublish は完了を待つ publish 1 件
+type pendingPublish struct {
+	topic string
+	tok   mqtt.Token
+}
+
+// newPahoPublisher は c で publish する pahoPublisher を返し、完了の確認を始めます
+func newPahoPublisher(c mqtt.Client, status string, qos byte) *pahoPublisher {
+	p := &pahoPublisher{c: c, status: status, qos: qos,
+		pending: make(chan pendingPublish, mqttPendingMax), log: os.Stderr, quit: make(chan struct{})}
+	go p.watch()
+	return p
+}
+
+// dialMQTT はブローカーに接続します。
+// 接続が切れても自動で再接続し、異常終了時は LWT により status トピックが offline になります
+func dialMQTT(opts mqttOptions, host string) (*pahoPublisher, error) {
+	if opts.QoS < 0 || opts.QoS > 2 {
+		return nil, fmt.Errorf("invalid --mqtt-qos %d (want 0, 1 or 2)", opts.QoS)
+	}
+	u, err := url.Parse(opts.Broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid MQTT broker URL %q (e.g. tcp://localhost:1883)", opts.Broker)
+	}
+	st, err := parseTopic(opts.StatusTopic)
+	if err != nil {
+		return nil, err
+	}
+	status, err := execTopic(st, newTopicData(host, ""))
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg, err := mqttTLSConfig(opts, u.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	qos := byte(opts.QoS)
+	co := mqtt.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetWill(status, mqttOffline, qos, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(10 * time.Second).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			c.Publish(status, qos, true, mqttOnline)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			fmt.Fprintf(os.Stderr, "MQTT connection lost: %v\n", err)
+		})
+	if tlsCfg != nil {
+		co.SetTLSConfig(tlsCfg)
+	}
+	p := newPahoPublisher(mqtt.NewClient(co), status, qos)
+
+	// 接続できるまで再試行が続くため、最初の接続だけ待って結果を知らせる
+	tok := p.c.Connect()
+	if !tok.WaitTimeout(10 * time.Second) {
+		fmt.Fprintf(os.Stderr, "MQTT broker %s not reachable yet, retrying in background\n", opts.Broker)
+	} else if err := tok.Error(); err != nil {
+		close(p.quit)
+		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	return p, nil
+}
+
+// mqttTLSConfig は TLS 関連のフラグから tls.Config を組み立てます（不要なら nil）
+func mqttTLSConfig(opts mqttOptions, scheme string) (*tls.Config, error) {
+	secure := scheme == "ssl" || scheme == "tls" || scheme == "mqtts" || scheme == "wss"
+	if !secure && opts.CAFile == "" && opts.CertFile == "" && !opts.Insecure {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: opts.Insecure, MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Publish はメッセージを送ります。QoS 1/2 の完了は待たず、失敗のみ非同期に報告します。
+// 確認待ちが mqttPendingMax 件を超えた分は結果を確認しません
+func (p *pahoPublisher) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	tok := p.c.Publish(topic, qos, retained, payload)
+	select {
+	case p.pending <- pendingPublish{topic: topic, tok: tok}:
+	default:
+		p.untracked.Add(1)
+	}
+	return nil
+}
+
+// watch は publish の完了を順に待ち、失敗とタイムアウトを報告します
+func (p *pahoPublisher) watch() {
+	for {
+		var pp pendingPublish
+		select {
+		case pp = <-p.pending:
+		case <-p.quit:
+			return
+		}
+		timer := time.NewTimer(mqttPublishTimeout)
+		select {
+		case <-pp.tok.Done():
+			if err := pp.tok.Error(); err != nil {
+				fmt.Fprintf(p.log, "failed to publish to %s: %v\n", pp.topic, err)
+			}
+		case <-timer.C:
+			fmt.Fprintf(p.log, "failed to publish to %s: no acknowledgement within %v\n", pp.topic, mqttPublishTimeout)
+		case <-p.quit:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// Close は offline を publish してから切断します
+func (p *pahoPublisher) Close() {
+	if p.c.IsConnected() {
+		p.c.Publish(p.status, p.qos, true, mqttOffline).WaitTimeout(2 * time.Second)
+	}
+	p.c.Disconnect(250)
+	close(p.quit)
+	if n := p.untracked.Load(); n > 0 {
+		fmt.Fprintf(p.log, "MQTT: %d publishes were not checked for errors (broker too slow)\n", n)
+	}
+}
@@ -0,0 +1,252 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+type published struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  map[string]any
+}
+
+// fakePublisher は publish されたメッセージを記録します
+type fakePublisher struct {
+	mu     sync.Mutex
+	msgs   []published
+	closed bool
+}
+
+func (f *fakePublisher) Publish(topic string, qos byte, retained bool, payload []byte) error {
+	var m map[string]any
+	json.Unmarshal(payload, &m)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.msgs = append(f.msgs, published{topic, qos, retained, m})
+	return nil
+}
+
+func (f *fakePublisher) Close() { f.closed = true }
+
+func (f *fakePublisher) events() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ev []string
+	for _, m := range f.msgs {
+		ev = append(ev, m.payload["event"].(string))
+	}
+	return ev
+}
+
+func newTestMQTT(t *testing.T, opts mqttOptions) (*mqttSink, *fakePublisher) {
+	t.Helper()
+	if opts.Topic == "" {
+		opts.Topic = defaultMQTTTopic
+	}
+	pub := &fakePublisher{}
+	m, err := newMQTTSink(pub, opts, "pi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, pub
+}
+
+// feedMQTT は m に new / update / lost を渡す sinkFeeder を返します（TTL 1 分）
+func feedMQTT(m *mqttSink) *sinkFeeder {
+	return newSinkFeeder(peekbt.NewDeviceTable(time.Minute), m, peekbt.EventNew, peekbt.EventUpdate, peekbt.EventLost)
+}
+
+/* ---------- 1. 新規・更新・消失 ---------- */
+func TestMQTTSink_Events(t *testing.T) {
+	m, pub := newTestMQTT(t, mqttOptions{QoS: 1, Retain: true, Interval: 10 * time.Second})
+	f := feedMQTT(m)
+	now := time.Now()
+	addr := net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}
+
+	f.adv(stubAdv{addr: addr, rssi: -50}, now)
+	f.adv(stubAdv{addr: addr, name: "Tile", rssi: -51}, now.Add(time.Second))     // 間隔内の変化は保留する
+	m.flush(now.Add(5 * time.Second))                                             // まだ間隔内
+	m.flush(now.Add(11 * time.Second))                                            // 間隔経過後に送る
+	f.adv(stubAdv{addr: addr, name: "Tile", rssi: -60}, now.Add(30*time.Second))  // RSSI だけの変化は送らない
+	f.adv(stubAdv{addr: addr, name: "Tile2", rssi: -60}, now.Add(40*time.Second)) // 間隔経過後の変化はすぐに送る
+	f.expire(now.Add(time.Minute))                                                // まだ TTL 内
+	f.expire(now.Add(2 * time.Minute))
+
+	want := []string{eventNew, eventUpdate, eventUpdate, eventLost}
+	got := pub.events()
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+	msg := pub.msgs[1]
+	if msg.topic != "peekbt/pi/a4:c1:38:01:02:03/state" || msg.qos != 1 || !msg.retained || msg.payload["rssi"] != -51.0 || msg.payload["name"] != "Tile" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+/* ---------- 2. トピックのテンプレートとセンサー値 ---------- */
+func TestMQTTSink_TopicAndSensor(t *testing.T) {
+	m, pub := newTestMQTT(t, mqttOptions{Topic: "home/{{.Host}}/ble/{{.ID}}"})
+	a := sensorAdv{stubAdv: stubAdv{addr: net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}},
+		mfg: []byte{0x88, 0xEC, 0x00, 0x03, 0xA5, 0x1F, 0x64, 0x00}}
+	feedMQTT(m).adv(a, time.Now())
+
+	msg := pub.msgs[0]
+	if msg.topic != "home/pi/ble/a4c138010203" {
+		t.Fatalf("topic = %q", msg.topic)
+	}
+	sensor, ok := msg.payload["sensor"].(map[string]any)
+	if !ok || sensor["temperature"] != 23.8 || sensor["battery"] != 100.0 {
+		t.Fatalf("sensor payload = %v", msg.payload["sensor"])
+	}
+}
+
+/* ---------- 3. 不正な設定 ---------- */
+func TestMQTT_InvalidOptions(t *testing.T) {
+	if _, err := newMQTTSink(&fakePublisher{}, mqttOptions{Topic: "x/{{.Nope"}, "pi"); err == nil {
+		t.Errorf("expected error on broken topic template")
+	}
+	m, _ := newMQTTSink(&fakePublisher{}, mqttOptions{Topic: "x/{{.Nope}}"}, "pi")
+	var errs []error
+	m.onErr = func(err error) { errs = append(errs, err) }
+	feedMQTT(m).adv(stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff")}, time.Now())
+	if len(errs) != 1 {
+		t.Errorf("expected topic execution error, got %v", errs)
+	}
+
+	base := mqttOptions{Broker: "tcp://localhost:1883", StatusTopic: defaultMQTTStatusTopic}
+	bad := base
+	bad.QoS = 3
+	if _, err := dialMQTT(bad, "pi"); err == nil {
+		t.Errorf("expected error on QoS 3")
+	}
+	bad = base
+	bad.Broker = "localhost"
+	if _, err := dialMQTT(bad, "pi"); err == nil {
+		t.Errorf("expected error on broker without scheme")
+	}
+}
+
+/* ---------- 4. TLS 設定 ---------- */
+func TestMQTTTLSConfig(t *testing.T) {
+	if cfg, err := mqttTLSConfig(mqttOptions{}, "tcp"); cfg != nil || err != nil {
+		t.Errorf("plain tcp: cfg=%v err=%v", cfg, err)
+	}
+	if cfg, err := mqttTLSConfig(mqttOptions{}, "ssl"); cfg == nil || err != nil {
+		t.Errorf("ssl: cfg=%v err=%v", cfg, err)
+	}
+	if cfg, _ := mqttTLSConfig(mqttOptions{Insecure: true}, "tcp"); cfg == nil || !cfg.InsecureSkipVerify {
+		t.Errorf("--mqtt-insecure not applied")
+	}
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(ca, []byte("not a certificate"), 0o644)
+	if _, err := mqttTLSConfig(mqttOptions{CAFile: ca}, "ssl"); err == nil {
+		t.Errorf("expected error on invalid CA file")
+	}
+	if _, err := mqttTLSConfig(mqttOptions{CertFile: ca, KeyFile: ca}, "ssl"); err == nil {
+		t.Errorf("expected error on invalid client certificate")
+	}
+}
+
+/* ---------- 5. publish の完了確認 ---------- */
+
+// fakeToken は完了を外から制御する mqtt.Token
+type fakeToken struct {
+	done chan struct{}
+	err  error
+}
+
+func (t *fakeToken) Wait() bool                       { <-t.done; return true }
+func (t *fakeToken) WaitTimeout(d time.Duration) bool { return t.Wait() }
+func (t *fakeToken) Done() <-chan struct{}            { return t.done }
+func (t *fakeToken) Error() error                     { return t.err }
+
+// fakeMQTTClient は publish の度に tokens を順に返す mqtt.Client
+type fakeMQTTClient struct {
+	mqtt.Client
+	tokens chan *fakeToken
+}
+
+func (c *fakeMQTTClient) Publish(string, byte, bool, any) mqtt.Token { return <-c.tokens }
+func (c *fakeMQTTClient) IsConnected() bool                          { return false }
+func (c *fakeMQTTClient) Disconnect(uint)                            {}
+
+func TestPahoPublisher_Pending(t *testing.T) {
+	const n = mqttPendingMax + 100
+	c := &fakeMQTTClient{tokens: make(chan *fakeToken, n+1)}
+	stuck := &fakeToken{done: make(chan struct{})} // ブローカーに届かない
+	for range n {
+		c.tokens <- stuck
+	}
+	failed := &fakeToken{done: make(chan struct{}), err: errors.New("not authorized")}
+	close(failed.done)
+	c.tokens <- failed
+
+	log := &syncBuffer{}
+	p := newPahoPublisher(c, "peekbt/pi/status", 1)
+	p.log = log
+	before := runtime.NumGoroutine()
+	for range n {
+		p.Publish("peekbt/pi/x/state", 1, false, nil)
+	}
+	// 確認待ちは溜まらず、溢れた分だけ数える
+	if g := runtime.NumGoroutine(); g > before+1 {
+		t.Errorf("goroutines grew from %d to %d", before, g)
+	}
+	if got := p.untracked.Load(); got < n-mqttPendingMax-1 {
+		t.Errorf("untracked %d", got)
+	}
+
+	// 詰まっていた publish が終われば、続く失敗が報告される
+	close(stuck.done)
+	time.Sleep(10 * time.Millisecond)
+	p.Publish("peekbt/pi/y/state", 1, false, nil)
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(log.String(), "failed to publish to peekbt/pi/y/state: not authorized") {
+		if time.Now().After(deadline) {
+			t.Fatalf("failure not reported:\n%s", log)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	p.Close()
+	if !strings.Contains(log.String(), "were not checked") {
+		t.Errorf("untracked count not reported:\n%s", log)
+	}
+}
+
+// syncBuffer は並行に書き込める bytes.Buffer
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
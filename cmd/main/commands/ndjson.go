@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// NDJSON などで出力するイベント種別
+const (
+	eventAdv    = string(peekbt.EventAdv)
+	eventNew    = string(peekbt.EventNew)
+	eventUpdate = string(peekbt.EventUpdate)
+	eventLost   = string(peekbt.EventLost)
+)
+
+// scanEvent は NDJSON 1 行分のレコード。DeviceInfo のフィールドはフラットに展開されます
+type scanEvent struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	peekbt.DeviceInfo
+}
+
+// ndjsonWriter はスキャン結果を 1 行 1 JSON で書き出します。
+// changes が false ならアドバタイズ毎に adv を、true ならセッションのデバイス表の変化
+// （new / update / lost）のみを出力します。変化の判定はデバイス表で行い、ここでは状態を持ちません
+type ndjsonWriter struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	closer  io.Closer
+	changes bool
+}
+
+// newNDJSONWriter は path（"-" なら標準出力）への書き込み口を作ります
+func newNDJSONWriter(path string, changes bool) (*ndjsonWriter, error) {
+	var w io.Writer = os.Stdout
+	var c io.Closer
+	if path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create JSON file: %w", err)
+		}
+		w, c = f, f
+	}
+	return &ndjsonWriter{
+		enc:     json.NewEncoder(w),
+		closer:  c,
+		changes: changes,
+	}, nil
+}
+
+// Advertisement は受信したアドバタイズを記録します（adv モードのみ。n が nil なら何もしません）
+func (n *ndjsonWriter) Advertisement(a ble.Advertisement, now time.Time) error {
+	if n == nil || n.changes {
+		return nil
+	}
+	info := peekbt.NewDeviceInfo(a)
+	info.LastSeen = now.Format(time.RFC3339)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.write(eventAdv, info, now)
+}
+
+// Change はデバイス表の変化（eventNew / eventUpdate / eventLost）を記録します（changes モードのみ）
+func (n *ndjsonWriter) Change(event string, info peekbt.DeviceInfo, now time.Time) error {
+	if n == nil || !n.changes {
+		return nil
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.write(event, info, now)
+}
+
+// Event はイベントバスから受け取ったイベントを記録します
+func (n *ndjsonWriter) Event(ev peekbt.Event) error {
+	if ev.Type == peekbt.EventAdv {
+		return n.Advertisement(ev.Adv, ev.Time)
+	}
+	return n.Change(string(ev.Type), ev.Entry.Info, ev.Time)
+}
+
+// eventTypes は出力するモードで必要なイベント種別を返します
+func (n *ndjsonWriter) eventTypes() []peekbt.EventType {
+	if n.changes {
+		return []peekbt.EventType{peekbt.EventNew, peekbt.EventUpdate, peekbt.EventLost}
+	}
+	return []peekbt.EventType{peekbt.EventAdv}
+}
+
+// Close は出力先ファイルを閉じます（標準出力は閉じません）
+func (n *ndjsonWriter) Close() error {
+	if n.closer == nil {
+		return nil
+	}
+	return n.closer.Close()
+}
+
+func (n *ndjsonWriter) write(event string, info peekbt.DeviceInfo, now time.Time) error {
+	ev := scanEvent{Event: event, Timestamp: now.Format(time.RFC3339Nano), DeviceInfo: info}
+	if err := n.enc.Encode(ev); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+func readEvents(t *testing.T, p string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var evs []map[string]any
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var m map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", sc.Text(), err)
+		}
+		evs = append(evs, m)
+	}
+	return evs
+}
+
+/* ---------- 1. アドバタイズ毎 ---------- */
+func TestNDJSONWriter_Adv(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "scan.ndjson")
+	w, err := newNDJSONWriter(p, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adv := stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff"), name: "dev", rssi: -40}
+	now := time.Now()
+	w.Advertisement(adv, now)
+	w.Advertisement(adv, now)
+	w.Change(eventLost, peekbt.NewDeviceInfo(adv), now)
+	w.Close()
+
+	evs := readEvents(t, p)
+	if len(evs) != 2 {
+		t.Fatalf("got %d events, want 2", len(evs))
+	}
+	if evs[0]["event"] != eventAdv || evs[0]["address"] != "aa:bb:cc:dd:ee:ff" || evs[0]["timestamp"] == "" {
+		t.Fatalf("unexpected event: %v", evs[0])
+	}
+	if evs[0]["rssi"].(float64) != -40 || evs[0]["name"] != "dev" {
+		t.Fatalf("DeviceInfo fields should be flattened: %v", evs[0])
+	}
+}
+
+/* ---------- 2. テーブル変化のみ ---------- */
+func TestNDJSONWriter_Changes(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "scan.ndjson")
+	w, err := newNDJSONWriter(p, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	adv := stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff"), rssi: -40}
+	w.Advertisement(adv, now)
+	w.Change(eventNew, peekbt.NewDeviceInfo(adv), now)
+	w.Change(eventLost, peekbt.NewDeviceInfo(adv), now.Add(time.Second))
+	w.Close()
+
+	var got []string
+	for _, e := range readEvents(t, p) {
+		got = append(got, e["event"].(string))
+		if e["address"] != "aa:bb:cc:dd:ee:ff" {
+			t.Fatalf("unexpected event: %v", e)
+		}
+	}
+	want := []string{eventNew, eventLost}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,273 @@
+package commands
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/spf13/cobra"
+)
+
+// notify の出力形式
+const (
+	notifyHex     = "hex"
+	notifyDecoded = "decoded"
+	notifyNDJSON  = "ndjson"
+)
+
+// notifyTimeLayout は hex / decoded 形式のタイムスタンプ（ミリ秒まで）
+const notifyTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// 再接続の間隔（失敗する度に倍にして notifyRetryMax まで延ばす）
+var (
+	notifyRetry    = time.Second
+	notifyRetryMax = 30 * time.Second
+)
+
+var (
+	notifyConn     connOptions
+	notifyValue    valueOptions
+	notifyFormat   string
+	notifyIndicate bool
+	notifyCount    int
+	notifyDuration time.Duration
+)
+
+var notifyCommand = &cobra.Command{
+	Use:   "notify [flags] <ADDR> <char-uuid|handle>",
+	Short: "Subscribe to a characteristic and stream its notifications or indications.",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runNotifyCommand,
+}
+
+func init() {
+	addConnFlags(notifyCommand, &notifyConn)
+	addValueFlags(notifyCommand, &notifyValue)
+	notifyCommand.Flags().StringVar(&notifyFormat, "format", notifyHex, "Output format: hex|decoded|ndjson")
+	notifyCommand.Flags().BoolVar(&notifyIndicate, "indicate", false, "Subscribe to indications (default: notifications, or indications if the characteristic only indicates)")
+	notifyCommand.Flags().IntVar(&notifyCount, "count", 0, "Stop after receiving this many values (0 = unlimited)")
+	notifyCommand.Flags().DurationVar(&notifyDuration, "duration", 0, "Stop after this long (0 = until Ctrl-C)")
+	rootCommand.AddCommand(notifyCommand)
+}
+
+func runNotifyCommand(cmd *cobra.Command, args []string) error {
+	codec, err := notifyValue.codec()
+	if err != nil {
+		return err
+	}
+	// decoded で変換方法の指定がなければ文字列として表示する
+	decode := notifyValue != (valueOptions{})
+	switch notifyFormat {
+	case notifyHex:
+	case notifyDecoded:
+		if !decode {
+			codec.Encoding = encodingString
+		}
+		decode = true
+	case notifyNDJSON:
+	default:
+		return fmt.Errorf("invalid format %q (want hex|decoded|ndjson)", notifyFormat)
+	}
+	if notifyCount < 0 || notifyDuration < 0 {
+		return fmt.Errorf("--count and --duration must not be negative")
+	}
+
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
+	if notifyDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, notifyDuration)
+		defer cancel()
+	}
+	n := &notifier{
+		conn:     &notifyConn,
+		addr:     args[0],
+		spec:     args[1],
+		indicate: notifyIndicate,
+		count:    notifyCount,
+		codec:    codec,
+		decode:   decode,
+		emit:     newNotifyPrinter(cmd.OutOrStdout(), notifyFormat),
+		log:      cmd.ErrOrStderr(),
+		now:      time.Now,
+	}
+	return n.run(ctx)
+}
+
+// notification は受信した通知 / 指示 1 件（NDJSON 1 行分）
+type notification struct {
+	Event     string `json:"event"` // notification | indication
+	Timestamp string `json:"timestamp"`
+	Address   string `json:"address"`
+	UUID      string `json:"uuid"`
+	Handle    uint16 `json:"handle"`
+	Hex       string `json:"hex"`
+	Value     any    `json:"value,omitempty"`
+	Error     string `json:"error,omitempty"` // 変換できなかった理由
+
+	time time.Time
+}
+
+// newNotifyPrinter は format で w に書き出す関数を返します
+func newNotifyPrinter(w io.Writer, format string) func(notification) error {
+	if format == notifyNDJSON {
+		enc := json.NewEncoder(w)
+		return func(n notification) error { return enc.Encode(n) }
+	}
+	return func(n notification) error {
+		v := n.Hex
+		switch {
+		case format == notifyHex:
+		case n.Error != "":
+			v = fmt.Sprintf("%s (%s)", n.Hex, n.Error)
+		default:
+			v = fmt.Sprint(n.Value)
+		}
+		_, err := fmt.Fprintf(w, "%s %s\n", n.time.Format(notifyTimeLayout), v)
+		return err
+	}
+}
+
+// notifier は接続・購読を続け、リンクが切れたら再接続します
+type notifier struct {
+	conn     *connOptions
+	addr     string
+	spec     string
+	indicate bool
+	count    int // 0 なら無制限
+	codec    valueCodec
+	decode   bool // false なら Value を付けない
+	emit     func(notification) error
+	log      io.Writer
+	now      func() time.Time
+
+	received   int
+	subscribed bool // 一度でも購読できたか
+}
+
+// run は ctx が終わるか count 件受信するまで通知を流します。
+// 最初の接続・購読に失敗した場合はエラーを返し、一度購読できた後は再接続を続けます
+func (n *notifier) run(ctx context.Context) error {
+	delay := notifyRetry
+	for {
+		done, err := n.session(ctx)
+		switch {
+		case ctx.Err() != nil && n.subscribed:
+			return nil
+		case done || !n.subscribed:
+			return err
+		}
+		if err != nil {
+			fmt.Fprintf(n.log, "%v; reconnecting in %v\n", err, delay)
+		} else {
+			fmt.Fprintf(n.log, "%s disconnected; reconnecting\n", n.addr)
+			delay = 0 // 切断直後はすぐに再接続を試みる
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(max(delay*2, notifyRetry), notifyRetryMax)
+	}
+}
+
+// session は 1 回の接続で購読し、切断されるまで通知を流します。
+// done は ctx の終了か count に達して終わった（再接続しない）ことを表します
+func (n *notifier) session(ctx context.Context) (done bool, err error) {
+	cln, c, err := connectCharacteristic(ctx, n.conn, n.addr, n.spec)
+	if err != nil {
+		return ctx.Err() != nil, err
+	}
+	defer cln.CancelConnection()
+
+	ind, err := subscribeMode(c, n.indicate)
+	if err != nil {
+		return false, err
+	}
+	event := "notification"
+	if ind {
+		event = "indication"
+	}
+	ch := make(chan notification, 64)
+	quit := make(chan struct{})
+	defer close(quit)
+	h := func(b []byte) {
+		at := n.now()
+		v := notification{Event: event, Timestamp: at.Format(time.RFC3339Nano), Address: cln.Addr().String(),
+			UUID: c.UUID.String(), Handle: c.ValueHandle, Hex: hex.EncodeToString(b), time: at}
+		if n.decode {
+			if val, err := n.codec.decode(b); err != nil {
+				v.Error = err.Error()
+			} else {
+				v.Value = val
+			}
+		}
+		select {
+		case ch <- v:
+		case <-quit:
+		}
+	}
+	if err := cln.Subscribe(c, ind, h); err != nil {
+		return false, fmt.Errorf("failed to subscribe to %s: %w", c.UUID, err)
+	}
+	if n.subscribed {
+		fmt.Fprintf(n.log, "reconnected to %s\n", n.addr)
+	}
+	n.subscribed = true
+
+	// deliver は 1 件を書き出し、count に達したかを返します
+	deliver := func(v notification) (bool, error) {
+		if err := n.emit(v); err != nil {
+			return true, err
+		}
+		n.received++
+		return n.count > 0 && n.received >= n.count, nil
+	}
+	for {
+		select {
+		case v := <-ch:
+			if done, err := deliver(v); done {
+				cln.Unsubscribe(c, ind)
+				return true, err
+			}
+		case <-cln.Disconnected():
+			// 切断される前に届いていた分を流す
+			for {
+				select {
+				case v := <-ch:
+					if done, err := deliver(v); done {
+						return true, err
+					}
+				default:
+					return false, nil
+				}
+			}
+		case <-ctx.Done():
+			cln.Unsubscribe(c, ind)
+			return true, nil
+		}
+	}
+}
+
+// subscribeMode は購読する種類（true なら indication）を決めます。
+// notify がなく indicate だけのキャラクタリスティックは indication で購読します
+func subscribeMode(c *ble.Characteristic, indicate bool) (bool, error) {
+	switch {
+	case c.CCCD == nil:
+		return false, fmt.Errorf("characteristic %s has no Client Characteristic Configuration descriptor", c.UUID)
+	case indicate && c.Property&ble.CharIndicate == 0:
+		return false, fmt.Errorf("characteristic %s does not support indications", c.UUID)
+	case indicate:
+		return true, nil
+	case c.Property&ble.CharNotify != 0:
+		return false, nil
+	case c.Property&ble.CharIndicate != 0:
+		return true, nil
+	}
+	return false, fmt.Errorf("characteristic %s does not support notifications or indications", c.UUID)
+}
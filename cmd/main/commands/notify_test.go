@@ -0,0 +1,225 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+// useFakeDialers は接続する度に clients を順に返すよう DefaultDialer を差し替えます
+func useFakeDialers(t *testing.T, clients ...*fakeClient) {
+	t.Helper()
+	orig, origRetry := DefaultDialer, notifyRetry
+	notifyRetry = time.Millisecond
+	DefaultDialer = func(ctx context.Context, a ble.Addr) (ble.Client, error) {
+		if len(clients) == 0 {
+			return nil, errors.New("device not reachable")
+		}
+		cln := clients[0]
+		clients = clients[1:]
+		return cln, nil
+	}
+	t.Cleanup(func() { DefaultDialer, notifyRetry = orig, origRetry })
+}
+
+// waitSubscribed は cln が購読されるまで待ちます
+func waitSubscribed(t *testing.T, cln *fakeClient) {
+	t.Helper()
+	select {
+	case <-cln.subscribed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("not subscribed")
+	}
+}
+
+func newTestNotifier(count int, emit func(notification) error) (*notifier, *bytes.Buffer) {
+	log := &bytes.Buffer{}
+	return &notifier{
+		conn:   &connOptions{Connect: time.Second, Discover: time.Second},
+		addr:   "aa:bb:cc:dd:ee:ff",
+		spec:   "2a19",
+		count:  count,
+		codec:  valueCodec{Encoding: encodingUint8},
+		decode: true,
+		emit:   emit,
+		log:    log,
+		now:    time.Now,
+	}, log
+}
+
+/* ---------- 1. 購読と件数の上限 ---------- */
+func TestNotifier_Count(t *testing.T) {
+	cln := newFakeClient(sampleGATT()...)
+	useFakeDialers(t, cln)
+	var got []notification
+	n, _ := newTestNotifier(2, func(v notification) error { got = append(got, v); return nil })
+
+	errc := make(chan error, 1)
+	go func() { errc <- n.run(context.Background()) }()
+	waitSubscribed(t, cln)
+	cln.notify(0x0012, []byte{90})
+	cln.notify(0x0012, []byte{1, 2})
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Value != uint64(90) || got[0].Event != "notification" || got[0].UUID != "2a19" ||
+		got[1].Value != nil || got[1].Hex != "0102" || got[1].Error == "" {
+		t.Errorf("unexpected notifications %+v", got)
+	}
+	if cln.unsubscribed != 1 || !cln.canceled {
+		t.Errorf("unsubscribed %d, disconnected %v", cln.unsubscribed, cln.canceled)
+	}
+}
+
+/* ---------- 2. 再接続 ---------- */
+func TestNotifier_Reconnect(t *testing.T) {
+	first, second := newFakeClient(sampleGATT()...), newFakeClient(sampleGATT()...)
+	useFakeDialers(t, first, second)
+	var got []string
+	n, log := newTestNotifier(3, func(v notification) error { got = append(got, v.Hex); return nil })
+
+	errc := make(chan error, 1)
+	go func() { errc <- n.run(context.Background()) }()
+	waitSubscribed(t, first)
+	first.notify(0x0012, []byte{1})
+	first.notify(0x0012, []byte{2})
+	first.CancelConnection() // リンク切れ
+	waitSubscribed(t, second)
+	second.notify(0x0012, []byte{3})
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "01,02,03" {
+		t.Errorf("received %v", got)
+	}
+	if !strings.Contains(log.String(), "reconnected to aa:bb:cc:dd:ee:ff") {
+		t.Errorf("log:\n%s", log)
+	}
+}
+
+func TestNotifier_Errors(t *testing.T) {
+	// 最初の接続に失敗したら再接続せずにエラー
+	useFakeDialers(t)
+	n, _ := newTestNotifier(0, func(notification) error { return nil })
+	if err := n.run(context.Background()); err == nil || !strings.Contains(err.Error(), "not reachable") {
+		t.Errorf("want connection error, got %v", err)
+	}
+
+	// 一度購読した後は、再接続の待ち中に --duration が切れたら正常終了
+	cln := newFakeClient(sampleGATT()...)
+	useFakeDialers(t, cln)
+	notifyRetry = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() { errc <- n.run(ctx) }()
+	waitSubscribed(t, cln)
+	cln.CancelConnection()
+	if err := <-errc; err != nil {
+		t.Errorf("want nil after duration, got %v", err)
+	}
+
+	// 再接続後の探索中に --duration が切れたら、探索の時間切れを待たずに切断して終了
+	first, second := newFakeClient(sampleGATT()...), newFakeClient(sampleGATT()...)
+	second.block = make(chan struct{})
+	defer close(second.block)
+	useFakeDialers(t, first)
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	n.conn.Discover = time.Minute
+	errc = make(chan error, 1)
+	go func() { errc <- n.run(ctx) }()
+	waitSubscribed(t, first)
+	DefaultDialer = func(context.Context, ble.Addr) (ble.Client, error) {
+		cancel() // 探索が止まっている間に終わる
+		return second, nil
+	}
+	first.CancelConnection()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("want nil after cancel, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("discovery did not stop on cancel")
+	}
+	select {
+	case <-second.Disconnected():
+	case <-time.After(time.Second):
+		t.Error("connection not canceled")
+	}
+
+	// CCCD のない / notify も indicate もできないキャラクタリスティック
+	for _, c := range []*ble.Characteristic{
+		{UUID: ble.UUID16(0x2a19), Property: ble.CharNotify},
+		{UUID: ble.UUID16(0x2a19), Property: ble.CharRead, CCCD: &ble.Descriptor{}},
+		{UUID: ble.UUID16(0x2a19), Property: ble.CharNotify, CCCD: &ble.Descriptor{}},
+	} {
+		if _, err := subscribeMode(c, c.Property&ble.CharNotify != 0); err == nil {
+			t.Errorf("%v: want error", c.Property)
+		}
+	}
+	if ind, err := subscribeMode(&ble.Characteristic{Property: ble.CharIndicate, CCCD: &ble.Descriptor{}}, false); !ind || err != nil {
+		t.Errorf("indicate-only characteristic: %v, %v", ind, err)
+	}
+}
+
+/* ---------- 3. 出力形式 ---------- */
+func TestNotifyPrinter(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 123456789, time.UTC)
+	v := notification{Event: "indication", Timestamp: at.Format(time.RFC3339Nano), Address: "aa:bb:cc:dd:ee:ff",
+		UUID: "2a1c", Handle: 0x22, Hex: "0a0b", Value: uint64(2826), time: at}
+	for format, want := range map[string]string{
+		notifyHex:     "2024-01-01T12:00:00.123Z 0a0b\n",
+		notifyDecoded: "2024-01-01T12:00:00.123Z 2826\n",
+	} {
+		buf := &bytes.Buffer{}
+		if err := newNotifyPrinter(buf, format)(v); err != nil || buf.String() != want {
+			t.Errorf("%s: %q, %v", format, buf, err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	newNotifyPrinter(buf, notifyNDJSON)(v)
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["event"] != "indication" || got["timestamp"] != "2024-01-01T12:00:00.123456789Z" || got["value"] != float64(2826) || got["handle"] != float64(0x22) {
+		t.Errorf("unexpected NDJSON %v", got)
+	}
+}
+
+func TestNotifyCommand(t *testing.T) {
+	cln := newFakeClient(sampleGATT()...)
+	useFakeDialers(t, cln)
+	buf := &bytes.Buffer{}
+	rootCommand.SetOut(buf)
+	defer rootCommand.SetOut(nil)
+	t.Cleanup(func() { notifyValue, notifyFormat, notifyCount = valueOptions{}, notifyHex, 0 })
+	rootCommand.SetArgs([]string{"notify", "AA:BB:CC:DD:EE:FF", "0x0012", "--format", "ndjson", "--uint8", "--count", "2"})
+
+	errc := make(chan error, 1)
+	go func() { errc <- rootCommand.Execute() }()
+	waitSubscribed(t, cln)
+	cln.notify(0x0012, []byte{99})
+	cln.notify(0x0012, []byte{98})
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"value":99`) || !strings.Contains(lines[1], `"hex":"62"`) {
+		t.Errorf("unexpected output:\n%s", buf)
+	}
+
+	rootCommand.SetArgs([]string{"notify", "AA:BB:CC:DD:EE:FF", "2a19", "--format", "csv"})
+	if err := rootCommand.Execute(); err == nil {
+		t.Error("want error for invalid format")
+	}
+}
@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+	"github.com/spf13/cobra"
+)
+
+// sinkOptions は scan / serve 共通の出力先（REST API・メトリクス・MQTT・webhook）のフラグ
+type sinkOptions struct {
+	Metrics  string
+	HTTP     string
+	Stream   time.Duration // /events と /ws での同じデバイスの update の最短間隔
+	Origins  []string      // /ws への接続を許可する別オリジン
+	Watch    []string
+	TTL      time.Duration
+	Max      int // デバイス表の上限（0 なら無制限）
+	MQTT     mqttOptions
+	HA       bool
+	HAPrefix string
+	Hook     webhookOptions
+}
+
+// addSinkFlags は出力先のフラグを c に登録します
+func addSinkFlags(c *cobra.Command, o *sinkOptions) {
+	f := c.Flags()
+	f.StringVar(&o.Metrics, "metrics", "", "Expose Prometheus metrics on this address (e.g. :9110)")
+	f.StringVar(&o.HTTP, "http", "", "Serve the REST API (/devices, /devices/{addr}, /healthz, /events, /ws) on this address (e.g. :8080)")
+	f.StringSliceVar(&o.Origins, "allow-origin", nil, "Allow WebSocket connections from pages of this origin (e.g. https://dash.example.com, * for any; default same-origin only)")
+	f.DurationVar(&o.Stream, "stream-interval", time.Second, "Minimum interval between update events of the same device on /events and /ws")
+	f.StringSliceVar(&o.Watch, "watch", nil, "Watchlist: export the last RSSI / track presence of these addresses (repeatable)")
+	f.DurationVar(&o.TTL, "device-ttl", time.Minute, "Forget devices not seen for this long (REST API, metrics, MQTT, webhooks; \"scan\" defaults to 10s)")
+	f.IntVar(&o.Max, "max-devices", 0, "Keep at most this many devices in memory, forgetting the least recently seen (0 = unlimited)")
+	f.StringVar(&o.MQTT.Broker, "mqtt", "", "Publish device events to this MQTT broker (e.g. tcp://localhost:1883, ssl://host:8883)")
+	f.StringVar(&o.MQTT.Topic, "mqtt-topic", defaultMQTTTopic, "Topic template for device state ({{.Host}}, {{.Address}}, {{.ID}})")
+	f.StringVar(&o.MQTT.StatusTopic, "mqtt-status-topic", defaultMQTTStatusTopic, "Topic for online/offline availability (LWT)")
+	f.IntVar(&o.MQTT.QoS, "mqtt-qos", 0, "MQTT QoS (0, 1 or 2)")
+	f.BoolVar(&o.MQTT.Retain, "mqtt-retain", true, "Publish device state as retained messages")
+	f.DurationVar(&o.MQTT.Interval, "mqtt-interval", 10*time.Second, "Minimum interval between updates of the same device")
+	f.StringVar(&o.MQTT.ClientID, "mqtt-client-id", "", "MQTT client ID (default peekbt-<hostname>)")
+	f.StringVar(&o.MQTT.Username, "mqtt-user", "", "MQTT username")
+	f.StringVar(&o.MQTT.Password, "mqtt-password", "", "MQTT password (or set "+mqttPasswordEnv+")")
+	f.StringVar(&o.MQTT.CAFile, "mqtt-ca", "", "CA certificate (PEM) to verify the broker")
+	f.StringVar(&o.MQTT.CertFile, "mqtt-cert", "", "Client certificate (PEM) for TLS authentication")
+	f.StringVar(&o.MQTT.KeyFile, "mqtt-key", "", "Client private key (PEM) for TLS authentication")
+	f.BoolVar(&o.MQTT.Insecure, "mqtt-insecure", false, "Skip verification of the broker certificate")
+	f.BoolVar(&o.HA, "ha-discovery", false, "Publish Home Assistant MQTT discovery configs (trackers for --watch, sensors for decoded readings)")
+	f.StringVar(&o.HAPrefix, "ha-prefix", defaultHAPrefix, "Home Assistant discovery topic prefix")
+	f.StringVar(&o.Hook.URL, "webhook", "", "POST device events as JSON to this URL")
+	f.StringSliceVar(&o.Hook.Events, "webhook-events", []string{hookNew, hookLost}, "Webhook events: "+strings.Join(webhookEvents, ","))
+	f.IntVar(&o.Hook.RSSI, "webhook-rssi", -60, "RSSI threshold (dBm) for the rssi-threshold event")
+	f.StringVar(&o.Hook.Secret, "webhook-secret", "", "Sign webhook bodies with HMAC-SHA256 (or set "+webhookSecretEnv+")")
+	f.IntVar(&o.Hook.QueueSize, "webhook-queue", 256, "Maximum number of pending webhook events (older ones are kept, new ones dropped)")
+	f.IntVar(&o.Hook.Retries, "webhook-retries", 5, "Retries with exponential backoff on network errors and 5xx/429")
+	f.DurationVar(&o.Hook.Timeout, "webhook-timeout", 10*time.Second, "Timeout of each webhook request")
+}
+
+// enabled は出力先が 1 つ以上指定されているかを返します
+func (o *sinkOptions) enabled() bool {
+	return o.Metrics != "" || o.HTTP != "" || o.MQTT.Broker != "" || o.Hook.URL != ""
+}
+
+// validate はフラグを検証し、アドレスを小文字に揃えます
+func (o *sinkOptions) validate() error {
+	for i, a := range o.Watch {
+		o.Watch[i] = strings.ToLower(a)
+		if err := validateAddr(o.Watch[i]); err != nil {
+			return err
+		}
+	}
+	if o.HA && o.MQTT.Broker == "" {
+		return errors.New("--ha-discovery requires --mqtt")
+	}
+	if o.Hook.URL != "" {
+		if err := o.Hook.validate(); err != nil {
+			return err
+		}
+	}
+	if o.TTL <= 0 {
+		return fmt.Errorf("invalid --device-ttl %s", o.TTL)
+	}
+	if o.Max < 0 {
+		return fmt.Errorf("invalid --max-devices %d", o.Max)
+	}
+	if o.Stream < 0 {
+		return fmt.Errorf("invalid --stream-interval %s", o.Stream)
+	}
+	return nil
+}
+
+// sinkSet は起動した出力先の組
+type sinkSet struct {
+	sinks   []eventSink
+	closers []func()
+}
+
+// open は指定された出力先を起動し、sess のイベントを bus から受け取るよう購読させます。
+// 出力先は独自のデバイス表を持たず、sess のデバイス表の変化（new / update / lost）に従います
+func (o *sinkOptions) open(ctx context.Context, bus *eventBus, sess *peekbt.Session) (*sinkSet, error) {
+	s := &sinkSet{}
+	if o.Metrics != "" {
+		metrics := newMetricsSink(o.Watch, sess.Table())
+		s.add(bus, "metrics", metrics, peekbt.EventAdv)
+		srv, err := startHTTP(ctx, o.Metrics, metrics.handler())
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Serving metrics on http://%s/metrics\n", srv)
+	}
+	if o.HTTP != "" {
+		api := newAPIServer(sess.Table(), o.Stream)
+		api.allowOrigins = o.Origins
+		go api.run(ctx)
+		s.add(bus, "api", api, peekbt.EventNew, peekbt.EventUpdate, peekbt.EventLost)
+		srv, err := startHTTP(ctx, o.HTTP, api.handler())
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Serving API on http://%s/devices\n", srv)
+	}
+	if o.MQTT.Broker != "" {
+		m, err := openMQTTSink(o.MQTT)
+		if err != nil {
+			return nil, err
+		}
+		s.closers = append(s.closers, m.Close)
+		if o.HA {
+			if err := m.enableDiscovery(o.HAPrefix, o.Watch); err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+		go m.run(ctx)
+		s.add(bus, "mqtt", m, peekbt.EventNew, peekbt.EventUpdate, peekbt.EventLost)
+	}
+	if o.Hook.URL != "" {
+		hook := o.Hook
+		if hook.Secret == "" {
+			hook.Secret = os.Getenv(webhookSecretEnv)
+		}
+		w, err := newWebhookSink(hook)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		go w.run(ctx)
+		s.add(bus, "webhook", w, w.eventTypes()...)
+	}
+	return s, nil
+}
+
+// add は出力先を bus の types のイベントの購読者にします
+func (s *sinkSet) add(bus *eventBus, name string, sink eventSink, types ...peekbt.EventType) {
+	s.sinks = append(s.sinks, sink)
+	bus.subscribe(name, sink.Event, types...)
+}
+
+// scanError はスキャンの失敗を受け取りたい出力先に知らせます
+func (s *sinkSet) scanError(err error) {
+	for _, sink := range s.sinks {
+		if es, ok := sink.(scanErrorSink); ok {
+			es.ScanError(err)
+		}
+	}
+}
+
+// Close は MQTT の接続などを閉じます
+func (s *sinkSet) Close() {
+	for _, c := range s.closers {
+		c()
+	}
+}
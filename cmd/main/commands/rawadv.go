@@ -0,0 +1,243 @@
+package commands
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// HCI LE Advertising Report の Event_Type [Vol 4, Part E, 7.7.65.2]
+const (
+	evtAdvInd        = 0x00 // ADV_IND
+	evtAdvDirectInd  = 0x01 // ADV_DIRECT_IND
+	evtAdvScanInd    = 0x02 // ADV_SCAN_IND
+	evtAdvNonconnInd = 0x03 // ADV_NONCONN_IND
+	evtScanRsp       = 0x04 // SCAN_RSP
+)
+
+// maxADLen はレガシーアドバタイズ 1 件の AD の最大長 [Vol 6, Part B, 2.3.1.1]
+const maxADLen = 31
+
+// rawAdv は 1 件のアドバタイズを HCI レポート相当の生データで表したもの。
+// pcap / btsnoop / 独自形式の記録と再生で共通に使います
+type rawAdv struct {
+	EventType uint8
+	AddrType  uint8   // 0x00: Public, 0x01: Random
+	Addr      [6]byte // 表示順（先頭が MSB）
+	RSSI      int8
+	Data      []byte // AD 構造の並び
+
+	// Adapter は受信したアダプタ番号（hciN の N）。記録の再生時のみ HasAdapter が立ちます
+	Adapter    uint8
+	HasAdapter bool
+}
+
+// rawReport は linux の *hci.Advertisement が持つ生データへのアクセサ
+type rawReport interface {
+	EventType() uint8
+	AddressType() uint8
+	Data() []byte
+	ScanResponse() []byte
+}
+
+// adapterReport は受信したアダプタ番号が分かるアドバタイズ（.pbt の再生）
+type adapterReport interface {
+	adapter() (uint8, bool)
+}
+
+// toRawAdvs はアドバタイズを生データに変換します。
+// linux 以外（テスト用スタブ）の場合は各フィールドから AD を組み立て直します。
+// スキャンレスポンスが結合されていれば、元のアドバタイズに続けて SCAN_RSP を別のレコードで返します
+func toRawAdvs(a ble.Advertisement) []rawAdv {
+	r := rawAdv{RSSI: int8(a.RSSI())}
+	if hw, err := net.ParseMAC(a.Addr().String()); err == nil && len(hw) == 6 {
+		copy(r.Addr[:], hw)
+	}
+	if peekbt.AddrKindOf(a) == peekbt.AddrRandom {
+		r.AddrType = 0x01
+	}
+	if ar, ok := a.(adapterReport); ok {
+		r.Adapter, r.HasAdapter = ar.adapter()
+	}
+
+	if rr, ok := a.(rawReport); ok {
+		// go-ble は別の goroutine で結合するため、各フィールドは最初に 1 回だけ読む
+		sr := rr.ScanResponse()
+		r.EventType, r.AddrType, r.Data = rr.EventType(), rr.AddressType(), trimAD(rr.Data())
+		if sr == nil {
+			return []rawAdv{r}
+		}
+		rsp := r
+		rsp.EventType, rsp.Data = evtScanRsp, trimAD(sr)
+		return []rawAdv{r, rsp}
+	}
+
+	r.EventType = evtAdvNonconnInd
+	if a.Connectable() {
+		r.EventType = evtAdvInd
+	}
+	r.Data = synthesizeAD(a)
+	return []rawAdv{r}
+}
+
+// rawSplitterSize は書き出し済みとして覚えておくアドバタイズの数
+const rawSplitterSize = 1024
+
+// rawSplitter はアドバタイズを ADV と SCAN_RSP のレコードに分け、それぞれを 1 回だけ返します。
+// go-ble はスキャンレスポンスを受信すると先に通知した *hci.Advertisement に結合し、
+// 同じポインタをもう一度通知します。ハンドラは別々の goroutine で動くため、1 回目の通知の時点で
+// 結合済みのこともあります。そこでポインタ毎に書き出したレコードを覚えておきます。
+// 1 つの goroutine から使います
+type rawSplitter struct {
+	written map[any]int // 書き出したレコード数（1: ADV のみ、2: SCAN_RSP まで）
+	ring    []any
+	next    int
+}
+
+func newRawSplitter() *rawSplitter {
+	return &rawSplitter{written: make(map[any]int), ring: make([]any, rawSplitterSize)}
+}
+
+// split は a のうちまだ返していないレコードを返します
+func (s *rawSplitter) split(a ble.Advertisement) []rawAdv {
+	raws := toRawAdvs(a)
+	key, ok := reportKey(a)
+	if !ok {
+		return raws
+	}
+	n, seen := s.written[key]
+	if !seen {
+		if old := s.ring[s.next]; old != nil {
+			delete(s.written, old)
+		}
+		s.ring[s.next] = key
+		s.next = (s.next + 1) % len(s.ring)
+	}
+	if n >= len(raws) {
+		return nil
+	}
+	s.written[key] = len(raws)
+	return raws[n:]
+}
+
+// reportKey は同じアドバタイズの再通知を見分けるキーを返します。
+// ポインタ以外（比較できない値もある）は毎回別のアドバタイズとして扱います
+func reportKey(a ble.Advertisement) (any, bool) {
+	if m, ok := a.(interface{ mergedInto() ble.Advertisement }); ok {
+		if base := m.mergedInto(); base != nil {
+			a = base
+		}
+	}
+	if reflect.ValueOf(a).Kind() != reflect.Pointer {
+		return nil, false
+	}
+	return a, true
+}
+
+// synthesizeAD はアドバタイズの各フィールドから AD 構造を組み立てます。
+// maxADLen に収まらない構造は省き、長い名前は Shortened Local Name にします
+func synthesizeAD(a ble.Advertisement) []byte {
+	var b []byte
+	put := func(typ byte, data []byte) {
+		if len(b)+2+len(data) > maxADLen {
+			return
+		}
+		b = append(b, byte(len(data)+1), typ)
+		b = append(b, data...)
+	}
+	if n := a.LocalName(); len(n) > maxADLen-2 {
+		put(0x08, []byte(n)[:maxADLen-2])
+	} else if n != "" {
+		put(0x09, []byte(n))
+	}
+	for _, u := range a.Services() {
+		switch u.Len() {
+		case 2:
+			put(0x03, u)
+		case 16:
+			put(0x07, u)
+		}
+	}
+	for _, sd := range a.ServiceData() {
+		typ := byte(0x16)
+		if sd.UUID.Len() == 16 {
+			typ = 0x21
+		}
+		put(typ, append(append([]byte{}, sd.UUID...), sd.Data...))
+	}
+	if md := a.ManufacturerData(); len(md) > 0 {
+		put(0xFF, md)
+	}
+	if p := a.TxPowerLevel(); p != 0 && p != 127 {
+		put(0x0A, []byte{byte(int8(p))})
+	}
+	return b
+}
+
+// trimAD は AD が maxADLen バイトに収まるよう、収まらない AD 構造から後ろを切り捨てます。
+// HCI イベントや PDU の長さは 1 バイトで、レガシーアドバタイズはこれより長いデータを運べません
+func trimAD(b []byte) []byte {
+	if len(b) <= maxADLen {
+		return b
+	}
+	n := 0
+	for n < maxADLen {
+		l := int(b[n])
+		if l == 0 || n+1+l > maxADLen {
+			break
+		}
+		n += 1 + l
+	}
+	return b[:n]
+}
+
+// addrLE は HCI / 無線上のリトルエンディアン表現のアドレスを返します
+func (r rawAdv) addrLE() []byte {
+	b := make([]byte, 6)
+	for i := range b {
+		b[i] = r.Addr[5-i]
+	}
+	return b
+}
+
+// hciEvent は H4 形式（先頭 0x04）の LE Advertising Report イベントを返します
+func (r rawAdv) hciEvent() []byte {
+	data := trimAD(r.Data)
+	params := []byte{0x02, 0x01, r.EventType, r.AddrType}
+	params = append(params, r.addrLE()...)
+	params = append(params, byte(len(data)))
+	params = append(params, data...)
+	params = append(params, byte(r.RSSI))
+	return append([]byte{0x04, 0x3E, byte(len(params))}, params...)
+}
+
+// llPDU は Link Layer のアドバタイジングパケット（Access Address〜CRC）を返します。
+// CRC は不明なため 0 で埋めます
+func (r rawAdv) llPDU() []byte {
+	var pduType byte
+	switch r.EventType {
+	case evtAdvInd:
+		pduType = 0x0
+	case evtAdvDirectInd:
+		pduType = 0x1
+	case evtAdvNonconnInd:
+		pduType = 0x2
+	case evtScanRsp:
+		pduType = 0x4
+	case evtAdvScanInd:
+		pduType = 0x6
+	}
+	hdr := pduType
+	if r.AddrType&0x01 != 0 {
+		hdr |= 0x40 // TxAdd
+	}
+	payload := append(r.addrLE(), trimAD(r.Data)...)
+
+	b := binary.LittleEndian.AppendUint32(nil, 0x8E89BED6) // アドバタイジング用 Access Address
+	b = append(b, hdr, byte(len(payload)))
+	b = append(b, payload...)
+	return append(b, 0, 0, 0)
+}
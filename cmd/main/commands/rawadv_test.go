@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux/adv"
+)
+
+/* ---------- HCI の生データを持つアドバタイズ ---------- */
+type reportAdv struct {
+	stubAdv
+	evt, typ uint8
+	data, sr []byte
+}
+
+func (r reportAdv) EventType() uint8     { return r.evt }
+func (r reportAdv) AddressType() uint8   { return r.typ }
+func (r reportAdv) Data() []byte         { return r.data }
+func (r reportAdv) ScanResponse() []byte { return r.sr }
+
+/* ---------- 1. HCI レポートからの変換 ---------- */
+func TestToRawAdvs_Report(t *testing.T) {
+	a := reportAdv{
+		stubAdv: stubAdv{addr: ble.NewAddr("c0:01:02:03:04:05"), rssi: -70},
+		evt:     evtAdvInd, typ: 0x01, data: []byte{0x02, 0x01, 0x06},
+	}
+	raws := toRawAdvs(a)
+	if len(raws) != 1 {
+		t.Fatalf("got %d records, want 1", len(raws))
+	}
+	r := raws[0]
+	if r.EventType != evtAdvInd || r.AddrType != 0x01 || r.RSSI != -70 || !bytes.Equal(r.Data, a.data) {
+		t.Fatalf("unexpected raw adv: %+v", r)
+	}
+	if r.Addr != [6]byte{0xc0, 0x01, 0x02, 0x03, 0x04, 0x05} {
+		t.Fatalf("address mismatch: %x", r.Addr)
+	}
+
+	// 結合されたスキャンレスポンスは元のアドバタイズを置き換えずに別のレコードになる
+	a.sr = []byte{0x04, 0x09, 'a', 'b', 'c'}
+	raws = toRawAdvs(a)
+	if len(raws) != 2 || raws[0].EventType != evtAdvInd || !bytes.Equal(raws[0].Data, a.data) ||
+		raws[1].EventType != evtScanRsp || !bytes.Equal(raws[1].Data, a.sr) || raws[1].Addr != r.Addr {
+		t.Fatalf("scan response should follow the advertisement: %+v", raws)
+	}
+}
+
+func TestRawSplitter(t *testing.T) {
+	mk := func() *reportAdv {
+		return &reportAdv{stubAdv: stubAdv{addr: ble.NewAddr("c0:01:02:03:04:05"), rssi: -70},
+			evt: evtAdvInd, data: []byte{0x02, 0x01, 0x06}}
+	}
+	types := func(raws []rawAdv) []uint8 {
+		var out []uint8
+		for _, r := range raws {
+			out = append(out, r.EventType)
+		}
+		return out
+	}
+
+	// go-ble は同じポインタを結合前と結合後に通知する
+	s := newRawSplitter()
+	a := mk()
+	first := s.split(a)
+	a.sr = []byte{0x02, 0x0a, 0x00}
+	second := s.split(a)
+	if !slices.Equal(types(first), []uint8{evtAdvInd}) || !slices.Equal(types(second), []uint8{evtScanRsp}) {
+		t.Errorf("before merge: %v, then %v", types(first), types(second))
+	}
+
+	// 1 回目の通知の時点で結合済みでも、ADV と SCAN_RSP を 1 回ずつ
+	b := mk()
+	b.sr = []byte{0x02, 0x0a, 0x00}
+	first, second = s.split(b), s.split(b)
+	if !slices.Equal(types(first), []uint8{evtAdvInd, evtScanRsp}) || len(second) != 0 {
+		t.Errorf("already merged: %v, then %v", types(first), types(second))
+	}
+
+	// 別のアドバタイズ（同じ内容でも）はそれぞれ書き出す
+	if len(s.split(mk())) != 1 || len(s.split(*mk())) != 1 || len(s.split(*mk())) != 1 {
+		t.Error("distinct advertisements deduplicated")
+	}
+
+	// 覚えておく数には上限がある
+	for i := 0; i < rawSplitterSize*2; i++ {
+		s.split(mk())
+	}
+	if len(s.written) != rawSplitterSize {
+		t.Errorf("remembered %d advertisements", len(s.written))
+	}
+}
+
+func TestRawSplitter_Replay(t *testing.T) {
+	// 再生したアドバタイズを記録し直しても元のレコードと一致する
+	r := &replayScanner{path: samplePcap(t)}
+	s := newRawSplitter()
+	var got []rawAdv
+	if err := r.Scan(context.Background(), true, func(a ble.Advertisement) { got = append(got, s.split(a)...) }, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(replaySamples) {
+		t.Fatalf("got %d records, want %d", len(got), len(replaySamples))
+	}
+	for i, want := range replaySamples {
+		if g := got[i]; g.EventType != want.EventType || g.RSSI != want.RSSI || !bytes.Equal(g.Data, want.Data) || g.Addr != want.Addr {
+			t.Errorf("record %d: %+v, want %+v", i, g, want)
+		}
+	}
+}
+
+/* ---------- 2. フィールドからの AD 組み立て ---------- */
+func TestToRawAdv_Synthesize(t *testing.T) {
+	a := filterAdv{
+		stubAdv:  stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff"), name: "Tile", rssi: -40},
+		mfg:      []byte{0x4c, 0x00, 0x02},
+		services: []ble.UUID{ble.UUID16(0x180f)},
+		conn:     true,
+	}
+	r := toRawAdvs(a)[0]
+	if r.EventType != evtAdvInd {
+		t.Fatalf("connectable advertisement should be ADV_IND, got %d", r.EventType)
+	}
+	p := adv.NewRawPacket(r.Data)
+	if p.LocalName() != "Tile" || !bytes.Equal(p.ManufacturerData(), a.mfg) || len(p.UUIDs()) != 1 || !p.UUIDs()[0].Equal(ble.UUID16(0x180f)) {
+		t.Fatalf("synthesized AD does not round-trip: % x", r.Data)
+	}
+}
+
+/* ---------- 3. HCI イベント / LL PDU ---------- */
+func TestRawAdv_Encodings(t *testing.T) {
+	r := rawAdv{EventType: evtAdvNonconnInd, AddrType: 0x01, Addr: [6]byte{1, 2, 3, 4, 5, 6}, RSSI: -50, Data: []byte{0x02, 0x01, 0x06}}
+
+	ev := r.hciEvent()
+	want := []byte{0x04, 0x3E, 15, 0x02, 0x01, 0x03, 0x01, 6, 5, 4, 3, 2, 1, 3, 0x02, 0x01, 0x06, 0xCE}
+	if !bytes.Equal(ev, want) {
+		t.Fatalf("hciEvent = % x, want % x", ev, want)
+	}
+
+	ll := r.llPDU()
+	if !bytes.Equal(ll[:4], []byte{0xD6, 0xBE, 0x89, 0x8E}) || ll[4] != 0x42 || ll[5] != 9 || len(ll) != 4+2+9+3 {
+		t.Fatalf("unexpected LL PDU: % x", ll)
+	}
+}
+
+/* ---------- 4. 31 バイトを超える AD ---------- */
+func TestRawAdv_LongData(t *testing.T) {
+	// 10 バイトの AD 構造 4 つ（40 バイト）は 3 つ目までに切り詰める
+	var data []byte
+	for i := 0; i < 4; i++ {
+		data = append(data, 9, 0xFF, 1, 2, 3, 4, 5, 6, 7, byte(i))
+	}
+	r := rawAdv{EventType: evtAdvInd, Data: data}
+
+	ev := r.hciEvent()
+	if int(ev[2]) != len(ev)-3 || int(ev[13]) != 30 || len(ev) != 3+11+30+1 {
+		t.Fatalf("hciEvent lengths inconsistent: % x", ev)
+	}
+	pdu := r.llPDU()
+	if int(pdu[5]) != 6+30 || len(pdu) != 4+2+36+3 {
+		t.Fatalf("llPDU length = %d, want 36", pdu[5])
+	}
+
+	// フィールドから組み立てる場合も収まる構造だけにする
+	a := filterAdv{
+		stubAdv: stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff"), name: strings.Repeat("n", 40)},
+		mfg:     []byte{0x4c, 0x00, 0x02},
+	}
+	ad := toRawAdvs(a)[0].Data
+	if len(ad) > maxADLen {
+		t.Fatalf("synthesized AD is %d bytes", len(ad))
+	}
+	p := adv.NewRawPacket(ad)
+	if p.LocalName() != strings.Repeat("n", maxADLen-2) {
+		t.Errorf("long name should become a shortened name, got %q", p.LocalName())
+	}
+}
@@ -0,0 +1,371 @@
+package commands
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ble/ble"
+	"github.com/spf13/cobra"
+)
+
+// 値のエンコーディング
+const (
+	encodingHex    = "hex"
+	encodingString = "string"
+	encodingUint8  = "uint8"
+	encodingUint16 = "uint16"
+	encodingUint32 = "uint32"
+)
+
+// valueOptions は read / write の値のエンコーディングのフラグ
+type valueOptions struct {
+	Hex, String           bool
+	Uint8, Uint16, Uint32 bool
+	LE, BE                bool
+}
+
+// addValueFlags は --hex / --string / --uint8 / --uint16 / --uint32 / --le / --be を c に登録します
+func addValueFlags(c *cobra.Command, o *valueOptions) {
+	c.Flags().BoolVar(&o.Hex, "hex", false, "The value is a hex byte string, e.g. 0102ff or 01:02:ff (default)")
+	c.Flags().BoolVar(&o.String, "string", false, "The value is a UTF-8 string")
+	c.Flags().BoolVar(&o.Uint8, "uint8", false, "The value is an unsigned 8-bit integer")
+	c.Flags().BoolVar(&o.Uint16, "uint16", false, "The value is an unsigned 16-bit integer")
+	c.Flags().BoolVar(&o.Uint32, "uint32", false, "The value is an unsigned 32-bit integer")
+	c.Flags().BoolVar(&o.LE, "le", false, "Integers are little-endian (default, as in the Bluetooth specification)")
+	c.Flags().BoolVar(&o.BE, "be", false, "Integers are big-endian")
+}
+
+// valueCodec は値のバイト列と表示 / 引数の変換
+type valueCodec struct {
+	Encoding string
+	order    binary.ByteOrder
+}
+
+// codec はフラグを検証して変換方法を返します
+func (o valueOptions) codec() (valueCodec, error) {
+	var kinds []string
+	for _, k := range []struct {
+		set  bool
+		name string
+	}{
+		{o.Hex, encodingHex}, {o.String, encodingString},
+		{o.Uint8, encodingUint8}, {o.Uint16, encodingUint16}, {o.Uint32, encodingUint32},
+	} {
+		if k.set {
+			kinds = append(kinds, k.name)
+		}
+	}
+	if len(kinds) > 1 {
+		return valueCodec{}, fmt.Errorf("only one of --%s can be specified", strings.Join(kinds, ", --"))
+	}
+	c := valueCodec{Encoding: encodingHex, order: binary.LittleEndian}
+	if len(kinds) == 1 {
+		c.Encoding = kinds[0]
+	}
+	if o.LE && o.BE {
+		return valueCodec{}, fmt.Errorf("--le and --be cannot be combined")
+	}
+	if (o.LE || o.BE) && !strings.HasPrefix(c.Encoding, "uint") {
+		return valueCodec{}, fmt.Errorf("--le / --be require --uint8, --uint16 or --uint32")
+	}
+	if o.BE {
+		c.order = binary.BigEndian
+	}
+	return c, nil
+}
+
+// size は整数のバイト数を返します（整数でなければ 0）
+func (c valueCodec) size() int {
+	switch c.Encoding {
+	case encodingUint8:
+		return 1
+	case encodingUint16:
+		return 2
+	case encodingUint32:
+		return 4
+	}
+	return 0
+}
+
+// encode はコマンドライン引数の値をバイト列にします
+func (c valueCodec) encode(s string) ([]byte, error) {
+	switch c.Encoding {
+	case encodingString:
+		return []byte(s), nil
+	case encodingHex:
+		h := strings.TrimPrefix(strings.ToLower(s), "0x")
+		h = strings.NewReplacer(":", "", " ", "", "-", "").Replace(h)
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex value %q", s)
+		}
+		return b, nil
+	}
+	n := c.size()
+	v, err := strconv.ParseUint(s, 0, n*8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q", c.Encoding, s)
+	}
+	b := make([]byte, n)
+	switch n {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		c.order.PutUint16(b, uint16(v))
+	case 4:
+		c.order.PutUint32(b, uint32(v))
+	}
+	return b, nil
+}
+
+// decode はバイト列を表示用の値にします（hex と string は文字列、整数は uint64）
+func (c valueCodec) decode(b []byte) (any, error) {
+	switch c.Encoding {
+	case encodingString:
+		return string(b), nil
+	case encodingHex:
+		return hex.EncodeToString(b), nil
+	}
+	if n := c.size(); len(b) != n {
+		return nil, fmt.Errorf("cannot decode %d bytes (%x) as %s", len(b), b, c.Encoding)
+	}
+	switch c.Encoding {
+	case encodingUint8:
+		return uint64(b[0]), nil
+	case encodingUint16:
+		return uint64(c.order.Uint16(b)), nil
+	default:
+		return uint64(c.order.Uint32(b)), nil
+	}
+}
+
+// charValue は read / write の結果（info と同じ --format で出力します）
+type charValue struct {
+	Address   string `json:"address" yaml:"address"`
+	UUID      string `json:"uuid" yaml:"uuid"`
+	Name      string `json:"name,omitempty" yaml:"name,omitempty"`
+	Handle    uint16 `json:"handle" yaml:"handle"`
+	Operation string `json:"operation" yaml:"operation"`
+	Encoding  string `json:"encoding" yaml:"encoding"`
+	Value     any    `json:"value" yaml:"value"`
+	Hex       string `json:"hex" yaml:"hex"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"` // 変換できなかった理由
+}
+
+// Fields は表示用のラベルと値の組を順に返します
+func (v charValue) Fields() [][2]string {
+	ch := v.UUID
+	if v.Name != "" {
+		ch += " (" + v.Name + ")"
+	}
+	val := fmt.Sprint(v.Value)
+	if v.Error != "" {
+		val = fmt.Sprintf("%s (%s)", v.Hex, v.Error)
+	}
+	return [][2]string{
+		{"Address", v.Address},
+		{"Characteristic", ch},
+		{"Handle", fmt.Sprintf("0x%04x", v.Handle)},
+		{"Operation", v.Operation},
+		{"Value", val},
+		{"Hex", v.Hex},
+	}
+}
+
+// newCharValue は c の値 b を codec で変換した結果を組み立てます。
+// 長さが合わず変換できなければ Value を空にして Error に理由を入れます（Hex は常に入る）
+func newCharValue(cln ble.Client, c *ble.Characteristic, op string, codec valueCodec, b []byte) charValue {
+	v := charValue{
+		Address:   cln.Addr().String(),
+		UUID:      c.UUID.String(),
+		Name:      ble.Name(c.UUID),
+		Handle:    c.ValueHandle,
+		Operation: op,
+		Encoding:  codec.Encoding,
+		Hex:       hex.EncodeToString(b),
+	}
+	if val, err := codec.decode(b); err != nil {
+		v.Error = err.Error()
+	} else {
+		v.Value = val
+	}
+	return v
+}
+
+// findCharacteristic は UUID または 0x で始まるハンドル（宣言または値のハンドル）で
+// キャラクタリスティックを探します
+func findCharacteristic(svcs []*ble.Service, spec string) (*ble.Characteristic, error) {
+	var match func(c *ble.Characteristic) bool
+	if h, ok := strings.CutPrefix(strings.ToLower(spec), "0x"); ok {
+		n, err := strconv.ParseUint(h, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid handle %q", spec)
+		}
+		match = func(c *ble.Characteristic) bool { return c.Handle == uint16(n) || c.ValueHandle == uint16(n) }
+	} else {
+		u, err := ble.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid characteristic %q (want a UUID or a 0x handle)", spec)
+		}
+		match = func(c *ble.Characteristic) bool { return longUUID(c.UUID) == longUUID(u) }
+	}
+
+	var found []*ble.Characteristic
+	for _, s := range svcs {
+		for _, c := range s.Characteristics {
+			if match(c) {
+				found = append(found, c)
+			}
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("characteristic %s not found", spec)
+	case 1:
+		return found[0], nil
+	default:
+		return nil, fmt.Errorf("characteristic %s is ambiguous (%d matches), specify its handle", spec, len(found))
+	}
+}
+
+// connectCharacteristic は接続して探索し、spec のキャラクタリスティックを返します。
+// 呼び出し側は使い終わったら CancelConnection で切断します
+func connectCharacteristic(ctx context.Context, o *connOptions, addr, spec string) (ble.Client, *ble.Characteristic, error) {
+	cln, err := o.dial(ctx, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	svcs, err := discoverServices(ctx, cln, o.Discover)
+	if err == nil {
+		var c *ble.Characteristic
+		if c, err = findCharacteristic(svcs, spec); err == nil {
+			return cln, c, nil
+		}
+	}
+	cln.CancelConnection()
+	return nil, nil, err
+}
+
+// readCharacteristic は c の値を読みます。MTU を超える値は Read Blob で続きを読みます
+// （各要求は go-ble の ATT のタイムアウト 30 秒で打ち切られます）
+func readCharacteristic(cln ble.Client, c *ble.Characteristic, codec valueCodec) (charValue, error) {
+	if c.Property&ble.CharRead == 0 {
+		return charValue{}, fmt.Errorf("characteristic %s is not readable (%s)", c.UUID, strings.Join(propertyNames(c.Property), ", "))
+	}
+	b, err := cln.ReadLongCharacteristic(c)
+	if err != nil {
+		return charValue{}, fmt.Errorf("failed to read characteristic %s: %w", c.UUID, err)
+	}
+	return newCharValue(cln, c, "read", codec, b), nil
+}
+
+// writeCharacteristic は value を codec で変換して c に書き込みます
+func writeCharacteristic(cln ble.Client, c *ble.Characteristic, codec valueCodec, value string, noRsp bool) (charValue, error) {
+	b, err := codec.encode(value)
+	if err != nil {
+		return charValue{}, err
+	}
+	op, need := "write", ble.CharWrite
+	if noRsp {
+		op, need = "write-without-response", ble.CharWriteNR
+	}
+	if c.Property&need == 0 {
+		return charValue{}, fmt.Errorf("characteristic %s does not support %s (%s)", c.UUID, op, strings.Join(propertyNames(c.Property), ", "))
+	}
+	if err := cln.WriteCharacteristic(c, b, noRsp); err != nil {
+		return charValue{}, fmt.Errorf("failed to write characteristic %s: %w", c.UUID, err)
+	}
+	return newCharValue(cln, c, op, codec, b), nil
+}
+
+var (
+	readConn  connOptions
+	readValue valueOptions
+	readOut   outputSpec
+
+	writeConn  connOptions
+	writeValue valueOptions
+	writeOut   outputSpec
+	writeNoRsp bool
+)
+
+var readCommand = &cobra.Command{
+	Use:   "read [flags] <ADDR> <char-uuid|handle>",
+	Short: "Read the value of a characteristic.",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runReadCommand,
+}
+
+var writeCommand = &cobra.Command{
+	Use:   "write [flags] <ADDR> <char-uuid|handle> <value>",
+	Short: "Write a value to a characteristic.",
+	Args:  cobra.ExactArgs(3),
+	RunE:  runWriteCommand,
+}
+
+func init() {
+	addConnFlags(readCommand, &readConn)
+	addValueFlags(readCommand, &readValue)
+	addOutputFlags(readCommand, &readOut)
+	rootCommand.AddCommand(readCommand)
+
+	addConnFlags(writeCommand, &writeConn)
+	addValueFlags(writeCommand, &writeValue)
+	addOutputFlags(writeCommand, &writeOut)
+	writeCommand.Flags().BoolVar(&writeNoRsp, "no-response", false, "Use write without response (write command)")
+	rootCommand.AddCommand(writeCommand)
+}
+
+func runReadCommand(cmd *cobra.Command, args []string) error {
+	codec, err := readValue.codec()
+	if err != nil {
+		return err
+	}
+	if err := readOut.resolve(); err != nil {
+		return err
+	}
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
+	cln, c, err := connectCharacteristic(ctx, &readConn, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	defer cln.CancelConnection()
+
+	v, err := readCharacteristic(cln, c, codec)
+	if err != nil {
+		return err
+	}
+	return readOut.write(v)
+}
+
+func runWriteCommand(cmd *cobra.Command, args []string) error {
+	codec, err := writeValue.codec()
+	if err != nil {
+		return err
+	}
+	// 接続する前に値を検証する
+	if _, err := codec.encode(args[2]); err != nil {
+		return err
+	}
+	if err := writeOut.resolve(); err != nil {
+		return err
+	}
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
+	cln, c, err := connectCharacteristic(ctx, &writeConn, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	defer cln.CancelConnection()
+
+	v, err := writeCharacteristic(cln, c, codec, args[2], writeNoRsp)
+	if err != nil {
+		return err
+	}
+	return writeOut.write(v)
+}
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+/* ---------- 1. 値のエンコーディング ---------- */
+func TestValueCodec(t *testing.T) {
+	cases := []struct {
+		opts  valueOptions
+		arg   string
+		bytes []byte
+		value any
+	}{
+		{valueOptions{}, "01:02:FF", []byte{1, 2, 0xff}, "0102ff"},
+		{valueOptions{Hex: true}, "0x0a0b", []byte{0x0a, 0x0b}, "0a0b"},
+		{valueOptions{String: true}, "hello", []byte("hello"), "hello"},
+		{valueOptions{Uint8: true}, "200", []byte{200}, uint64(200)},
+		{valueOptions{Uint16: true}, "0x1234", []byte{0x34, 0x12}, uint64(0x1234)},
+		{valueOptions{Uint16: true, BE: true}, "4660", []byte{0x12, 0x34}, uint64(0x1234)},
+		{valueOptions{Uint32: true, LE: true}, "1", []byte{1, 0, 0, 0}, uint64(1)},
+	}
+	for _, c := range cases {
+		codec, err := c.opts.codec()
+		if err != nil {
+			t.Fatalf("%+v: %v", c.opts, err)
+		}
+		b, err := codec.encode(c.arg)
+		if err != nil || !bytes.Equal(b, c.bytes) {
+			t.Errorf("%s %q: encoded %x, %v", codec.Encoding, c.arg, b, err)
+		}
+		if v, err := codec.decode(c.bytes); err != nil || v != c.value {
+			t.Errorf("%s %x: decoded %v, %v", codec.Encoding, c.bytes, v, err)
+		}
+	}
+
+	for _, o := range []valueOptions{{Hex: true, String: true}, {Uint8: true, LE: true, BE: true}, {String: true, BE: true}} {
+		if _, err := o.codec(); err == nil {
+			t.Errorf("%+v: want error", o)
+		}
+	}
+	u16, _ := valueOptions{Uint16: true}.codec()
+	if _, err := u16.encode("70000"); err == nil {
+		t.Error("want out of range error")
+	}
+	if _, err := u16.decode([]byte{1, 2, 3}); err == nil {
+		t.Error("want length error")
+	}
+	if _, err := (valueCodec{Encoding: encodingHex}).encode("xyz"); err == nil {
+		t.Error("want invalid hex error")
+	}
+}
+
+/* ---------- 2. キャラクタリスティックの指定 ---------- */
+func TestFindCharacteristic(t *testing.T) {
+	svcs := sampleGATT()
+	for _, spec := range []string{"2a19", "00002A19-0000-1000-8000-00805F9B34FB", "0x0012", "0x11"} {
+		c, err := findCharacteristic(svcs, spec)
+		if err != nil || c.ValueHandle != 0x0012 {
+			t.Errorf("%s: %v, %v", spec, c, err)
+		}
+	}
+	for _, spec := range []string{"2a1a", "0x0099", "0xzz", "battery"} {
+		if _, err := findCharacteristic(svcs, spec); err == nil {
+			t.Errorf("%s: want error", spec)
+		}
+	}
+
+	svcs[1].Characteristics = append(svcs[1].Characteristics,
+		&ble.Characteristic{UUID: ble.UUID16(0x2a19), Handle: 0x0014, ValueHandle: 0x0015})
+	if _, err := findCharacteristic(svcs, "2a19"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("want ambiguous error, got %v", err)
+	}
+}
+
+/* ---------- 3. read / write コマンド ---------- */
+func TestReadWriteCommand(t *testing.T) {
+	cln := newFakeClient(sampleGATT()...)
+	cln.values[0x0012] = []byte{0x5a}
+	useFakeDialer(t, cln)
+	t.Cleanup(func() {
+		readValue, readOut = valueOptions{}, outputSpec{}
+		writeValue, writeOut, writeNoRsp = valueOptions{}, outputSpec{}, false
+	})
+	out := filepath.Join(t.TempDir(), "value.json")
+
+	rootCommand.SetArgs([]string{"read", "AA:BB:CC:DD:EE:FF", "2a19", "--uint8", "--format", "json", "-o", out})
+	if err := rootCommand.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v charValue
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "Battery Level" || v.Handle != 0x0012 || v.Value != float64(90) || v.Hex != "5a" || v.Operation != "read" {
+		t.Errorf("unexpected read result %+v", v)
+	}
+	if !cln.canceled {
+		t.Error("not disconnected after read")
+	}
+
+	cln = newFakeClient(sampleGATT()...)
+	useFakeDialer(t, cln)
+	out = filepath.Join(t.TempDir(), "write.txt")
+	rootCommand.SetArgs([]string{"write", "AA:BB:CC:DD:EE:FF", "0x0022", "0x0102", "--uint16", "--be", "--no-response", "-o", out})
+	if err := rootCommand.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cln.writes) != 1 || !bytes.Equal(cln.writes[0].value, []byte{1, 2}) || !cln.writes[0].noRsp || cln.writes[0].handle != 0x0022 {
+		t.Errorf("unexpected writes %+v", cln.writes)
+	}
+	if text, _ := os.ReadFile(out); !strings.Contains(string(text), "Operation      : write-without-response\n") ||
+		!strings.Contains(string(text), "Value          : 258\n") {
+		t.Errorf("unexpected text output:\n%s", text)
+	}
+}
+
+func TestRead_LengthMismatch(t *testing.T) {
+	cln := newFakeClient(sampleGATT()...)
+	cln.values[0x0012] = []byte{0x5a}
+	svcs, err := discoverServices(context.Background(), cln, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	battery, _ := findCharacteristic(svcs, "2a19")
+	v, err := readCharacteristic(cln, battery, valueCodec{Encoding: encodingUint16, order: binary.LittleEndian})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != nil || v.Hex != "5a" || !strings.Contains(v.Error, "cannot decode 1 bytes") {
+		t.Errorf("unexpected read result %+v", v)
+	}
+	if f := v.Fields(); f[4][1] != "5a (cannot decode 1 bytes (5a) as uint16)" {
+		t.Errorf("unexpected value field %q", f[4][1])
+	}
+}
+
+func TestReadWrite_Properties(t *testing.T) {
+	cln := newFakeClient(sampleGATT()...)
+	svcs, err := discoverServices(context.Background(), cln, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hexCodec := valueCodec{Encoding: encodingHex}
+	nus, _ := findCharacteristic(svcs, "0x0022")
+	if _, err := readCharacteristic(cln, nus, hexCodec); err == nil || !strings.Contains(err.Error(), "not readable") {
+		t.Errorf("want not readable error, got %v", err)
+	}
+	name, _ := findCharacteristic(svcs, "2a00")
+	if _, err := writeCharacteristic(cln, name, hexCodec, "00", false); err == nil {
+		t.Error("want error writing a read-only characteristic")
+	}
+	if len(cln.writes) != 0 {
+		t.Errorf("unexpected writes %+v", cln.writes)
+	}
+	if _, err := readCharacteristic(cln, name, hexCodec); err == nil || !strings.Contains(err.Error(), "read not permitted") {
+		t.Errorf("want ATT error, got %v", err)
+	}
+}
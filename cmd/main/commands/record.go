@@ -0,0 +1,534 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// peekbt 独自の記録形式 (.pbt)
+//
+// ファイル先頭に pbtMagic (8 バイト) を置き、その後ろにフレームを追記していきます。
+//
+//	フレーム = 種別 (1) | 本体長 (uvarint) | 本体 | CRC-32 (種別 + 本体, LE 4 バイト)
+//	'S' セッション開始: 本体 = 基準時刻 (Unix マイクロ秒, varint)
+//	'A' アドバタイズ:   本体 = 直前フレームからの経過マイクロ秒 (uvarint) | アダプタ番号 (1, 0xFF は不明)
+//	                    | フラグ (1: 下位 3bit が Event_Type, bit7 が Random) | RSSI (1)
+//	                    | アドレス (6, 表示順) | AD 構造
+//
+// 書き込み中に落ちても、読み出し側は壊れた末尾フレーム以降を無視します。
+// 圧縮時はストリーム全体を gzip / zstd で包み、追記は新しいメンバ / フレームとして連結します
+const (
+	pbtMagic        = "peekbt\x00\x01"
+	pbtFrameSession = 'S'
+	pbtFrameAdv     = 'A'
+	pbtMaxFrame     = 1 << 12 // 破損検出用の本体長の上限
+
+	// pbtNoAdapter はアダプタを開いていない（再生中など）ときのアダプタ番号
+	pbtNoAdapter = 0xff
+	// recordFlushInterval はバッファを OS へ書き出す間隔
+	recordFlushInterval = time.Second
+)
+
+// 圧縮方式
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	errPbtCorrupt = errors.New("corrupt peekbt recording")
+)
+
+// recordOptions は --record-compress / --record-max-size の組
+type recordOptions struct {
+	Compress string // "" ならファイル名の拡張子 (.gz / .zst) から判断
+	MaxSize  int64  // 0 ならローテーションしない
+}
+
+// compression は使用する圧縮方式を返します
+func (o recordOptions) compression(path string) (string, error) {
+	switch o.Compress {
+	case compressNone, compressGzip, compressZstd:
+		return o.Compress, nil
+	case "":
+		switch {
+		case strings.HasSuffix(path, ".gz"):
+			return compressGzip, nil
+		case strings.HasSuffix(path, ".zst"), strings.HasSuffix(path, ".zstd"):
+			return compressZstd, nil
+		}
+		return compressNone, nil
+	}
+	return "", fmt.Errorf("invalid --record-compress %q (want none, gzip or zstd)", o.Compress)
+}
+
+// parseSize は "100M" / "1GiB" / "4096" のようなサイズを バイト数に変換します（1024 倍単位）
+func parseSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	if t == "" {
+		return 0, nil
+	}
+	t = strings.TrimSuffix(strings.TrimSuffix(t, "B"), "I")
+	mult := int64(1)
+	if n := len(t); n > 0 {
+		if i := strings.IndexByte("KMGT", t[n-1]); i >= 0 {
+			mult <<= 10 * (i + 1)
+			t = t[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q (e.g. 100M, 1G)", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+/* ---------- 書き込み ---------- */
+
+// flushWriteCloser は gzip.Writer / zstd.Encoder の共通部分
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// countingWriter はファイルに書いたバイト数を数えます（ローテーション判定用）
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// recorder は .pbt 形式でアドバタイズを追記する captureWriter
+type recorder struct {
+	mu       sync.Mutex
+	path     string
+	compress string
+	maxSize  int64
+	adapter  byte // 各フレームに記録するアダプタ番号
+
+	f      *os.File
+	cnt    *countingWriter
+	bw     *bufio.Writer
+	z      flushWriteCloser // 圧縮しない場合は nil
+	w      io.Writer
+	lastUS int64 // 直前フレームの時刻（Unix マイクロ秒）
+	inSess bool  // このファイルにセッション開始フレームを書いたか
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newRecorder は path を開いて recorder を返します。
+// 既存ファイルには追記し、途中で途切れた末尾は切り詰めます
+// （圧縮ファイルの末尾が壊れている場合はローテーションして新しいファイルを始めます）
+func newRecorder(path string, opts recordOptions) (*recorder, error) {
+	c, err := opts.compression(path)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxSize < 0 {
+		return nil, fmt.Errorf("invalid --record-max-size %d", opts.MaxSize)
+	}
+	adapter := byte(pbtNoAdapter)
+	if adapterIndex >= 0 {
+		adapter = byte(adapterIndex)
+	}
+	r := &recorder{path: path, compress: c, maxSize: opts.MaxSize, adapter: adapter,
+		stop: make(chan struct{}), done: make(chan struct{})}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	go r.flushLoop()
+	return r, nil
+}
+
+// open は記録ファイルを追記用に開きます
+func (r *recorder) open() error {
+	size, err := r.prepare()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open record file: %w", err)
+	}
+	r.f, r.cnt = f, &countingWriter{w: f, n: size}
+	r.bw = bufio.NewWriter(r.cnt)
+	r.z, r.w, r.inSess = nil, r.bw, false
+	switch r.compress {
+	case compressGzip:
+		r.z = gzip.NewWriter(r.bw)
+	case compressZstd:
+		enc, err := zstd.NewWriter(r.bw, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to initialize zstd: %w", err)
+		}
+		r.z = enc
+	}
+	if r.z != nil {
+		r.w = r.z
+	}
+	if size == 0 {
+		if _, err := io.WriteString(r.w, pbtMagic); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to write record header: %w", err)
+		}
+	}
+	return nil
+}
+
+// prepare は既存ファイルを検証し、追記を始める位置（= ファイルサイズ）を返します
+func (r *recorder) prepare() (int64, error) {
+	st, err := os.Stat(r.path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && st.Size() == 0) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open record file: %w", err)
+	}
+
+	src, err := openAdvSource(r.path)
+	if err != nil {
+		return 0, fmt.Errorf("cannot append to %s: %w", r.path, err)
+	}
+	p, ok := src.(*pbtReader)
+	if !ok {
+		src.Close()
+		return 0, fmt.Errorf("cannot append to %s: not a peekbt recording", r.path)
+	}
+	defer p.Close()
+	for {
+		if _, _, err := p.frame(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return st.Size(), nil
+			}
+			break
+		}
+	}
+
+	// 末尾が壊れている（書き込み中に落ちた）
+	if !p.compressed {
+		if err := os.Truncate(r.path, p.good); err != nil {
+			return 0, fmt.Errorf("failed to repair record file: %w", err)
+		}
+		return p.good, nil
+	}
+	if _, err := rotateAside(r.path); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// WriteAdv はアドバタイズを 1 フレームとして追記します
+func (r *recorder) WriteAdv(a rawAdv, ts time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	us := ts.UnixMicro()
+	if !r.inSess {
+		if err := r.frame(pbtFrameSession, binary.AppendVarint(nil, us)); err != nil {
+			return err
+		}
+		r.lastUS, r.inSess = us, true
+	}
+	// ハンドラは並行に呼ばれるため、前後した時刻は直前と同時刻として扱う
+	dt := us - r.lastUS
+	if dt < 0 {
+		dt = 0
+	}
+	r.lastUS += dt
+
+	flags := a.EventType & 0x07
+	if a.AddrType&0x01 != 0 {
+		flags |= 0x80
+	}
+	// 再生中の記録は元のアダプタ番号を引き継ぐ
+	adapter := r.adapter
+	if a.HasAdapter {
+		adapter = a.Adapter
+	}
+	body := binary.AppendUvarint(make([]byte, 0, 16+len(a.Data)), uint64(dt))
+	body = append(body, adapter, flags, byte(a.RSSI))
+	body = append(body, a.Addr[:]...)
+	body = append(body, a.Data...)
+	if err := r.frame(pbtFrameAdv, body); err != nil {
+		return err
+	}
+
+	if r.maxSize > 0 && r.cnt.n+int64(r.bw.Buffered()) >= r.maxSize {
+		return r.rotate()
+	}
+	return nil
+}
+
+// frame は種別・長さ・CRC を付けて本体を書き込みます
+func (r *recorder) frame(typ byte, body []byte) error {
+	b := binary.AppendUvarint([]byte{typ}, uint64(len(body)))
+	crc := crc32.Update(crc32.ChecksumIEEE([]byte{typ}), crc32.IEEETable, body)
+	for _, p := range [][]byte{b, body, binary.LittleEndian.AppendUint32(nil, crc)} {
+		if _, err := r.w.Write(p); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotate は現在のファイルを閉じて退避し、同じ名前で新しいファイルを始めます
+func (r *recorder) rotate() error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	if _, err := rotateAside(r.path); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// rotateAside は path を session-1.pbt のような未使用の連番の名前に変更します。
+// 連番は拡張子（圧縮の .gz / .zst があればその前の .pbt まで）の直前に入れます
+func rotateAside(path string) (string, error) {
+	dir, base := filepath.Split(path)
+	ext := filepath.Ext(base)
+	if ext == ".gz" || ext == ".zst" {
+		ext = filepath.Ext(strings.TrimSuffix(base, ext)) + ext
+	}
+	if ext == base {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(base, ext)
+	for n := 1; ; n++ {
+		dst := filepath.Join(dir, fmt.Sprintf("%s-%d%s", stem, n, ext))
+		if _, err := os.Stat(dst); errors.Is(err, os.ErrNotExist) {
+			if err := os.Rename(path, dst); err != nil {
+				return "", fmt.Errorf("failed to rotate record file: %w", err)
+			}
+			return dst, nil
+		}
+	}
+}
+
+// flush は圧縮器とバッファの内容をファイルへ書き出します
+func (r *recorder) flush() error {
+	if r.z != nil {
+		if err := r.z.Flush(); err != nil {
+			return fmt.Errorf("failed to write record: %w", err)
+		}
+	}
+	if err := r.bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return nil
+}
+
+// flushLoop は一定間隔でフラッシュし、異常終了時に失うデータを抑えます
+func (r *recorder) flushLoop() {
+	defer close(r.done)
+	t := time.NewTicker(recordFlushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+			r.mu.Lock()
+			if err := r.flush(); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// closeFile は圧縮ストリームを終端してファイルを閉じます
+func (r *recorder) closeFile() error {
+	var err error
+	if r.z != nil {
+		err = r.z.Close()
+	}
+	if ferr := r.bw.Flush(); err == nil {
+		err = ferr
+	}
+	if serr := r.f.Sync(); err == nil {
+		err = serr
+	}
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to close record file: %w", err)
+	}
+	return nil
+}
+
+// Close はフラッシュを止めてファイルを閉じます
+func (r *recorder) Close() error {
+	close(r.stop)
+	<-r.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeFile()
+}
+
+/* ---------- 読み出し ---------- */
+
+// pbtReader は .pbt（圧縮を含む）からアドバタイズを読み出します
+type pbtReader struct {
+	f          *os.File
+	dec        io.ReadCloser // 圧縮を解く場合の reader
+	r          *bufio.Reader
+	compressed bool
+	us         int64 // 直前フレームの時刻
+	inSess     bool
+	pos        int64 // 読んだバイト数（展開後）
+	good       int64 // 最後に正しく読めたフレームの終端
+}
+
+// isPbtCandidate は .pbt またはその圧縮ファイルのマジックかを返します
+func isPbtCandidate(magic []byte) bool {
+	return bytes.Equal(magic, []byte(pbtMagic)) || bytes.HasPrefix(magic, gzipMagic) || bytes.HasPrefix(magic, zstdMagic)
+}
+
+func newPbtReader(f *os.File, r *bufio.Reader) (*pbtReader, error) {
+	p := &pbtReader{f: f, r: r}
+	magic, _ := r.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		z, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gzip header: %w", err)
+		}
+		p.dec = z
+	case bytes.HasPrefix(magic, zstdMagic):
+		z, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize zstd: %w", err)
+		}
+		p.dec = z.IOReadCloser()
+	}
+	if p.dec != nil {
+		p.r, p.compressed = bufio.NewReader(p.dec), true
+	}
+
+	h := make([]byte, len(pbtMagic))
+	if _, err := io.ReadFull(p.r, h); err != nil || string(h) != pbtMagic {
+		p.closeDec()
+		return nil, fmt.Errorf("unknown capture format: %s", f.Name())
+	}
+	p.pos, p.good = int64(len(h)), int64(len(h))
+	return p, nil
+}
+
+// Next は次のアドバタイズを返します。
+// 途中で途切れた・壊れた末尾は記録中の異常終了とみなし、終端 (io.EOF) として扱います
+func (p *pbtReader) Next() (rawAdv, time.Time, error) {
+	for {
+		typ, body, err := p.frame()
+		if err != nil {
+			return rawAdv{}, time.Time{}, io.EOF
+		}
+		switch typ {
+		case pbtFrameSession:
+			us, n := binary.Varint(body)
+			if n <= 0 {
+				return rawAdv{}, time.Time{}, io.EOF
+			}
+			p.us, p.inSess = us, true
+		case pbtFrameAdv:
+			dt, n := binary.Uvarint(body)
+			body = body[max(n, 0):]
+			if n <= 0 || !p.inSess || len(body) < 9 {
+				return rawAdv{}, time.Time{}, io.EOF
+			}
+			p.us += int64(dt)
+			a := rawAdv{EventType: body[1] & 0x07, RSSI: int8(body[2])}
+			if body[0] != pbtNoAdapter {
+				a.Adapter, a.HasAdapter = body[0], true
+			}
+			if body[1]&0x80 != 0 {
+				a.AddrType = 0x01
+			}
+			copy(a.Addr[:], body[3:9])
+			a.Data = body[9:]
+			return a, time.UnixMicro(p.us), nil
+		}
+		// 未知の種別は読み飛ばす（将来の拡張用）
+	}
+}
+
+// frame は 1 フレームを読み、CRC を検証します。
+// ファイルがフレームの境界で終わっていれば io.EOF、それ以外の異常は errPbtCorrupt を返します
+func (p *pbtReader) frame() (byte, []byte, error) {
+	typ, err := p.r.ReadByte()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, fmt.Errorf("%w: %v", errPbtCorrupt, err)
+	}
+	cr := &countingByteReader{r: p.r}
+	n, err := binary.ReadUvarint(cr)
+	if err != nil || n > pbtMaxFrame {
+		return 0, nil, errPbtCorrupt
+	}
+	b := make([]byte, n+4)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		return 0, nil, fmt.Errorf("%w: truncated frame", errPbtCorrupt)
+	}
+	body := b[:n]
+	if crc32.Update(crc32.ChecksumIEEE([]byte{typ}), crc32.IEEETable, body) != binary.LittleEndian.Uint32(b[n:]) {
+		return 0, nil, fmt.Errorf("%w: checksum mismatch", errPbtCorrupt)
+	}
+	p.pos += 1 + cr.n + int64(len(b))
+	p.good = p.pos
+	return typ, body, nil
+}
+
+// countingByteReader は uvarint のバイト数を数えます
+type countingByteReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+func (p *pbtReader) closeDec() {
+	if p.dec != nil {
+		p.dec.Close()
+	}
+}
+
+// Close はファイルを閉じます
+func (p *pbtReader) Close() error {
+	p.closeDec()
+	return p.f.Close()
+}
@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRecording(t *testing.T, path string, opts recordOptions) {
+	t.Helper()
+	r, err := newRecorder(path, opts)
+	if err != nil {
+		t.Fatalf("newRecorder: %v", err)
+	}
+	writeSampleCapture(t, r)
+}
+
+/* ---------- 1. 圧縮方式ごとの往復 ---------- */
+func TestRecorder_RoundTrip(t *testing.T) {
+	for _, name := range []string{"s.pbt", "s.pbt.gz", "s.pbt.zst"} {
+		t.Run(name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), name)
+			writeRecording(t, p, recordOptions{})
+			got, tss := readAll(t, p)
+			assertSamples(t, got, tss)
+		})
+	}
+}
+
+/* ---------- 2. pcap より小さい ---------- */
+func TestRecorder_Compact(t *testing.T) {
+	dir := t.TempDir()
+	pbt, pcap := filepath.Join(dir, "s.pbt"), filepath.Join(dir, "s.pcap")
+	writeRecording(t, pbt, recordOptions{})
+	w, _ := newPcapWriter(pcap)
+	writeSampleCapture(t, w)
+
+	a, _ := os.Stat(pbt)
+	b, _ := os.Stat(pcap)
+	if a.Size() >= b.Size() {
+		t.Fatalf("pbt %d bytes, pcap %d bytes", a.Size(), b.Size())
+	}
+}
+
+/* ---------- 3. 途切れた末尾の無視と修復 ---------- */
+func TestRecorder_TruncatedTail(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "s.pbt")
+	writeRecording(t, p, recordOptions{})
+	st, _ := os.Stat(p)
+	os.Truncate(p, st.Size()-3)
+
+	if got, _ := readAll(t, p); len(got) != 2 {
+		t.Fatalf("read %d advertisements from truncated file, want 2", len(got))
+	}
+
+	// 追記時は壊れた末尾を切り詰めてから続ける
+	r, err := newRecorder(p, recordOptions{})
+	if err != nil {
+		t.Fatalf("newRecorder: %v", err)
+	}
+	writeSampleCapture(t, r)
+	if got, _ := readAll(t, p); len(got) != 5 {
+		t.Fatalf("read %d advertisements after append, want 5", len(got))
+	}
+}
+
+/* ---------- 4. 圧縮ファイルへの追記 ---------- */
+func TestRecorder_AppendCompressed(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "s.pbt.gz")
+	writeRecording(t, p, recordOptions{})
+	writeRecording(t, p, recordOptions{})
+	if got, _ := readAll(t, p); len(got) != 6 {
+		t.Fatalf("read %d advertisements, want 6", len(got))
+	}
+
+	// 壊れた圧縮ファイルは退避して新しく始める
+	st, _ := os.Stat(p)
+	os.Truncate(p, st.Size()-5)
+	writeRecording(t, p, recordOptions{})
+	if got, _ := readAll(t, p); len(got) != 3 {
+		t.Fatalf("read %d advertisements from new file, want 3", len(got))
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(p), "s-1.pbt.gz")); err != nil {
+		t.Fatalf("damaged file not rotated aside: %v", err)
+	}
+}
+
+/* ---------- 5. サイズによるローテーション ---------- */
+func TestRecorder_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "s.pbt")
+	writeRecording(t, p, recordOptions{MaxSize: 40})
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "s-*.pbt"))
+	if len(rotated) < 2 {
+		t.Fatalf("got %d rotated files, want at least 2", len(rotated))
+	}
+	total := 0
+	for _, name := range append(rotated, p) {
+		got, _ := readAll(t, name)
+		total += len(got)
+	}
+	if total != 3 {
+		t.Fatalf("read %d advertisements across rotated files, want 3", total)
+	}
+}
+
+/* ---------- 6. 不正な指定 ---------- */
+func TestRecorder_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := newRecorder(filepath.Join(dir, "a.pbt"), recordOptions{Compress: "xz"}); err == nil {
+		t.Errorf("expected error on unknown compression")
+	}
+	other := filepath.Join(dir, "notes.txt")
+	os.WriteFile(other, []byte("hello, world"), 0o644)
+	if _, err := newRecorder(other, recordOptions{}); err == nil {
+		t.Errorf("expected error when appending to a non-recording file")
+	}
+}
+
+/* ---------- 7. サイズ指定 ---------- */
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{"": 0, "4096": 4096, "100M": 100 << 20, "1GiB": 1 << 30, "1.5k": 1536, "2mb": 2 << 20}
+	for in, want := range cases {
+		if got, err := parseSize(in); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"big", "-1M", "10X"} {
+		if _, err := parseSize(in); err == nil {
+			t.Errorf("parseSize(%q) should fail", in)
+		}
+	}
+}
+
+/* ---------- 8. アダプタ番号 ---------- */
+func TestRecorder_Adapter(t *testing.T) {
+	// adv は記録したファイルの最初のアドバタイズフレームの本体
+	adv := func(p string) []byte {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// magic | 'S' 長さ 本体 CRC | 'A' 長さ 本体 CRC（いずれも本体長は 1 バイトに収まる）
+		i := len(pbtMagic) + 2 + int(data[len(pbtMagic)+1]) + 4
+		if data[i] != pbtFrameAdv {
+			t.Fatalf("unexpected frame %q", data[i])
+		}
+		return data[i+2 : i+2+int(data[i+1])]
+	}
+
+	orig := adapterIndex
+	t.Cleanup(func() { adapterIndex = orig })
+	for idx, want := range map[int]byte{-1: pbtNoAdapter, 0: 0, 2: 2} {
+		adapterIndex = idx
+		p := filepath.Join(t.TempDir(), "s.pbt")
+		writeRecording(t, p, recordOptions{})
+		// 経過時間 (0) の次がアダプタ番号
+		if b := adv(p); !bytes.HasPrefix(b, []byte{0, want}) {
+			t.Errorf("adapter %d: frame starts with % x", idx, b[:2])
+		}
+	}
+}
+
+/* ---------- 9. 再生した記録のアダプタ番号を引き継ぐ ---------- */
+func TestRecorder_AdapterFromReplay(t *testing.T) {
+	orig := adapterIndex
+	t.Cleanup(func() { adapterIndex = orig })
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src.pbt"), filepath.Join(dir, "dst.pbt")
+
+	adapterIndex = 3
+	writeRecording(t, src, recordOptions{})
+	got, _ := readAll(t, src)
+	if len(got) == 0 || !got[0].HasAdapter || got[0].Adapter != 3 {
+		t.Fatalf("adapter not read back: %+v", got)
+	}
+
+	// 再生（--hci なし）から記録し直しても元の番号が残る
+	adapterIndex = -1
+	r, err := newRecorder(dst, recordOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range toRawAdvs(newReplayAdv(got[0], nil)) {
+		if err := r.WriteAdv(a, time.Unix(1700000000, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Close()
+	back, _ := readAll(t, dst)
+	if len(back) != 1 || !back[0].HasAdapter || back[0].Adapter != 3 {
+		t.Fatalf("adapter lost on re-record: %+v", back)
+	}
+}
+
+/* ---------- 10. ローテーション先の名前 ---------- */
+func TestRotateAside(t *testing.T) {
+	dir := t.TempDir()
+	for in, want := range map[string]string{
+		"run.2024.pbt.gz":  "run.2024-1.pbt.gz",
+		"run.2024.pbt.zst": "run.2024-1.pbt.zst",
+		"run.2024.pbt":     "run.2024-1.pbt",
+		"session":          "session-1",
+	} {
+		p := filepath.Join(dir, in)
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := rotateAside(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.Base(got) != want {
+			t.Errorf("rotateAside(%q) = %q, want %q", in, filepath.Base(got), want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux/adv"
+	"github.com/go-ble/ble/linux/hci"
+)
+
+// replayAdv は記録データから復元したアドバタイズ。
+// linux の *hci.Advertisement と同じく生データへのアクセサも持ちます
+type replayAdv struct {
+	raw  rawAdv
+	sr   []byte
+	p    *adv.Packet
+	base *replayAdv // スキャンレスポンスを結合した元のアドバタイズ
+}
+
+func newReplayAdv(r rawAdv, sr []byte) *replayAdv {
+	return &replayAdv{raw: r, sr: sr, p: adv.NewRawPacket(r.Data, sr)}
+}
+
+func (a *replayAdv) LocalName() string              { return a.p.LocalName() }
+func (a *replayAdv) ManufacturerData() []byte       { return a.p.ManufacturerData() }
+func (a *replayAdv) ServiceData() []ble.ServiceData { return a.p.ServiceData() }
+func (a *replayAdv) Services() []ble.UUID           { return a.p.UUIDs() }
+func (a *replayAdv) OverflowService() []ble.UUID    { return a.p.UUIDs() }
+func (a *replayAdv) SolicitedService() []ble.UUID   { return a.p.ServiceSol() }
+func (a *replayAdv) RSSI() int                      { return int(a.raw.RSSI) }
+func (a *replayAdv) EventType() uint8               { return a.raw.EventType }
+func (a *replayAdv) AddressType() uint8             { return a.raw.AddrType }
+func (a *replayAdv) Data() []byte                   { return a.raw.Data }
+func (a *replayAdv) ScanResponse() []byte           { return a.sr }
+
+// adapter は記録したアダプタ番号を返します
+func (a *replayAdv) adapter() (uint8, bool) { return a.raw.Adapter, a.raw.HasAdapter }
+
+// mergedInto はスキャンレスポンスの通知であれば結合先のアドバタイズを返します。
+// go-ble は同じポインタで再通知するため、記録時に同じアドバタイズとして扱えるようにします
+func (a *replayAdv) mergedInto() ble.Advertisement {
+	if a.base == nil {
+		return nil
+	}
+	return a.base
+}
+
+func (a *replayAdv) TxPowerLevel() int {
+	pwr, _ := a.p.TxPower()
+	return pwr
+}
+
+func (a *replayAdv) Connectable() bool {
+	return a.raw.EventType == evtAdvInd || a.raw.EventType == evtAdvDirectInd
+}
+
+// Addr は go-ble と同じく Random アドレスを hci.RandomAddress で包んで返します
+func (a *replayAdv) Addr() ble.Addr {
+	hw := net.HardwareAddr(a.raw.Addr[:])
+	if a.raw.AddrType&0x01 != 0 {
+		return hci.RandomAddress{Addr: hw}
+	}
+	return hw
+}
+
+// replayScanner はキャプチャファイルを実機の代わりに再生する Scanner。
+// Now はキャプチャ上の時刻を返すため、再生速度によらず出力の時刻や消失判定は記録時と同じになります
+type replayScanner struct {
+	path  string
+	speed float64 // 再生倍率（0 なら待たずに最大速度）
+	loop  bool
+
+	epoch time.Time    // 最初のレコードのキャプチャ時刻
+	clock atomic.Int64 // 最後に読んだレコードのキャプチャ時刻（UnixNano、0 なら未読）
+}
+
+// Now は最後に読んだレコードのキャプチャ時刻を返します（ループ再生では周回分だけ進みます）。
+// まだ何も読んでいなければ現在時刻を返します
+func (r *replayScanner) Now() time.Time {
+	if ns := r.clock.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Now()
+}
+
+// replayLoopGap はループ再生で、間隔の分からないファイル（1 件だけ、全て同じ時刻）の周回の間に空ける時間
+const replayLoopGap = 100 * time.Millisecond
+
+// Scan はファイル内のアドバタイズを記録時の間隔 / speed で h に渡します。
+// ファイル終端に達すると（loop でなければ）nil を返します
+func (r *replayScanner) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler, f ble.AdvFilter) error {
+	seen := make(map[string]struct{})
+	start := time.Now()
+	r.epoch = time.Time{}
+	r.clock.Store(0)
+	var offset time.Duration // ループ毎に進める仮想時刻のずれ
+	for {
+		last, n, err := r.play(ctx, start, offset, allowDup, seen, h, f)
+		if err != nil {
+			return err
+		}
+		if !r.loop {
+			return nil
+		}
+		if n == 0 {
+			return fmt.Errorf("cannot loop %s: no advertisements in the file", r.path)
+		}
+		// 次の周回は最後のレコードから平均的なレコード間隔を空けて始める
+		gap := replayLoopGap
+		if span := last - offset; n > 1 && span > 0 {
+			gap = span / time.Duration(n-1)
+		}
+		offset = last + gap
+	}
+}
+
+// play はファイルを 1 回再生し、最後のレコードの仮想時刻と読んだアドバタイズの数を返します
+func (r *replayScanner) play(ctx context.Context, start time.Time, offset time.Duration, allowDup bool,
+	seen map[string]struct{}, h ble.AdvHandler, f ble.AdvFilter) (time.Duration, int, error) {
+	src, err := openAdvSource(r.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	var t0 time.Time
+	last, n := offset, 0
+	advs := make(map[[6]byte]*replayAdv) // スキャンレスポンス結合用
+	for {
+		if err := ctx.Err(); err != nil {
+			return last, n, err
+		}
+		raw, ts, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return last, n, nil
+		}
+		if err != nil {
+			return last, n, err
+		}
+		n++
+		if t0.IsZero() {
+			t0 = ts
+		}
+		if r.epoch.IsZero() {
+			r.epoch = ts
+		}
+		last = offset + ts.Sub(t0)
+		if err := r.wait(ctx, start, last); err != nil {
+			return last, n, err
+		}
+		r.clock.Store(r.epoch.Add(last).UnixNano())
+
+		// go-ble と同様にスキャンレスポンスは直前のアドバタイズに結合して通知する
+		var a *replayAdv
+		if raw.EventType == evtScanRsp {
+			base, ok := advs[raw.Addr]
+			if !ok {
+				continue
+			}
+			merged := base.raw
+			merged.RSSI = raw.RSSI
+			a = newReplayAdv(merged, raw.Data)
+			a.base = base
+		} else {
+			a = newReplayAdv(raw, nil)
+			advs[raw.Addr] = a
+		}
+
+		if !allowDup {
+			key := string(raw.Addr[:]) + string(raw.Data)
+			if _, dup := seen[key]; dup {
+				continue
+			}
+			seen[key] = struct{}{}
+		}
+		if f == nil || f(a) {
+			h(a)
+		}
+	}
+}
+
+// wait は仮想時刻 at に対応する実時刻まで待ちます
+func (r *replayScanner) wait(ctx context.Context, start time.Time, at time.Duration) error {
+	if r.speed <= 0 {
+		return nil
+	}
+	d := time.Until(start.Add(time.Duration(float64(at) / r.speed)))
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// parseSpeed は "1x" / "10x" / "0.5" / "max" を再生倍率に変換します（max は 0）
+func parseSpeed(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "max" {
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "x"), 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid replay speed %q (e.g. 1x, 10x, max)", s)
+	}
+	return v, nil
+}
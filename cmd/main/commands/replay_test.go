@@ -0,0 +1,208 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+func samplePcap(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "sample.pcap")
+	w, err := newPcapWriter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSampleCapture(t, w)
+	return p
+}
+
+/* ---------- 1. 最大速度での再生 ---------- */
+func TestReplayScanner_Max(t *testing.T) {
+	r := &replayScanner{path: samplePcap(t)}
+	var got []ble.Advertisement
+	err := r.Scan(context.Background(), true, func(a ble.Advertisement) { got = append(got, a) }, nil)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d advertisements, want 3", len(got))
+	}
+	// スキャンレスポンスは元のアドバタイズに結合される
+	sr := got[2]
+	if sr.Addr().String() != "f4:8c:50:01:02:03" || sr.LocalName() != "Tile" || len(sr.Services()) != 1 || !sr.Connectable() {
+		t.Fatalf("scan response not merged: name=%q services=%v", sr.LocalName(), sr.Services())
+	}
+	if peekbt.AddressType(got[0]) != "Public" || peekbt.AddressType(got[1]) != "Static Random" {
+		t.Fatalf("address types: %s, %s", peekbt.AddressType(got[0]), peekbt.AddressType(got[1]))
+	}
+}
+
+/* ---------- 2. フィルタ・重複除去・ループ ---------- */
+func TestReplayScanner_FilterDedupLoop(t *testing.T) {
+	r := &replayScanner{path: samplePcap(t), loop: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := 0
+	onlyRandom := addrTypeFilter(false, true)
+	err := r.Scan(ctx, false, func(a ble.Advertisement) {
+		n++
+		if n == 1 {
+			// ループしても重複は除去されるので、少し待ってから止める
+			time.AfterFunc(50*time.Millisecond, cancel)
+		}
+	}, onlyRandom)
+	if err != context.Canceled {
+		t.Fatalf("looping replay should end with context.Canceled, got %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d advertisements, want 1", n)
+	}
+}
+
+/* ---------- 3. 等倍再生のタイミング ---------- */
+func TestReplayScanner_Speed(t *testing.T) {
+	r := &replayScanner{path: samplePcap(t), speed: 2}
+	start := time.Now()
+	if err := r.Scan(context.Background(), true, func(ble.Advertisement) {}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 記録上 200ms のキャプチャを 2 倍速で再生 → 約 100ms
+	if d := time.Since(start); d < 90*time.Millisecond || d > time.Second {
+		t.Fatalf("replay took %v, want about 100ms", d)
+	}
+}
+
+func TestReplayScanner_LoopGap(t *testing.T) {
+	// 1 件だけのファイルでも周回の間は空ける
+	p := filepath.Join(t.TempDir(), "one.pcap")
+	w, err := newPcapWriter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteAdv(replaySamples[0], time.Unix(1700000000, 0))
+	w.Close()
+
+	r := &replayScanner{path: p, speed: 10, loop: true}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	n := 0
+	if err := r.Scan(ctx, true, func(ble.Advertisement) { n++ }, nil); err != context.DeadlineExceeded {
+		t.Fatalf("Scan: %v", err)
+	}
+	// 周回の間は replayLoopGap / 10 = 10ms
+	if n < 2 || n > 20 {
+		t.Errorf("got %d passes in 100ms", n)
+	}
+
+	// アドバタイズが 1 件もないファイルはループできない
+	empty := filepath.Join(t.TempDir(), "empty.pcap")
+	w, _ = newPcapWriter(empty)
+	w.Close()
+	r = &replayScanner{path: empty, loop: true}
+	if err := r.Scan(context.Background(), true, func(ble.Advertisement) {}, nil); err == nil {
+		t.Error("want error for a capture without advertisements")
+	}
+}
+
+/* ---------- 4. 再生速度の指定 ---------- */
+func TestParseSpeed(t *testing.T) {
+	cases := map[string]float64{"1x": 1, "10x": 10, "0.5": 0.5, "MAX": 0}
+	for in, want := range cases {
+		if got, err := parseSpeed(in); err != nil || got != want {
+			t.Errorf("parseSpeed(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "fast", "-1x", "0"} {
+		if _, err := parseSpeed(in); err == nil {
+			t.Errorf("parseSpeed(%q) should fail", in)
+		}
+	}
+}
+
+/* ---------- 5. info を再生データで実行 ---------- */
+func TestReplay_InfoCommand(t *testing.T) {
+	origScanner := DefaultScanner
+	defer func() {
+		DefaultScanner, replayMode, replayPath, replaySpeed = origScanner, false, "", "1x"
+		infoOut = outputSpec{}
+	}()
+
+	out := filepath.Join(t.TempDir(), "info.json")
+	rootCommand.SetArgs([]string{"--replay", samplePcap(t), "--speed", "max",
+		"info", "--format", "json", "-o", out, "--filter", `name == "Tile"`})
+	if err := rootCommand.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	b, _ := os.ReadFile(out)
+	var info peekbt.DeviceInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		t.Fatalf("invalid JSON %q: %v", b, err)
+	}
+	if info.Address != "f4:8c:50:01:02:03" || info.AddressType != "Public" || !info.Connectable {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+}
+
+/* ---------- 6. 出力の時刻はキャプチャ上の時刻 ---------- */
+func TestReplay_ScanCaptureClock(t *testing.T) {
+	origScanner := DefaultScanner
+	origStdout := os.Stdout
+	defer func() {
+		DefaultScanner, replayMode, replayPath, replaySpeed = origScanner, false, "", "1x"
+		scanJSON, scanEvents, scanTime = "", "adv", 0
+		os.Stdout = origStdout
+	}()
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	os.Stdout = devnull
+
+	// 2 台目だけが 12 秒後にも見える（1 台目は 10 秒で消える）
+	p := filepath.Join(t.TempDir(), "clock.pcap")
+	w, err := newPcapWriter(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Unix(1700000000, 0)
+	w.WriteAdv(replaySamples[0], t0)
+	w.WriteAdv(replaySamples[1], t0.Add(time.Second))
+	w.WriteAdv(replaySamples[1], t0.Add(12*time.Second))
+	w.Close()
+
+	out := filepath.Join(t.TempDir(), "scan.ndjson")
+	rootCommand.SetArgs([]string{"--replay", p, "--speed", "max",
+		"scan", "-t", "5", "--json", out, "--json-events", "changes"})
+	if err := rootCommand.Execute(); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+
+	type ev struct {
+		event string
+		at    time.Time
+	}
+	var got []ev
+	for _, e := range readEvents(t, out) {
+		at, err := time.Parse(time.RFC3339Nano, e["timestamp"].(string))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, ev{e["event"].(string), at})
+	}
+	want := []ev{{eventNew, t0}, {eventNew, t0.Add(time.Second)}, {eventLost, t0.Add(12 * time.Second)}}
+	if len(got) != len(want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].event != want[i].event || !got[i].at.Equal(want[i].at) {
+			t.Errorf("event %d = %s at %v, want %s at %v", i, got[i].event, got[i].at, want[i].event, want[i].at)
+		}
+	}
+}
@@ -7,12 +7,47 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var (
+	replayPath  string
+	replaySpeed string
+	replayLoop  bool
+)
+
 var rootCommand = &cobra.Command{
 	Use:   "peek",
 	Short: "Scan and interact with nearby Bluetooth devices.",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return errors.New("no command specified")
 	},
+	PersistentPreRunE: setupReplay,
+}
+
+func init() {
+	rootCommand.PersistentFlags().IntVar(&hciDevice, "hci", -1, "HCI adapter index to use, e.g. 1 for hci1 (default: the first available adapter)")
+	rootCommand.PersistentFlags().StringVar(&replayPath, "replay", "", "Replay advertisements from a pcap/btsnoop capture or .pbt recording instead of the adapter")
+	rootCommand.PersistentFlags().StringVar(&replaySpeed, "speed", "1x", "Replay speed (e.g. 1x, 10x, max)")
+	rootCommand.PersistentFlags().BoolVar(&replayLoop, "loop", false, "Restart the replay from the beginning when it ends")
+}
+
+// setupReplay は --replay 指定時に DefaultScanner を再生用に差し替えます
+func setupReplay(cmd *cobra.Command, args []string) error {
+	if replayPath == "" {
+		return nil
+	}
+	speed, err := parseSpeed(replaySpeed)
+	if err != nil {
+		return err
+	}
+	// 形式の判別だけ先に行い、不正なファイルは実行前にエラーにする
+	src, err := openAdvSource(replayPath)
+	if err != nil {
+		return err
+	}
+	src.Close()
+
+	DefaultScanner = &replayScanner{path: replayPath, speed: speed, loop: replayLoop}
+	replayMode = true
+	return nil
 }
 
 // Execute runs the root command and handles subcommands.
@@ -2,16 +2,18 @@ package commands
 
 import (
 	"bufio"
+	"container/list"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
 	"github.com/spf13/cobra"
 )
 
@@ -29,11 +31,32 @@ type entryDisplay struct {
 }
 
 var (
-	scanTime int
-	randOnly bool
-	pubOnly  bool
+	scanTime   int
+	randOnly   bool
+	pubOnly    bool
+	scanFilter string
+	scanAddrs  []string
+	scanAllow  string
+	scanDeny   string
+	scanOpts   = defaultScanSettings
+	scanJSON   string
+	scanEvents string
+	scanCSV    string
+	scanCSVBy  string
+	scanTSV    bool
+	scanPcap   string
+	scanSnoop  string
+	scanRecord string
+	scanRecOpt recordOptions
+	scanRecMax string
+	scanOut    sinkOptions
+	scanQueue  queueOptions
+	scanDraw   time.Duration
 )
 
+// scanDeviceTTL はこの間アドバタイズが無ければ表から消す時間（--device-ttl で変更できる）
+var scanDeviceTTL = 10 * time.Second
+
 var scanCommand = &cobra.Command{
 	Use:   "scan",
 	Short: "Scan for nearby Bluetooth devices.",
@@ -44,6 +67,27 @@ func init() {
 	scanCommand.Flags().IntVarP(&scanTime, "time", "t", 0, "Scan time in seconds (0 = infinite)")
 	scanCommand.Flags().BoolVar(&randOnly, "rand", false, "Random address only.")
 	scanCommand.Flags().BoolVar(&pubOnly, "pub", false, "Public address only.")
+	scanCommand.Flags().StringVarP(&scanJSON, "json", "j", "", `Stream NDJSON to the specified file ("-" for stdout, disables the table)`)
+	scanCommand.Flags().StringVar(&scanEvents, "json-events", "adv", "NDJSON granularity: adv (every advertisement) or changes (new/update/lost)")
+	scanCommand.Flags().StringVar(&scanCSV, "csv", "", "Write scan results as CSV to the specified file")
+	scanCommand.Flags().StringVar(&scanCSVBy, "csv-mode", csvModeDevice, "CSV rows: device (per-device aggregate at exit) or obs (one row per advertisement)")
+	scanCommand.Flags().BoolVar(&scanTSV, "tsv", false, "Write --csv output as TSV instead of RFC 4180 CSV")
+	scanCommand.Flags().StringVar(&scanPcap, "pcap", "", "Record advertisements to a pcap file (LINKTYPE_BLUETOOTH_LE_LL_WITH_PHDR)")
+	scanCommand.Flags().StringVar(&scanSnoop, "btsnoop", "", "Record advertisements to a btsnoop (HCI H4) file")
+	scanCommand.Flags().StringVar(&scanRecord, "record", "", "Append advertisements to a compact peekbt recording (.pbt)")
+	scanCommand.Flags().StringVar(&scanRecOpt.Compress, "record-compress", "", "Compression for --record: none|gzip|zstd (default: from the file extension)")
+	scanCommand.Flags().StringVar(&scanRecMax, "record-max-size", "", "Rotate the --record file when it reaches this size (e.g. 100M, 1G)")
+	scanCommand.Flags().BoolVar(&scanOpts.Passive, "passive", false, "Passive scanning (do not send SCAN_REQ)")
+	scanCommand.Flags().DurationVar(&scanOpts.Interval, "interval", defaultScanSettings.Interval, "Scan interval (2.5ms - 10.24s)")
+	scanCommand.Flags().DurationVar(&scanOpts.Window, "window", defaultScanSettings.Window, "Scan window (<= interval)")
+	scanCommand.Flags().BoolVar(&scanOpts.Dedupe, "dedupe", false, "Let the controller filter duplicate advertisements")
+	scanCommand.Flags().StringSliceVar(&scanAddrs, "addr", nil, "Only show these addresses (repeatable); uses the controller accept list when possible")
+	scanCommand.Flags().StringVar(&scanAllow, "allow", "", "Show only devices listed in the file (addresses, prefixes, company IDs or names)")
+	scanCommand.Flags().StringVar(&scanDeny, "deny", "", "Hide devices listed in the file (addresses, prefixes, company IDs or names)")
+	scanCommand.Flags().StringVar(&scanFilter, "filter", "", `Filter expression (e.g. 'rssi > -70 && name =~ "^Tile"')`)
+	scanCommand.Flags().DurationVar(&scanDraw, "refresh", 200*time.Millisecond, "Refresh interval of the table")
+	addSinkFlags(scanCommand, &scanOut)
+	addQueueFlags(scanCommand, &scanQueue)
 	rootCommand.AddCommand(scanCommand)
 }
 
@@ -53,107 +97,230 @@ func runScanCommand(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("flags --rand and --pub are mutually exclusive")
 	}
 
-	// BLEデバイス初期化
-	if _, err := InitDefaultAdapter(); err != nil {
+	// アドレス指定とフィルタ式の検証
+	for i, a := range scanAddrs {
+		scanAddrs[i] = strings.ToLower(a)
+		if err := validateAddr(scanAddrs[i]); err != nil {
+			return err
+		}
+	}
+	flt, err := parseFilter(scanFilter)
+	if err != nil {
+		return err
+	}
+	allow, deny, err := loadAccessLists(scanAllow, scanDeny)
+	if err != nil {
+		return err
+	}
+	params, err := scanOpts.hciParams()
+	if err != nil {
+		return err
+	}
+	if scanEvents != "adv" && scanEvents != "changes" {
+		return fmt.Errorf("invalid --json-events %q (want adv or changes)", scanEvents)
+	}
+	if scanRecOpt.MaxSize, err = parseSize(scanRecMax); err != nil {
+		return err
+	}
+	if err := scanOut.validate(); err != nil {
 		return err
 	}
+	if scanDraw <= 0 {
+		return fmt.Errorf("invalid --refresh %s", scanDraw)
+	}
 
-	/*// フィルタを組み立てる
-		var advFilter ble.AdvFilter
-		switch {
-		case pubOnly:
-	    	// MSB が 00xxxxxx → Public
-		    advFilter = func(a ble.Advertisement) bool {
-	    		oct, _ := strconv.ParseUint(strings.Split(a.Addr().String(), ":")[0], 16, 8)
-	    		return (oct & 0xC0) == 0x00
-	    }
-		case randOnly:
-	    // MSB が 1xxxxxxx → Random
-	    	advFilter = func(a ble.Advertisement) bool {
-	        	oct, _ := strconv.ParseUint(strings.Split(a.Addr().String(), ":")[0], 16, 8)
-	        	return (oct & 0xC0) != 0x00
-	    }
-		default:
-	    	advFilter = nil
-		}*/
-
-	results := make(map[string]deviceEntry)
-	displayed := make(map[string]entryDisplay)
-	order := make([]string, 0, 16)
-	var mu sync.Mutex
+	// 受信時刻と TTL の判定は再生中はキャプチャ上の時刻に従う
+	clock := scanClock(DefaultScanner)
+	// 出力先（REST API・MQTT など）も同じデバイス表に従う
+	ttl := scanDeviceTTL
+	if cmd.Flags().Changed("device-ttl") {
+		ttl = scanOut.TTL
+	}
+	// 受信ハンドラと出力の間のキュー（TUI の描画などが遅れても HCI を待たせない）
+	sessCfg := peekbt.Config{
+		Scanner:    DefaultScanner,
+		Duplicates: !scanOpts.Dedupe,
+		TTL:        ttl,
+		Now:        clock,
+		MaxDevices: scanOut.Max,
+	}
+	if err := scanQueue.apply(&sessCfg); err != nil {
+		return err
+	}
 
-	// コンテキスト作成
-	ctx, cancel := NewTimeoutCtx(scanTime)
-	defer cancel()
+	// BLEデバイス初期化とスキャンパラメータ設定
+	dev, err := InitDefaultAdapter()
+	if err != nil {
+		return err
+	}
+	if err := configureScan(dev, params); err != nil {
+		return err
+	}
 
-	// 終了キー監視
-	handleUserCancel(scanTime, cancel)
-
-	// --- 最初に一度だけクリア＆ヘッダを描画 ---
-	fmt.Print("\033[2J\033[H")
-	drawHeader()
-
-	// 描画ループ開始
-	go func() {
-		ticker := time.NewTicker(200 * time.Millisecond)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				mu.Lock()
-				pruneStaleDevices(results, displayed, &order)
-				drawBody(displayed, order)
-				mu.Unlock()
-			}
+	// アドレス指定（無ければ完全一致だけの allow リスト）をコントローラの Filter Accept List に登録
+	accept, fromAllow := acceptListAddrs(scanAddrs, allow)
+	restore, programmed, err := programAcceptList(senderFor(dev), accept, acceptListTypes(pubOnly, randOnly), params)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	if programmed && fromAllow {
+		allow.inController.Store(true)
+	}
+
+	// フィルタを ble.AdvFilter として組み立てる（ハンドラのロック外で評価される）。
+	// アドレス指定はリストに載らなかった場合だけホスト側で絞り込む
+	var addrFilter ble.AdvFilter
+	if !programmed {
+		addrFilter = addrSetFilter(scanAddrs)
+	}
+	sessCfg.Filter = buildAdvFilter(
+		addrTypeFilter(pubOnly, randOnly),
+		addrFilter,
+		accessFilter(allow, deny),
+		exprFilter(flt),
+	)
+	sess := peekbt.NewSession(sessCfg)
+
+	// NDJSON 出力（標準出力に流す場合はテーブル描画を行わない）。
+	// 終了方法の案内はテーブルのフッタに描き、テーブルが無ければ標準エラーに出す
+	tui, msgOut := true, io.Writer(nil)
+	var nd *ndjsonWriter
+	if scanJSON != "" {
+		if nd, err = newNDJSONWriter(scanJSON, scanEvents == "changes"); err != nil {
+			return err
 		}
-	}()
+		defer nd.Close()
+		if scanJSON == "-" {
+			tui, msgOut = false, os.Stderr
+		}
+	}
 
-	// 実際のスキャン
-	err := DefaultScanner.Scan(ctx, true, func(a ble.Advertisement) {
-		addr := a.Addr().String()
-		// フィルタ
-		firstOctet, _ := strconv.ParseUint(strings.Split(addr, ":")[0], 16, 8)
-		isPub := firstOctet&0xC0 == 0x00
-		if pubOnly && !isPub {
-			return
+	// CSV / TSV 出力（中断時も Close で集計行を書き出す）
+	var csvOut *csvExporter
+	if scanCSV != "" {
+		if csvOut, err = newCSVExporter(scanCSV, scanCSVBy, scanTSV); err != nil {
+			return err
 		}
-		if randOnly && isPub {
-			return
+		defer func() {
+			if cerr := csvOut.Close(); cerr != nil {
+				fmt.Fprintln(os.Stderr, cerr)
+			}
+		}()
+	}
+
+	// pcap / btsnoop / .pbt へのキャプチャ（テーブル表示と同時に記録できる）
+	captures, err := openCaptures(scanPcap, scanSnoop)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := closeCaptures(captures); cerr != nil {
+			fmt.Fprintln(os.Stderr, cerr)
 		}
-		r := a.RSSI()
-		name := a.LocalName()
-		if name == "" {
-			name = "(no name)"
+	}()
+	if scanRecord != "" {
+		rec, err := newRecorder(scanRecord, scanRecOpt)
+		if err != nil {
+			return err
 		}
+		captures = append(captures, rec)
+	}
 
-		mu.Lock()
-		// 新規デバイスなら順序追加＆ハイライト「all」
-		if _, seen := results[addr]; !seen {
-			order = append(order, addr)
-			displayed[addr] = entryDisplay{
-				entry:     deviceEntry{addr, name, r, time.Now()},
-				colorTTL:  time.Now().Add(1 * time.Second),
-				highlight: "all",
+	// コンテキスト作成
+	ctx, cancel := NewTimeoutCtx(scanTime)
+	defer cancel()
+	ctx, stop := withInterrupt(ctx)
+	defer stop()
+
+	// 終了キー監視
+	handleUserCancel(msgOut, scanTime, cancel)
+
+	// SIGHUP で allow / deny リストを再読込
+	reloadOnSIGHUP(ctx, func(err error) { fmt.Fprintln(os.Stderr, err) }, allow, deny)
+
+	// 出力先はそれぞれイベントバスを購読し、独立して動く。
+	// 書き込みに失敗したらスキャンを止める
+	emit := func(err error) {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			cancel()
+		}
+	}
+	bus := newEventBus()
+	bus.lossless = maxSpeedReplay(DefaultScanner)
+	view := newScanView()
+	view.stats = sess.QueueStats
+	view.hint = cancelHint(scanTime)
+	if tui {
+		bus.subscribe("tui", view.apply, peekbt.EventNew, peekbt.EventAdv, peekbt.EventLost)
+	}
+	if nd != nil {
+		bus.subscribe("ndjson", func(ev peekbt.Event) { emit(nd.Event(ev)) }, nd.eventTypes()...)
+	}
+	if len(captures) > 0 {
+		// 購読者毎に 1 つの goroutine で呼ばれるため rawSplitter をそのまま使える
+		split := newRawSplitter()
+		bus.subscribe("capture", func(ev peekbt.Event) {
+			for _, raw := range split.split(ev.Adv) {
+				for _, c := range captures {
+					emit(c.WriteAdv(raw, ev.Time))
+				}
 			}
-		} else {
-			// 更新のみ
-			results[addr] = deviceEntry{addr, name, r, time.Now()}
-			// colorTTL は新規時のみ設定
-			displayed[addr] = entryDisplay{
-				entry:     results[addr],
-				colorTTL:  displayed[addr].colorTTL,
-				highlight: "",
+		}, peekbt.EventAdv)
+	}
+	if csvOut != nil {
+		bus.subscribe("csv", func(ev peekbt.Event) {
+			if ev.Type == peekbt.EventLost {
+				emit(csvOut.Lost(ev.Entry.Info.Address))
+				return
 			}
+			emit(csvOut.Advertisement(ev.Adv, ev.Time))
+		}, peekbt.EventAdv, peekbt.EventLost)
+	}
+	if scanOut.enabled() {
+		sinks, err := scanOut.open(ctx, bus, sess)
+		if err != nil {
+			return err
 		}
-		results[addr] = deviceEntry{addr, name, r, time.Now()}
-		mu.Unlock()
-	}, nil)
+		defer sinks.Close()
+	}
+	bus.start()
+	defer bus.close()
+
+	// 描画ループ開始（前回のフレームとの差分だけを書き出す）
+	drawn := make(chan struct{})
+	if tui {
+		scr := newRenderer(os.Stdout)
+		go func() {
+			defer close(drawn)
+			ticker := time.NewTicker(scanDraw)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					scr.render(view.frame(scanOpts, clock()))
+					scr.finish()
+					return
+				case <-ticker.C:
+					if err := scr.render(view.frame(scanOpts, clock())); err != nil {
+						emit(err)
+						return
+					}
+				}
+			}
+		}()
+	} else {
+		close(drawn)
+	}
 
-	// 正常終了判定
-	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
-		fmt.Println() // 最後に改行だけ入れる
+	// 実際のスキャン（イベントはセッションからバスへ流れる）
+	err = sess.Run(ctx, bus.publish)
+	cancel()
+	<-drawn
+
+	// 正常終了判定（再生モードではファイル終端で nil が返る）
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return nil
 	}
 	return err
@@ -166,10 +333,21 @@ func makeContext(seconds int) (context.Context, context.CancelFunc) {
 	return context.WithTimeout(context.Background(), time.Duration(seconds)*time.Second)
 }
 
-func handleUserCancel(seconds int, cancel context.CancelFunc) {
+// cancelHint は終了方法の案内を返します
+func cancelHint(seconds int) string {
+	if seconds == 0 {
+		return "Scanning... (press 'e' + Enter to exit)"
+	}
+	return fmt.Sprintf("Scanning for %d seconds...", seconds)
+}
+
+// handleUserCancel は 'e' + Enter で cancel を呼びます。out が nil でなければ案内を書き出します
+func handleUserCancel(out io.Writer, seconds int, cancel context.CancelFunc) {
+	if out != nil {
+		fmt.Fprintln(out, cancelHint(seconds))
+	}
 	if seconds == 0 {
 		go func() {
-			fmt.Println("Scanning... (press 'e' + Enter to exit)")
 			r := bufio.NewReader(os.Stdin)
 			for {
 				line, _ := r.ReadString('\n')
@@ -179,46 +357,86 @@ func handleUserCancel(seconds int, cancel context.CancelFunc) {
 				}
 			}
 		}()
-	} else {
-		fmt.Printf("Scanning for %d seconds...\n", seconds)
 	}
 }
 
-// drawHeader はヘッダ部のみ描画
-func drawHeader() {
-	fmt.Println("ADDR                 RSSI   NAME")
-	fmt.Println(strings.Repeat("-", 50))
+// scanView はテーブル表示の状態（表示順と新規デバイスのハイライト）
+type scanView struct {
+	mu        sync.Mutex
+	displayed map[string]*list.Element // 値は *entryDisplay（削除を O(1) にするため order の要素を引く）
+	order     *list.List               // 初めて見えた順
+	stats     func() peekbt.QueueStats // フッタに出す受信キューの状態（nil なら出さない）
+	hint      string                   // フッタに出す操作の案内（画面のクリアで消えないようフレームに含める）
 }
 
-// drawBody はヘッダ下から各行を上書き
-func drawBody(displayed map[string]entryDisplay, order []string) {
-	for i, addr := range order {
-		disp := displayed[addr]
-		entry := disp.entry
-		colS, colE := "", ""
-		// 新規デバイスのみ緑ハイライト
-		if disp.highlight == "all" && time.Now().Before(disp.colorTTL) {
-			colS, colE = "\033[32m", "\033[0m"
+func newScanView() *scanView {
+	return &scanView{displayed: make(map[string]*list.Element), order: list.New()}
+}
+
+// apply はセッションのイベントを表示に反映します
+func (v *scanView) apply(ev peekbt.Event) {
+	addr := ev.Entry.Info.Address
+	name := ev.Entry.Info.Name
+	if name == "" {
+		name = "(no name)"
+	}
+	entry := deviceEntry{addr, name, ev.Entry.Info.RSSI, ev.Time}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	switch ev.Type {
+	case peekbt.EventNew:
+		// 新規デバイスなら順序追加＆ハイライト「all」
+		v.displayed[addr] = v.order.PushBack(&entryDisplay{entry: entry, colorTTL: ev.Time.Add(time.Second), highlight: "all"})
+	case peekbt.EventAdv:
+		// 既知のデバイスは更新のみ（colorTTL は新規時のみ設定）
+		if el, ok := v.displayed[addr]; ok {
+			d := el.Value.(*entryDisplay)
+			d.entry, d.highlight = entry, ""
+		}
+	case peekbt.EventLost:
+		if el, ok := v.displayed[addr]; ok {
+			v.order.Remove(el)
+			delete(v.displayed, addr)
 		}
-		// ヘッダ２行分をスキップして i+3 行目へ移動
-		fmt.Printf("\033[%d;0H", i+3)
-		fmt.Printf("%s%-20s %-6d %-20s%s", colS, entry.addr, entry.rssi, entry.name, colE)
-		// 行末クリア
-		fmt.Print("\033[K")
 	}
 }
 
-// pruneStaleDevices は最後受信から10秒経過したデバイスを削除
-func pruneStaleDevices(results map[string]deviceEntry, displayed map[string]entryDisplay, order *[]string) {
-	cutoff := time.Now().Add(-10 * time.Second)
-	newOrder := (*order)[:0]
-	for _, addr := range *order {
-		if ent, ok := results[addr]; ok && ent.seen.After(cutoff) {
-			newOrder = append(newOrder, addr)
-		} else {
-			delete(results, addr)
-			delete(displayed, addr)
+// frame は now の時点のテーブル（スキャン設定・列名・各デバイス・フッタ）を組み立てます
+func (v *scanView) frame(opts scanSettings, now time.Time) frame {
+	var f frame
+	f.add("", fmt.Sprintf("Scan: %s", opts))
+	f.add("", "ADDR                 RSSI   NAME")
+	f.add("", strings.Repeat("-", 50))
+
+	v.mu.Lock()
+	for el := v.order.Front(); el != nil; el = el.Next() {
+		disp := el.Value.(*entryDisplay)
+		style := ""
+		// 新規デバイスのみ緑ハイライト
+		if disp.highlight == "all" && now.Before(disp.colorTTL) {
+			style = "32"
 		}
+		f.add(style, strings.TrimRight(fmt.Sprintf("%-20s %-6d %s", disp.entry.addr, disp.entry.rssi, disp.entry.name), " "))
+	}
+	v.mu.Unlock()
+
+	if v.stats != nil || v.hint != "" {
+		f.add("", "")
+	}
+	if v.stats != nil {
+		f.add("", formatQueueStats(v.stats()))
+	}
+	if v.hint != "" {
+		f.add("", v.hint)
+	}
+	return f
+}
+
+// formatQueueStats はフッタの 1 行。処理が追いつかずに捨てた / まとめた数を出します
+func formatQueueStats(st peekbt.QueueStats) string {
+	if st.Cap == 0 {
+		return "queue: off"
 	}
-	*order = newOrder
+	return fmt.Sprintf("queue: %d/%d  dropped: %d  coalesced: %d", st.Len, st.Cap, st.Dropped, st.Coalesced)
 }
@@ -1,14 +1,12 @@
 package commands
 
 import (
-	"bytes"
 	"context"
-	"io"
-	"os"
 	"testing"
 	"time"
 
 	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
 	"github.com/spf13/cobra"
 )
 
@@ -22,20 +20,28 @@ func (m mockScanner) Scan(ctx context.Context, b bool,
 	return m.fn(ctx, b, h, f)
 }
 
-/* ---------- 1. pruneStaleDevices ---------- */
-func TestPruneStaleDevices(t *testing.T) {
+/* ---------- 1. テーブル表示の状態 ---------- */
+func TestScanView(t *testing.T) {
 	now := time.Now()
-	results := map[string]deviceEntry{
-		"AA": {seen: now},
-		"BB": {seen: now.Add(-11 * time.Second)},
+	ev := func(typ peekbt.EventType, addr, name string, rssi int) peekbt.Event {
+		return peekbt.Event{Type: typ, Time: now, Entry: peekbt.Entry{Info: peekbt.DeviceInfo{Address: addr, Name: name, RSSI: rssi}}}
+	}
+	v := newScanView()
+	v.apply(ev(peekbt.EventAdv, "AA", "", -70)) // new より前の adv は無視
+	v.apply(ev(peekbt.EventNew, "AA", "", -70))
+	v.apply(ev(peekbt.EventNew, "BB", "tag", -60))
+	if d := v.displayed["AA"].Value.(*entryDisplay); d.highlight != "all" || d.entry.name != "(no name)" {
+		t.Fatalf("new device not highlighted: %+v", d)
 	}
-	displayed := map[string]entryDisplay{"AA": {}, "BB": {}}
-	order := []string{"AA", "BB"}
 
-	pruneStaleDevices(results, displayed, &order)
+	v.apply(ev(peekbt.EventAdv, "AA", "", -50))
+	if d := v.displayed["AA"].Value.(*entryDisplay); d.highlight != "" || d.entry.rssi != -50 || !d.colorTTL.Equal(now.Add(time.Second)) {
+		t.Fatalf("update not applied: %+v", d)
+	}
 
-	if len(order) != 1 || order[0] != "AA" {
-		t.Fatalf("prune failed, got %v", order)
+	v.apply(ev(peekbt.EventLost, "AA", "", -50))
+	if v.order.Len() != 1 || v.order.Front().Value.(*entryDisplay).entry.addr != "BB" || len(v.displayed) != 1 {
+		t.Fatalf("lost device not removed: %d rows", v.order.Len())
 	}
 }
 
@@ -50,23 +56,7 @@ func TestRunScanCommand_MutualEx(t *testing.T) {
 	}
 }
 
-/* ---------- 3. ヘッダ描画 ---------- */
-func TestDrawHeader(t *testing.T) {
-	r, w, _ := os.Pipe()
-	oldStd := os.Stdout
-	os.Stdout = w
-
-	drawHeader()
-
-	w.Close()
-	os.Stdout = oldStd
-	out, _ := io.ReadAll(r)
-
-	if !bytes.Contains(out, []byte("ADDR")) {
-		t.Fatalf("header output missing ADDR column")
-	}
-}
-
+/* ---------- 3. コンテキスト ---------- */
 func TestMakeContext(t *testing.T) {
 	ctx, cancel := makeContext(0)
 	defer cancel()
@@ -80,3 +70,34 @@ func TestMakeContext(t *testing.T) {
 		t.Fatalf("deadline 1s がセットされていない")
 	}
 }
+
+/* ---------- 4. 受信キュー ---------- */
+func TestQueueOptions(t *testing.T) {
+	var cfg peekbt.Config
+	if err := (queueOptions{Size: 16, Policy: "coalesce"}).apply(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QueueSize != 16 || cfg.DropPolicy != peekbt.Coalesce {
+		t.Errorf("config %+v", cfg)
+	}
+	for _, o := range []queueOptions{{Size: 16, Policy: "block"}, {Size: -1, Policy: "drop-oldest"}} {
+		if err := o.apply(&peekbt.Config{}); err == nil {
+			t.Errorf("%+v: want error", o)
+		}
+	}
+	// 最大速度の再生ではキューを使わない（溢れて捨てないように）
+	cfg = peekbt.Config{Scanner: &replayScanner{speed: 0}}
+	if err := (queueOptions{Size: 16, Policy: "drop-oldest"}).apply(&cfg); err != nil || cfg.QueueSize != 0 {
+		t.Errorf("replay at max speed: queue %d, %v", cfg.QueueSize, err)
+	}
+}
+
+func TestFormatQueueStats(t *testing.T) {
+	got := formatQueueStats(peekbt.QueueStats{Len: 3, Cap: 4096, Dropped: 12, Coalesced: 5})
+	if want := "queue: 3/4096  dropped: 12  coalesced: 5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := formatQueueStats(peekbt.QueueStats{}); got != "queue: off" {
+		t.Errorf("got %q", got)
+	}
+}
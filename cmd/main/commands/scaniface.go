@@ -2,15 +2,16 @@ package commands
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+	"github.com/spf13/cobra"
 )
 
-type Scanner interface {
-	// 第 4 引数は ble.AdvFilter 固定
-	Scan(ctx context.Context, allowDup bool,
-		h ble.AdvHandler, f ble.AdvFilter) error
-}
+// Scanner はアドバタイズの供給元（peekbt.Scanner と同じ）
+type Scanner = peekbt.Scanner
 
 type bleScanner struct{}
 
@@ -20,3 +21,55 @@ func (bleScanner) Scan(ctx context.Context, allowDup bool,
 }
 
 var DefaultScanner Scanner = bleScanner{}
+
+// clockScanner は自身の時刻を持つ Scanner（再生ではキャプチャ上の時刻）
+type clockScanner interface {
+	Now() time.Time
+}
+
+// scanClock は s の受信時刻の取得元を返します（実機では time.Now）
+func scanClock(s Scanner) func() time.Time {
+	if c, ok := s.(clockScanner); ok {
+		return c.Now
+	}
+	return time.Now
+}
+
+// maxSpeedReplay は s が最大速度の再生かを返します。
+// 出力を待たせても取りこぼしが起きないため、キューやイベントバスで捨てずに待ちます
+func maxSpeedReplay(s Scanner) bool {
+	r, ok := s.(*replayScanner)
+	return ok && r.speed <= 0
+}
+
+// defaultQueueSize は受信キューの既定の大きさ（Pi 3 で数秒分のアドバタイズ）
+const defaultQueueSize = 4096
+
+// queueOptions は受信ハンドラと出力の間のキューのフラグ
+type queueOptions struct {
+	Size   int
+	Policy string
+}
+
+// addQueueFlags はキューのフラグを c に登録します
+func addQueueFlags(c *cobra.Command, o *queueOptions) {
+	c.Flags().IntVar(&o.Size, "queue-size", defaultQueueSize, "Advertisements buffered between the adapter and the outputs (0 = no queue)")
+	c.Flags().StringVar(&o.Policy, "drop-policy", peekbt.DropOldest.String(), "When the queue is full: drop-oldest, or coalesce (keep only the latest per address)")
+}
+
+// apply はキューの設定を cfg に反映します。
+// 最大速度の再生では溢れた分を捨てないよう、キューを使わずに受信ハンドラの中で処理します
+func (o queueOptions) apply(cfg *peekbt.Config) error {
+	policy, err := peekbt.ParseDropPolicy(o.Policy)
+	if err != nil {
+		return err
+	}
+	if o.Size < 0 {
+		return fmt.Errorf("invalid --queue-size %d", o.Size)
+	}
+	cfg.QueueSize, cfg.DropPolicy = o.Size, policy
+	if maxSpeedReplay(cfg.Scanner) {
+		cfg.QueueSize = 0
+	}
+	return nil
+}
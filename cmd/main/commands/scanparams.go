@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux/hci/cmd"
+)
+
+// defaultScanParams は go-ble の初期値と同じスキャンパラメータ
+var defaultScanParams = cmd.LESetScanParameters{
+	LEScanType:           0x01,   // 0x00: passive, 0x01: active
+	LEScanInterval:       0x0004, // N * 0.625msec
+	LEScanWindow:         0x0004, // N * 0.625msec
+	OwnAddressType:       0x00,   // 0x00: public, 0x01: random
+	ScanningFilterPolicy: 0x00,   // 0x00: accept all, 0x01: accept list only
+}
+
+// scanSlot は LE Scan Interval / Window の単位 (0.625ms)
+const scanSlot = 625 * time.Microsecond
+
+// scanSettings はユーザが指定したスキャン方式
+type scanSettings struct {
+	Passive  bool          // SCAN_REQ を送らない
+	Interval time.Duration // 2.5ms - 10.24s
+	Window   time.Duration // Interval 以下
+	Dedupe   bool          // コントローラで重複アドバタイズを除去
+}
+
+// defaultScanSettings は go-ble の初期値に合わせた設定
+var defaultScanSettings = scanSettings{
+	Interval: time.Duration(defaultScanParams.LEScanInterval) * scanSlot,
+	Window:   time.Duration(defaultScanParams.LEScanWindow) * scanSlot,
+}
+
+// hciParams は設定を検証して LE Set Scan Parameters に変換します
+func (s scanSettings) hciParams() (cmd.LESetScanParameters, error) {
+	p := defaultScanParams
+	interval, err := toScanSlots("interval", s.Interval)
+	if err != nil {
+		return p, err
+	}
+	window, err := toScanSlots("window", s.Window)
+	if err != nil {
+		return p, err
+	}
+	if window > interval {
+		return p, fmt.Errorf("scan window %v must not exceed interval %v", s.Window, s.Interval)
+	}
+	p.LEScanInterval = interval
+	p.LEScanWindow = window
+	if s.Passive {
+		p.LEScanType = 0x00
+	}
+	return p, nil
+}
+
+// toScanSlots は時間を 0.625ms 単位に変換し、0x0004-0x4000 の範囲を検証します
+func toScanSlots(name string, d time.Duration) (uint16, error) {
+	n := d / scanSlot
+	if n < 0x0004 || n > 0x4000 {
+		return 0, fmt.Errorf("scan %s %v out of range (2.5ms - 10.24s)", name, d)
+	}
+	return uint16(n), nil
+}
+
+// String はヘッダ表示用の要約を返します
+func (s scanSettings) String() string {
+	mode, dup := "active", "duplicates allowed"
+	if s.Passive {
+		mode = "passive"
+	}
+	if s.Dedupe {
+		dup = "deduped"
+	}
+	return fmt.Sprintf("%s, interval %v, window %v, %s", mode, s.Interval, s.Window, dup)
+}
+
+// configureScan はスキャン開始前にパラメータをアダプタへ送ります。
+// HCI へ直接送れないアダプタでは何もしません
+func configureScan(dev ble.Device, p cmd.LESetScanParameters) error {
+	s := senderFor(dev)
+	if s == nil {
+		return nil
+	}
+	if err := s.Send(&p, nil); err != nil {
+		return fmt.Errorf("failed to set scan parameters: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+/* ---------- 1. HCI パラメータ変換 ---------- */
+func TestScanSettings_HCIParams(t *testing.T) {
+	s := scanSettings{Passive: true, Interval: 100 * time.Millisecond, Window: 50 * time.Millisecond}
+	p, err := s.hciParams()
+	if err != nil {
+		t.Fatalf("hciParams: %v", err)
+	}
+	if p.LEScanType != 0x00 || p.LEScanInterval != 160 || p.LEScanWindow != 80 {
+		t.Fatalf("unexpected params: %+v", p)
+	}
+
+	p, err = defaultScanSettings.hciParams()
+	if err != nil || p != defaultScanParams {
+		t.Fatalf("default settings should map to go-ble defaults: %+v, %v", p, err)
+	}
+}
+
+/* ---------- 2. 範囲外 ---------- */
+func TestScanSettings_Invalid(t *testing.T) {
+	cases := []scanSettings{
+		{Interval: time.Millisecond, Window: time.Millisecond},
+		{Interval: 11 * time.Second, Window: 10 * time.Millisecond},
+		{Interval: 10 * time.Millisecond, Window: 20 * time.Millisecond},
+	}
+	for _, c := range cases {
+		if _, err := c.hciParams(); err == nil {
+			t.Errorf("%+v should be rejected", c)
+		}
+	}
+}
+
+/* ---------- 3. ヘッダ表示 ---------- */
+func TestScanSettings_String(t *testing.T) {
+	s := scanSettings{Passive: true, Dedupe: true, Interval: 10 * time.Millisecond, Window: 10 * time.Millisecond}
+	got := s.String()
+	for _, want := range []string{"passive", "interval 10ms", "window 10ms", "deduped"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%q does not contain %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// cell は画面の 1 桁分の文字。
+// 全角文字は 2 桁を占めるため、後ろに s が空の継続セルを置いてセルの位置と桁を揃えます
+type cell struct {
+	s     string // 文字（結合文字などの幅 0 の文字は直前の文字に含める）
+	style string // SGR のパラメータ（"" なら既定、"32" なら緑）
+}
+
+// frame は 1 回分の画面の内容（行毎のセル）
+type frame [][]cell
+
+// add は s を style で描いた行を末尾に加えます
+func (f *frame) add(style, s string) {
+	row := make([]cell, 0, len(s))
+	for _, r := range s {
+		switch w := runewidth.RuneWidth(r); {
+		case w == 0 && len(row) > 0:
+			row[len(row)-1].s += string(r)
+		case w == 2:
+			row = append(row, cell{s: string(r), style: style}, cell{style: style})
+		default:
+			row = append(row, cell{s: string(r), style: style})
+		}
+	}
+	*f = append(*f, row)
+}
+
+// String はスタイルを除いた画面の文字列（行末の改行付き）
+func (f frame) String() string {
+	var b bytes.Buffer
+	for _, row := range f {
+		for _, c := range row {
+			b.WriteString(c.s)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// renderer は前回のフレームを覚えておき、変わったセルだけをカーソル移動付きで w に書き出します。
+// 1 フレーム分の出力はまとめて 1 回の Write で書きます
+type renderer struct {
+	w     io.Writer
+	prev  frame
+	drawn bool   // 一度でも描いたか（初回は画面をクリアする）
+	style string // 端末の現在のスタイル
+	buf   bytes.Buffer
+}
+
+func newRenderer(w io.Writer) *renderer {
+	return &renderer{w: w}
+}
+
+// render は前回のフレームとの差分を書き出します
+func (r *renderer) render(next frame) error {
+	r.buf.Reset()
+	if !r.drawn {
+		r.buf.WriteString("\033[0m\033[2J")
+		r.prev, r.style, r.drawn = nil, "", true
+	}
+	for y := 0; y < max(len(next), len(r.prev)); y++ {
+		var old, cur []cell
+		if y < len(r.prev) {
+			old = r.prev[y]
+		}
+		if y < len(next) {
+			cur = next[y]
+		}
+		r.diffLine(y, old, cur)
+	}
+	r.setStyle("")
+
+	r.prev = make(frame, len(next))
+	for i, row := range next {
+		r.prev[i] = slices.Clone(row)
+	}
+	if r.buf.Len() == 0 {
+		return nil
+	}
+	if _, err := r.w.Write(r.buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to draw: %w", err)
+	}
+	return nil
+}
+
+// diffLine は y 行目の変わった区間毎に、その先頭へ移動して書き直します。
+// セルの位置がそのまま桁になるため、全角文字の後でもカーソルの位置はずれません。
+// 行が短くなった場合は残りを行末までクリアします
+func (r *renderer) diffLine(y int, old, cur []cell) {
+	for x := 0; x < len(cur); {
+		if x < len(old) && old[x] == cur[x] {
+			x++
+			continue
+		}
+		fmt.Fprintf(&r.buf, "\033[%d;%dH", y+1, x+1)
+		for ; x < len(cur) && (x >= len(old) || old[x] != cur[x]); x++ {
+			r.setStyle(cur[x].style)
+			r.buf.WriteString(cur[x].s) // 継続セルは直前の全角文字が埋めている
+		}
+	}
+	if len(old) > len(cur) {
+		r.setStyle("") // 既定の背景色でクリアする
+		fmt.Fprintf(&r.buf, "\033[%d;%dH\033[K", y+1, len(cur)+1)
+	}
+}
+
+func (r *renderer) setStyle(s string) {
+	if s == r.style {
+		return
+	}
+	if s == "" {
+		r.buf.WriteString("\033[0m")
+	} else {
+		fmt.Fprintf(&r.buf, "\033[0;%sm", s)
+	}
+	r.style = s
+}
+
+// finish はカーソルを最後のフレームの下に移します（終了時に呼ぶ）
+func (r *renderer) finish() error {
+	if !r.drawn {
+		return nil
+	}
+	if _, err := fmt.Fprintf(r.w, "\033[%d;1H", len(r.prev)+1); err != nil {
+		return fmt.Errorf("failed to draw: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package commands
+
+import (
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden frames in testdata")
+
+// vterm は renderer が使うエスケープシーケンス（CUP・EL・ED・SGR）だけを解釈する仮想端末
+type vterm struct {
+	rows    [][]cell
+	y, x    int
+	style   string
+	writes  int
+	written int
+}
+
+func (v *vterm) Write(p []byte) (int, error) {
+	v.writes++
+	v.written += len(p)
+	s := []rune(string(p))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\033' {
+			v.put(s[i])
+			continue
+		}
+		// ESC [ params final
+		j := i + 2
+		for j < len(s) && (s[j] == ';' || (s[j] >= '0' && s[j] <= '9')) {
+			j++
+		}
+		var params []int
+		for _, f := range strings.Split(string(s[i+2:j]), ";") {
+			n, _ := strconv.Atoi(f)
+			params = append(params, n)
+		}
+		switch s[j] {
+		case 'H':
+			v.y, v.x = params[0]-1, params[1]-1
+		case 'K':
+			if v.y < len(v.rows) && v.x < len(v.rows[v.y]) {
+				v.rows[v.y] = v.rows[v.y][:v.x]
+			}
+		case 'J':
+			v.rows = nil
+		case 'm':
+			v.style = ""
+			if len(params) > 1 {
+				v.style = strconv.Itoa(params[1])
+			}
+		}
+		i = j
+	}
+	return len(p), nil
+}
+
+// put は r を書き、文字幅だけカーソルを進めます。
+// 実際の端末と同じく、全角文字の片側だけを上書きするともう片側は空白になります
+func (v *vterm) put(r rune) {
+	w := runewidth.RuneWidth(r)
+	if w == 0 {
+		if row := v.row(); v.x > 0 && v.x <= len(row) {
+			row[v.x-1].s += string(r)
+		}
+		return
+	}
+	for i := 0; i < w; i++ {
+		v.set(v.x+i, cell{style: v.style})
+	}
+	v.rows[v.y][v.x].s = string(r)
+	v.x += w
+}
+
+// set は x 桁目のセルを c にします。全角文字の片側なら、もう片側を空白にします
+func (v *vterm) set(x int, c cell) {
+	row := v.row()
+	for len(row) <= x {
+		row = append(row, cell{s: " "})
+	}
+	if row[x].s == "" && x > 0 {
+		row[x-1].s = " " // 全角文字の右側
+	} else if x+1 < len(row) && row[x+1].s == "" {
+		row[x+1].s = " " // 全角文字の左側
+	}
+	row[x] = c
+	v.rows[v.y] = row
+}
+
+func (v *vterm) row() []cell {
+	for len(v.rows) <= v.y {
+		v.rows = append(v.rows, nil)
+	}
+	return v.rows[v.y]
+}
+
+func (v *vterm) screen() frame {
+	return frame(v.rows)
+}
+
+// assertGolden は画面の内容を testdata/<name>.golden と比べます（-update で書き換え）
+func assertGolden(t *testing.T, name string, got frame) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("%s: frame mismatch\n--- got\n%s--- want\n%s", name, got, want)
+	}
+}
+
+/* ---------- 1. 差分描画 ---------- */
+func TestRenderer_Diff(t *testing.T) {
+	var term vterm
+	r := newRenderer(&term)
+
+	var f1 frame
+	f1.add("", "hello world")
+	f1.add("32", "green line")
+	f1.add("", "third")
+	if err := r.render(f1); err != nil {
+		t.Fatal(err)
+	}
+	if term.screen().String() != f1.String() || term.rows[1][0].style != "32" || term.rows[0][0].style != "" {
+		t.Fatalf("first frame:\n%s", term.screen())
+	}
+
+	// 同じフレームは何も書かない
+	writes := term.writes
+	r.render(f1)
+	if term.writes != writes {
+		t.Error("unchanged frame was written")
+	}
+
+	// 変わったセルだけ書き、短くなった行と消えた行はクリアする
+	var f2 frame
+	f2.add("", "hello there")
+	f2.add("", "green")
+	written := term.written
+	if err := r.render(f2); err != nil {
+		t.Fatal(err)
+	}
+	if got := term.screen().String(); got != "hello there\ngreen\n\n" {
+		t.Fatalf("second frame:\n%q", got)
+	}
+	if term.rows[1][0].style != "" {
+		t.Error("style of a changed cell not updated")
+	}
+	if n := term.written - written; n > 60 {
+		t.Errorf("diff wrote %d bytes", n)
+	}
+}
+
+/* ---------- 2. テーブルのフレーム ---------- */
+func TestScanView_Golden(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := peekbt.QueueStats{Cap: 4096}
+	v := newScanView()
+	v.stats = func() peekbt.QueueStats { return st }
+	tb := peekbt.NewDeviceTable(time.Minute)
+	apply := func(a stubAdv, at time.Time) {
+		e, typ := tb.Update(a, at)
+		v.apply(peekbt.Event{Type: peekbt.EventAdv, Time: at, Entry: e, Adv: a})
+		if typ != "" {
+			v.apply(peekbt.Event{Type: typ, Time: at, Entry: e, Adv: a})
+		}
+	}
+	tag := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x00, 0x00, 0x01}
+	apply(stubAdv{addr: tag, name: "Tag", rssi: -70}, now)
+	apply(stubAdv{addr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x00, 0x00, 0x02}, rssi: -82}, now)
+	apply(stubAdv{addr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x00, 0x00, 0x03}, name: "Sensor", rssi: -55}, now.Add(500*time.Millisecond))
+
+	var term vterm
+	r := newRenderer(&term)
+	f := v.frame(defaultScanSettings, now.Add(800*time.Millisecond))
+	if err := r.render(f); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "scan_frame_1", term.screen())
+	if term.rows[3][0].style != "32" {
+		t.Error("new device not highlighted")
+	}
+
+	// RSSI の更新・消失・取りこぼしの反映（ハイライトは 1 秒で消える）
+	apply(stubAdv{addr: tag, name: "Tag", rssi: -64}, now.Add(time.Second))
+	v.apply(peekbt.Event{Type: peekbt.EventLost, Time: now.Add(2 * time.Second),
+		Entry: peekbt.Entry{Info: peekbt.DeviceInfo{Address: "aa:bb:cc:00:00:02"}}})
+	st = peekbt.QueueStats{Len: 7, Cap: 4096, Dropped: 12}
+	written := term.written
+	if err := r.render(v.frame(defaultScanSettings, now.Add(1200*time.Millisecond))); err != nil {
+		t.Fatal(err)
+	}
+	assertGolden(t, "scan_frame_2", term.screen())
+	if term.rows[3][0].style != "" || term.rows[4][0].style != "32" {
+		t.Error("highlight not updated")
+	}
+	if diff := term.written - written; diff >= written {
+		t.Errorf("update wrote %d bytes, full frame was %d", diff, written)
+	}
+}
+
+/* ---------- 3. 全角文字の名前 ---------- */
+func TestScanView_GoldenWide(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	v := newScanView()
+	v.hint = cancelHint(0)
+	tb := peekbt.NewDeviceTable(time.Minute)
+	apply := func(a stubAdv, at time.Time) {
+		e, typ := tb.Update(a, at)
+		v.apply(peekbt.Event{Type: peekbt.EventAdv, Time: at, Entry: e, Adv: a})
+		if typ != "" {
+			v.apply(peekbt.Event{Type: typ, Time: at, Entry: e, Adv: a})
+		}
+	}
+	room := net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x00, 0x00, 0x01}
+	apply(stubAdv{addr: room, name: "リビング温度計", rssi: -70}, now)
+	apply(stubAdv{addr: net.HardwareAddr{0xaa, 0xbb, 0xcc, 0x00, 0x00, 0x02}, name: "Tag", rssi: -82}, now)
+
+	var term vterm
+	r := newRenderer(&term)
+	if err := r.render(v.frame(defaultScanSettings, now.Add(2*time.Second))); err != nil {
+		t.Fatal(err)
+	}
+
+	// 全角文字より後ろの桁だけ・途中の 1 文字だけが変わっても、差分の書き込み位置がずれない
+	for i, name := range []string{"リビング温度計2", "寝室", "リビング湿度計2"} {
+		at := now.Add(time.Duration(3+i) * time.Second)
+		apply(stubAdv{addr: room, name: name, rssi: -70}, at)
+		f := v.frame(defaultScanSettings, at)
+		if err := r.render(f); err != nil {
+			t.Fatal(err)
+		}
+		if term.screen().String() != f.String() {
+			t.Fatalf("%s: screen does not match the frame\n--- screen\n%s--- frame\n%s", name, term.screen(), f)
+		}
+	}
+	assertGolden(t, "scan_frame_3", term.screen())
+}
@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveOut    sinkOptions
+	serveQueue  queueOptions
+	serveFilter string
+	serveAllow  string
+	serveDeny   string
+)
+
+// serveRetryDelay はスキャンが失敗した際に再開するまでの待ち時間
+var serveRetryDelay = 5 * time.Second
+
+var serveCommand = &cobra.Command{
+	Use:   "serve",
+	Short: "Run headless and export scan results (REST API, Prometheus metrics, MQTT, webhooks).",
+	Args:  cobra.NoArgs,
+	RunE:  runServeCommand,
+}
+
+func init() {
+	addSinkFlags(serveCommand, &serveOut)
+	addQueueFlags(serveCommand, &serveQueue)
+	f := serveCommand.Flags()
+	f.StringVar(&serveFilter, "filter", "", `Only export devices matching the filter expression`)
+	f.StringVar(&serveAllow, "allow", "", "Only export devices listed in the file (addresses, prefixes, company IDs or names)")
+	f.StringVar(&serveDeny, "deny", "", "Do not export devices listed in the file (addresses, prefixes, company IDs or names)")
+	rootCommand.AddCommand(serveCommand)
+}
+
+// eventSink は serve / scan がセッションのイベントを配る出力先。
+// デバイスの出現・変化・消失はセッションのデバイス表（--device-ttl）で判定したものを受け取ります
+type eventSink interface {
+	Event(ev peekbt.Event)
+}
+
+// scanErrorSink はスキャンの失敗も受け取りたい sink
+type scanErrorSink interface {
+	ScanError(err error)
+}
+
+func runServeCommand(cmd *cobra.Command, args []string) error {
+	if !serveOut.enabled() {
+		return errors.New("nothing to serve (specify --http, --metrics, --mqtt or --webhook)")
+	}
+	if err := serveOut.validate(); err != nil {
+		return err
+	}
+	flt, err := parseFilter(serveFilter)
+	if err != nil {
+		return err
+	}
+	allow, deny, err := loadAccessLists(serveAllow, serveDeny)
+	if err != nil {
+		return err
+	}
+	cfg := peekbt.Config{
+		Scanner:    DefaultScanner,
+		Duplicates: true,
+		Filter:     buildAdvFilter(accessFilter(allow, deny), exprFilter(flt)),
+		TTL:        serveOut.TTL,
+		Now:        scanClock(DefaultScanner),
+		MaxDevices: serveOut.Max,
+	}
+	if err := serveQueue.apply(&cfg); err != nil {
+		return err
+	}
+
+	if _, err := InitDefaultAdapter(); err != nil {
+		return err
+	}
+
+	ctx, stop := withInterrupt(context.Background())
+	defer stop()
+	reloadOnSIGHUP(ctx, func(err error) { fmt.Fprintln(os.Stderr, err) }, allow, deny)
+
+	sess := peekbt.NewSession(cfg)
+	bus := newEventBus()
+	bus.lossless = maxSpeedReplay(DefaultScanner)
+	sinks, err := serveOut.open(ctx, bus, sess)
+	if err != nil {
+		return err
+	}
+	defer sinks.Close()
+	bus.start()
+	defer bus.close()
+
+	return runScanLoop(ctx, func(ctx context.Context) error {
+		return sess.Run(ctx, bus.publish)
+	}, func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		sinks.scanError(err)
+	})
+}
+
+// openMQTTSink はブローカーに接続して mqttSink を返します
+func openMQTTSink(opts mqttOptions) (*mqttSink, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "peekbt"
+	}
+	if opts.ClientID == "" {
+		opts.ClientID = "peekbt-" + host
+	}
+	if opts.Password == "" {
+		opts.Password = os.Getenv(mqttPasswordEnv)
+	}
+	if _, err := parseTopic(opts.Topic); err != nil {
+		return nil, err
+	}
+	pub, err := dialMQTT(opts, host)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMQTTSink(pub, opts, host)
+	if err != nil {
+		pub.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// runScanLoop は ctx がキャンセルされるまで scan を繰り返します。
+// スキャンが失敗した場合は onErr に通知し、serveRetryDelay 後に再開します。
+// 再生が終端に達した場合は ctx のキャンセルを待ちます
+func runScanLoop(ctx context.Context, scan func(context.Context) error, onErr func(error)) error {
+	for {
+		err := scan(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			<-ctx.Done()
+			return nil
+		}
+		onErr(fmt.Errorf("scan failed: %w", err))
+
+		t := time.NewTimer(serveRetryDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// startHTTP は addr で待ち受けを開始し、ctx のキャンセルで停止します。
+// 実際に待ち受けているアドレスを返します（":0" 指定時の確認用）
+func startHTTP(ctx context.Context, addr string, h http.Handler) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(sctx)
+	}()
+	return ln.Addr().String(), nil
+}
@@ -0,0 +1,161 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// sinkFeeder はセッションと同じ順に、デバイス表の変化を出力先に渡します
+type sinkFeeder struct {
+	table *peekbt.DeviceTable
+	sink  eventSink
+	types map[peekbt.EventType]bool // 空なら全種別
+}
+
+func newSinkFeeder(table *peekbt.DeviceTable, sink eventSink, types ...peekbt.EventType) *sinkFeeder {
+	f := &sinkFeeder{table: table, sink: sink, types: make(map[peekbt.EventType]bool)}
+	for _, t := range types {
+		f.types[t] = true
+	}
+	return f
+}
+
+// adv は a を now に受信したものとして表を更新し、イベントを渡します
+func (f *sinkFeeder) adv(a ble.Advertisement, now time.Time) {
+	e, typ := f.table.Update(a, now)
+	f.send(peekbt.Event{Type: peekbt.EventAdv, Time: now, Entry: e, Adv: a})
+	if typ != "" {
+		f.send(peekbt.Event{Type: typ, Time: now, Entry: e, Adv: a})
+	}
+	f.expire(now)
+}
+
+// expire は TTL を過ぎたデバイスと上限を超えた分を表から消し、lost を渡します
+func (f *sinkFeeder) expire(now time.Time) {
+	for _, e := range f.table.Expire(now) {
+		f.send(peekbt.Event{Type: peekbt.EventLost, Time: now, Entry: e})
+	}
+}
+
+func (f *sinkFeeder) send(ev peekbt.Event) {
+	if len(f.types) == 0 || f.types[ev.Type] {
+		f.sink.Event(ev)
+	}
+}
+
+/* ---------- 1. 失敗時の再開 ---------- */
+func TestRunScanLoop_Retry(t *testing.T) {
+	origScanner, origDelay := DefaultScanner, serveRetryDelay
+	defer func() { DefaultScanner, serveRetryDelay = origScanner, origDelay }()
+	serveRetryDelay = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls, advs := 0, 0
+	DefaultScanner = mockScanner{fn: func(ctx context.Context, _ bool, h ble.AdvHandler, _ ble.AdvFilter) error {
+		calls++
+		if calls < 3 {
+			return errors.New("hci: busy")
+		}
+		h(stubAdv{addr: ble.NewAddr("aa:bb:cc:dd:ee:ff")})
+		cancel()
+		return ctx.Err()
+	}}
+
+	var errs []error
+	err := runScanLoop(ctx, func(ctx context.Context) error {
+		return DefaultScanner.Scan(ctx, true, func(ble.Advertisement) { advs++ }, nil)
+	}, func(err error) { errs = append(errs, err) })
+	if err != nil || calls != 3 || advs != 1 || len(errs) != 2 {
+		t.Fatalf("err=%v calls=%d advs=%d errs=%v", err, calls, advs, errs)
+	}
+}
+
+/* ---------- 2. 再生終端では止まらない ---------- */
+func TestRunScanLoop_ReplayEnd(t *testing.T) {
+	orig := DefaultScanner
+	defer func() { DefaultScanner = orig }()
+	DefaultScanner = mockScanner{fn: func(context.Context, bool, ble.AdvHandler, ble.AdvFilter) error { return nil }}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	scan := func(ctx context.Context) error {
+		return DefaultScanner.Scan(ctx, true, func(ble.Advertisement) {}, nil)
+	}
+	if err := runScanLoop(ctx, scan, func(error) {}); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 40*time.Millisecond {
+		t.Fatalf("runScanLoop returned before the context was done")
+	}
+}
+
+/* ---------- 3. 出力先の指定なし ---------- */
+func TestServeCommand_NothingToServe(t *testing.T) {
+	rootCommand.SetArgs([]string{"serve"})
+	if err := rootCommand.Execute(); err == nil {
+		t.Fatalf("expected error without --metrics")
+	}
+}
+
+/* ---------- 4. --max-devices は全ての出力に効く ---------- */
+
+// busSink はイベントを bus に流す eventSink
+type busSink struct{ bus *eventBus }
+
+func (b busSink) Event(ev peekbt.Event) { b.bus.publish(ev) }
+
+func TestSinks_MaxDevices(t *testing.T) {
+	table := peekbt.NewDeviceTable(time.Minute)
+	table.SetMaxDevices(2)
+	bus := newEventBus()
+	bus.lossless = true
+	set := &sinkSet{}
+
+	api := newAPIServer(table, time.Second)
+	set.add(bus, "api", api, peekbt.EventNew, peekbt.EventUpdate, peekbt.EventLost)
+	m, pub := newTestMQTT(t, mqttOptions{StatusTopic: defaultMQTTStatusTopic, Interval: time.Minute})
+	if err := m.enableDiscovery("ha", nil); err != nil {
+		t.Fatal(err)
+	}
+	set.add(bus, "mqtt", m, peekbt.EventNew, peekbt.EventUpdate, peekbt.EventLost)
+	w, _ := newWebhookSink(testHookOptions("http://localhost/hook"))
+	w.opts.QueueSize, w.queue = 1024, make(chan webhookPayload, 1024)
+	set.add(bus, "webhook", w, w.eventTypes()...)
+	bus.start()
+
+	f := newSinkFeeder(table, busSink{bus})
+	now := time.Now()
+	for i := range 10 {
+		at := now.Add(time.Duration(i) * time.Second)
+		a := sensorAdv{stubAdv: stubAdv{addr: net.HardwareAddr{0xa4, 0xc1, 0x38, 0, 0, byte(i)}},
+			mfg: []byte{0x88, 0xEC, 0x00, 0x03, 0xA5, 0x1F, 0x64, 0x00}}
+		f.adv(a, at)
+		a.stubAdv.name = "renamed" // 間隔内の update は保留される
+		f.adv(a, at)
+	}
+	bus.close()
+
+	if table.Len() != 2 || len(api.hub.sent) != 2 || len(m.sent) != 2 || len(m.pending) != 2 ||
+		len(m.ha.announced) != 2 || len(w.states) != 2 {
+		t.Errorf("table %d, api %d, mqtt %d/%d, ha %d, webhook %d",
+			table.Len(), len(api.hub.sent), len(m.sent), len(m.pending), len(m.ha.announced), len(w.states))
+	}
+	// 追い出したデバイスは lost として送られている
+	lost := 0
+	for _, msg := range pub.msgs {
+		if msg.payload["event"] == eventLost {
+			lost++
+		}
+	}
+	if lost != 8 {
+		t.Errorf("%d lost events over MQTT, want 8", lost)
+	}
+}
@@ -0,0 +1,253 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/gorilla/websocket"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+const (
+	// streamBuffer は購読者毎に溜めておけるイベント数（超えた分は捨てる）
+	streamBuffer = 256
+	// streamPing は SSE のコメント / WebSocket の ping を送る間隔（プロキシのアイドル切断対策）
+	streamPing = 30 * time.Second
+)
+
+// streamEvent は /events と /ws で送るイベント。デバイス表の 1 行に event と timestamp を加えたもの
+type streamEvent struct {
+	Event     string `json:"event"`
+	Timestamp string `json:"timestamp"`
+	peekbt.DeviceRecord
+}
+
+// hubItem はフィルタ式の評価用に最後のアドバタイズを添えたイベント
+type hubItem struct {
+	ev  streamEvent
+	adv ble.Advertisement
+}
+
+// streamSub は 1 接続分の購読
+type streamSub struct {
+	filter  *filterExpr
+	ch      chan streamEvent
+	dropped atomic.Int64
+}
+
+// eventHub はデバイス表の変化を購読者に配ります。
+// 混雑した場所でブラウザが溢れないよう、同じデバイスの update は interval 毎に
+// 最新の 1 件へまとめます（new / lost はまとめずに即座に送る）
+type eventHub struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	subs    map[*streamSub]struct{}
+	sent    map[string]time.Time // デバイス毎に最後に送った時刻
+	pending map[string]hubItem   // interval 内に届いた update のうち最新のもの
+}
+
+func newEventHub(interval time.Duration) *eventHub {
+	return &eventHub{
+		interval: interval,
+		subs:     make(map[*streamSub]struct{}),
+		sent:     make(map[string]time.Time),
+		pending:  make(map[string]hubItem),
+	}
+}
+
+// publish はイベントを配ります。interval 内の update は保留し flush で送ります
+func (h *eventHub) publish(event string, e peekbt.Entry, now time.Time) {
+	it := hubItem{
+		ev:  streamEvent{Event: event, Timestamp: now.Format(time.RFC3339Nano), DeviceRecord: e.Record()},
+		adv: e.Adv,
+	}
+	addr := e.Info.Address
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch event {
+	case eventUpdate:
+		if now.Sub(h.sent[addr]) < h.interval {
+			h.pending[addr] = it
+			return
+		}
+		h.sent[addr] = now
+	case eventLost:
+		delete(h.sent, addr)
+	default:
+		h.sent[addr] = now
+	}
+	delete(h.pending, addr)
+	h.broadcast(it)
+}
+
+// flush は interval を過ぎた保留中の update を送ります
+func (h *eventHub) flush(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for addr, it := range h.pending {
+		if now.Sub(h.sent[addr]) >= h.interval {
+			h.sent[addr] = now
+			delete(h.pending, addr)
+			h.broadcast(it)
+		}
+	}
+}
+
+// broadcast はフィルタに合う購読者へ送ります。受け取りが遅い購読者の分は捨てます（h.mu を保持して呼ぶ）
+func (h *eventHub) broadcast(it hubItem) {
+	for s := range h.subs {
+		if s.filter != nil && !s.filter.Match(it.adv) {
+			continue
+		}
+		select {
+		case s.ch <- it.ev:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+func (h *eventHub) subscribe(f *filterExpr) *streamSub {
+	s := &streamSub{filter: f, ch: make(chan streamEvent, streamBuffer)}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// unsubscribe は購読を終えます。受け取りが遅く捨てたイベントがあればログに残します
+func (h *eventHub) unsubscribe(s *streamSub) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+	if n := s.dropped.Load(); n > 0 {
+		fmt.Fprintf(os.Stderr, "stream client too slow: dropped %d events\n", n)
+	}
+}
+
+// streamSubscribe はクエリの filter を解釈して購読を始めます
+func (s *apiServer) streamSubscribe(w http.ResponseWriter, r *http.Request) (*streamSub, bool) {
+	f, err := parseFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	return s.hub.subscribe(f), true
+}
+
+// handleEvents は GET /events。デバイスのイベントを Server-Sent Events で送ります
+//
+//	event: update
+//	data: {"event":"update","timestamp":"…","address":"…",…}
+func (s *apiServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	sub, ok := s.streamSubscribe(w, r)
+	if !ok {
+		return
+	}
+	defer s.hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": peekbt\n\n")
+	flusher.Flush()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case ev := <-sub.ch:
+			b, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Event, b); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// checkOrigin は WebSocket の接続元を確認します。任意のページからデバイスの一覧を読まれないよう、
+// Origin を送らないクライアントと同一オリジンのほかは --allow-origin で指定したもの（* はすべて）だけを許可します
+func (s *apiServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range s.allowOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// handleWS は GET /ws。デバイスのイベントを 1 メッセージ 1 件の JSON で WebSocket に送ります
+func (s *apiServer) handleWS(w http.ResponseWriter, r *http.Request) {
+	sub, ok := s.streamSubscribe(w, r)
+	if !ok {
+		return
+	}
+	defer s.hub.unsubscribe(sub)
+
+	up := websocket.Upgrader{CheckOrigin: s.checkOrigin}
+	conn, err := up.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade がエラー応答を書く
+	}
+	defer conn.Close()
+
+	// クライアントからのメッセージは使わないが、close や切断を検知するために読み続ける
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		case ev := <-sub.ch:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			err = conn.WriteJSON(ev)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// recvEvent はタイムアウト付きで 1 件受け取ります
+func recvEvent(t *testing.T, sub *streamSub) (streamEvent, bool) {
+	t.Helper()
+	select {
+	case ev := <-sub.ch:
+		return ev, true
+	case <-time.After(50 * time.Millisecond):
+		return streamEvent{}, false
+	}
+}
+
+/* ---------- 1. update のまとめ ---------- */
+func TestEventHub_Coalesce(t *testing.T) {
+	tb := peekbt.NewDeviceTable(time.Minute)
+	hub := newEventHub(time.Second)
+	sub := hub.subscribe(nil)
+	addr := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	now := time.Now()
+
+	adv := func(name string, rssi int, at time.Duration) {
+		e, event := tb.Update(stubAdv{addr: addr, name: name, rssi: rssi}, now.Add(at))
+		if event != "" {
+			hub.publish(string(event), e, now.Add(at))
+		}
+	}
+	adv("", -70, 0)
+	if ev, ok := recvEvent(t, sub); !ok || ev.Event != eventNew {
+		t.Fatalf("want new, got %+v", ev)
+	}
+	// 1 秒以内の update は最新の 1 件に保留される
+	adv("A", -60, 100*time.Millisecond)
+	adv("B", -50, 200*time.Millisecond)
+	if ev, ok := recvEvent(t, sub); ok {
+		t.Fatalf("update within interval delivered: %+v", ev)
+	}
+	hub.flush(now.Add(500 * time.Millisecond))
+	if ev, ok := recvEvent(t, sub); ok {
+		t.Fatalf("flushed too early: %+v", ev)
+	}
+	hub.flush(now.Add(time.Second))
+	ev, ok := recvEvent(t, sub)
+	if !ok || ev.Event != eventUpdate || ev.Name != "B" || ev.RSSI != -50 {
+		t.Fatalf("want coalesced update of B with rssi -50, got %+v", ev)
+	}
+	if _, ok := recvEvent(t, sub); ok {
+		t.Fatal("more than one update per interval")
+	}
+
+	// lost は保留中の update を捨てて即座に送る
+	adv("C", -40, 1500*time.Millisecond)
+	for _, e := range tb.Expire(now.Add(2 * time.Minute)) {
+		hub.publish(eventLost, e, now.Add(2*time.Minute))
+	}
+	if ev, ok := recvEvent(t, sub); !ok || ev.Event != eventLost || ev.RSSI != -40 {
+		t.Fatalf("want lost with last state, got %+v", ev)
+	}
+	hub.flush(now.Add(3 * time.Minute))
+	if ev, ok := recvEvent(t, sub); ok {
+		t.Fatalf("stale update after lost: %+v", ev)
+	}
+}
+
+/* ---------- 2. フィルタと取りこぼし ---------- */
+func TestEventHub_FilterAndDrop(t *testing.T) {
+	hub := newEventHub(0)
+	f, err := parseFilter(`name =~ "^Tile"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tiles := hub.subscribe(f)
+	all := hub.subscribe(nil)
+	tb := peekbt.NewDeviceTable(time.Minute)
+	now := time.Now()
+	for i, name := range []string{"Tile", "Other"} {
+		e, event := tb.Update(stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 0, byte(i)}, name: name}, now)
+		hub.publish(string(event), e, now)
+	}
+	if len(tiles.ch) != 1 || len(all.ch) != 2 {
+		t.Fatalf("filtered %d / all %d events, want 1 / 2", len(tiles.ch), len(all.ch))
+	}
+
+	// 受け取らない購読者の分は捨て、publish は止まらない
+	for i := 0; i < streamBuffer+10; i++ {
+		e, _ := tb.Update(stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 1, 0}, rssi: -i - 1}, now)
+		hub.publish(eventUpdate, e, now)
+	}
+	if n := all.dropped.Load(); n != 12 {
+		t.Errorf("dropped %d, want 12", n)
+	}
+	hub.unsubscribe(all)
+	hub.unsubscribe(tiles)
+	if len(hub.subs) != 0 {
+		t.Errorf("%d subscribers left", len(hub.subs))
+	}
+}
+
+/* ---------- 3. SSE ---------- */
+func TestAPI_Events(t *testing.T) {
+	_, f, srv := newTestAPIFeeder(t)
+	resp, err := srv.Client().Get(srv.URL + `/events?filter=rssi+>+-50`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	if line, _ := r.ReadString('\n'); !strings.HasPrefix(line, ":") {
+		t.Fatalf("want initial comment, got %q", line)
+	}
+
+	now := time.Now()
+	f.adv(stubAdv{addr: net.HardwareAddr{1, 1, 1, 1, 1, 1}, rssi: -90}, now) // フィルタで除外
+	f.adv(stubAdv{addr: net.HardwareAddr{2, 2, 2, 2, 2, 2}, name: "near", rssi: -30}, now)
+
+	var lines []string
+	for len(lines) < 2 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSuffix(line, "\n"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "event: new" || !strings.HasPrefix(lines[1], "data: ") {
+		t.Fatalf("unexpected frame %q", lines)
+	}
+	var ev streamEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Event != eventNew || ev.Address != "02:02:02:02:02:02" || ev.Name != "near" || ev.Stats.Count != 1 {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+func TestAPI_EventsInvalidFilter(t *testing.T) {
+	_, srv := newTestAPI(t)
+	for _, path := range []string{"/events?filter=rssi+>", "/ws?filter=rssi+>"} {
+		var e map[string]string
+		if code := getJSON(t, srv, path, &e); code != http.StatusBadRequest || e["error"] == "" {
+			t.Errorf("GET %s: %d %v", path, code, e)
+		}
+	}
+}
+
+/* ---------- 4. WebSocket ---------- */
+func TestAPI_WebSocket(t *testing.T) {
+	s, f, srv := newTestAPIFeeder(t)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 購読の開始はハンドラ側で非同期なので、届くまで別のデバイスを送り続ける
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+			f.adv(stubAdv{addr: net.HardwareAddr{3, 3, 3, 3, byte(i >> 8), byte(i)}, rssi: -40}, s.now())
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var ev streamEvent
+	if err := conn.ReadJSON(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ev.Address, "03:03:03:03:") || ev.Event != eventNew {
+		t.Errorf("unexpected event %+v", ev)
+	}
+}
+
+/* ---------- 5. WebSocket のオリジン ---------- */
+func TestAPI_WebSocketOrigin(t *testing.T) {
+	s, srv := newTestAPI(t)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	dial := func(origin string) error {
+		h := http.Header{}
+		if origin != "" {
+			h.Set("Origin", origin)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(url, h)
+		if err == nil {
+			conn.Close()
+		}
+		return err
+	}
+
+	// Origin なし（ブラウザ以外）と同一オリジンは許可、別オリジンは拒否
+	if err := dial(""); err != nil {
+		t.Errorf("no origin: %v", err)
+	}
+	if err := dial(srv.URL); err != nil {
+		t.Errorf("same origin: %v", err)
+	}
+	if err := dial("https://evil.example"); err == nil {
+		t.Errorf("cross origin accepted")
+	}
+
+	s.allowOrigins = []string{"https://dash.example/"}
+	if err := dial("https://dash.example"); err != nil {
+		t.Errorf("allowed origin: %v", err)
+	}
+	if err := dial("https://evil.example"); err == nil {
+		t.Errorf("cross origin accepted with --allow-origin")
+	}
+}
@@ -0,0 +1,274 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+// webhook で選択できるイベント
+const (
+	hookNew            = eventNew
+	hookLost           = eventLost
+	hookRSSIThreshold  = "rssi-threshold"
+	hookPayloadChanged = "payload-changed"
+
+	// webhookSecretEnv は --webhook-secret を ps に出さずに渡すための環境変数
+	webhookSecretEnv = "PEEKBT_WEBHOOK_SECRET"
+	// webhookSignatureHeader は HMAC-SHA256 署名を載せるヘッダ
+	webhookSignatureHeader = "X-Peekbt-Signature-256"
+)
+
+var webhookEvents = []string{hookNew, hookLost, hookRSSIThreshold, hookPayloadChanged}
+
+// webhookBackoff は再送の初回待ち時間（以降 2 倍ずつ、webhookMaxBackoff まで）
+var (
+	webhookBackoff    = time.Second
+	webhookMaxBackoff = 30 * time.Second
+)
+
+// webhookOptions は --webhook-* の組
+type webhookOptions struct {
+	URL       string
+	Events    []string
+	RSSI      int // rssi-threshold の閾値 (dBm)
+	Secret    string
+	QueueSize int
+	Retries   int
+	Timeout   time.Duration
+}
+
+// validate はフラグを検証します
+func (o webhookOptions) validate() error {
+	u, err := url.Parse(o.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid --webhook URL %q", o.URL)
+	}
+	for _, e := range o.Events {
+		valid := false
+		for _, w := range webhookEvents {
+			valid = valid || e == w
+		}
+		if !valid {
+			return fmt.Errorf("invalid webhook event %q (want %s)", e, strings.Join(webhookEvents, ", "))
+		}
+	}
+	if o.QueueSize <= 0 {
+		return fmt.Errorf("invalid --webhook-queue %d", o.QueueSize)
+	}
+	if o.Retries < 0 {
+		return fmt.Errorf("invalid --webhook-retries %d", o.Retries)
+	}
+	return nil
+}
+
+// webhookPayload は POST する JSON。しきい値イベントでは向きと閾値を含みます
+type webhookPayload struct {
+	scanEvent
+	Threshold *int   `json:"threshold,omitempty"`
+	Crossed   string `json:"crossed,omitempty"` // "above" / "below"
+}
+
+// hookState はデバイス毎の判定用の状態
+type hookState struct {
+	above   bool
+	payload string
+}
+
+// webhookSink は選択されたイベントを HTTP POST で通知する eventSink。
+// new / lost はセッションのデバイス表に従い、閾値と内容の変化はデバイス表にあるデバイスについて判定します。
+// 送信はキューを介して別 goroutine で行い、キューが一杯なら捨てて Scan のハンドラを止めません
+type webhookSink struct {
+	opts   webhookOptions
+	events map[string]bool
+	client *http.Client
+	onErr  func(error)
+	queue  chan webhookPayload
+
+	mu      sync.Mutex
+	states  map[string]*hookState // デバイス表にあるデバイスのみ（lost で消す）
+	dropped atomic.Int64
+}
+
+func newWebhookSink(opts webhookOptions) (*webhookSink, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	w := &webhookSink{
+		opts:   opts,
+		events: make(map[string]bool),
+		client: &http.Client{Timeout: opts.Timeout},
+		onErr:  func(err error) { fmt.Fprintln(os.Stderr, err) },
+		queue:  make(chan webhookPayload, opts.QueueSize),
+		states: make(map[string]*hookState),
+	}
+	for _, e := range opts.Events {
+		w.events[e] = true
+	}
+	return w, nil
+}
+
+// payloadKey はアドバタイズの内容を比較するための値です。
+// go-ble はスキャンレスポンスを結合して通知するため、アドバタイズ本体の AD のみを比べます
+func payloadKey(a ble.Advertisement) string {
+	if rr, ok := a.(rawReport); ok {
+		return string(rr.Data())
+	}
+	return string(synthesizeAD(a))
+}
+
+// eventTypes は購読するイベントの種類を返します。
+// rssi-threshold と payload-changed は update にならない RSSI や AD の変化を見るため、その時だけアドバタイズ毎に受け取ります
+func (w *webhookSink) eventTypes() []peekbt.EventType {
+	types := []peekbt.EventType{peekbt.EventNew, peekbt.EventLost}
+	if w.events[hookRSSIThreshold] || w.events[hookPayloadChanged] {
+		types = append(types, peekbt.EventAdv)
+	}
+	return types
+}
+
+// Event はイベントを判定してキューに積みます
+func (w *webhookSink) Event(ev peekbt.Event) {
+	info := ev.Entry.Info
+	above := info.RSSI >= w.opts.RSSI
+
+	var out []webhookPayload
+	w.mu.Lock()
+	st, seen := w.states[info.Address]
+	switch ev.Type {
+	case peekbt.EventNew:
+		w.states[info.Address] = &hookState{above: above, payload: payloadKey(ev.Adv)}
+		out = append(out, w.payload(hookNew, info, ev.Time))
+	case peekbt.EventAdv:
+		// 新規デバイスの EventAdv は EventNew より先に届くため、seen のものだけ比べる
+		if !seen {
+			break
+		}
+		if above != st.above {
+			p := w.payload(hookRSSIThreshold, info, ev.Time)
+			p.Threshold, p.Crossed = &w.opts.RSSI, "below"
+			if above {
+				p.Crossed = "above"
+			}
+			out = append(out, p)
+			st.above = above
+		}
+		if key := payloadKey(ev.Adv); key != st.payload {
+			out = append(out, w.payload(hookPayloadChanged, info, ev.Time))
+			st.payload = key
+		}
+	case peekbt.EventLost:
+		delete(w.states, info.Address)
+		out = append(out, w.payload(hookLost, info, ev.Time))
+	}
+	w.mu.Unlock()
+
+	for _, p := range out {
+		w.enqueue(p)
+	}
+}
+
+func (w *webhookSink) payload(event string, info peekbt.DeviceInfo, now time.Time) webhookPayload {
+	return webhookPayload{scanEvent: scanEvent{Event: event, Timestamp: now.Format(time.RFC3339Nano), DeviceInfo: info}}
+}
+
+// enqueue は選択されたイベントのみをキューに積みます（一杯なら捨てる）
+func (w *webhookSink) enqueue(p webhookPayload) {
+	if !w.events[p.Event] {
+		return
+	}
+	select {
+	case w.queue <- p:
+	default:
+		if n := w.dropped.Add(1); n == 1 || n%100 == 0 {
+			w.onErr(fmt.Errorf("webhook queue full, %d events dropped", n))
+		}
+	}
+}
+
+// run は ctx が終わるまでキューの送信を行います
+func (w *webhookSink) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p := <-w.queue:
+			if err := w.deliver(ctx, p); err != nil && ctx.Err() == nil {
+				w.onErr(err)
+			}
+		}
+	}
+}
+
+// deliver は 1 件を POST します。ネットワークエラーと 5xx / 429 は間隔を倍にしながら再送します
+func (w *webhookSink) deliver(ctx context.Context, p webhookPayload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	wait := webhookBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, p.Event, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.opts.Retries {
+			return fmt.Errorf("webhook %s for %s failed: %w", p.Event, p.Address, err)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		wait = min(wait*2, webhookMaxBackoff)
+	}
+}
+
+// post は 1 回分の送信を行い、再送すべきかとエラーを返します
+func (w *webhookSink) post(ctx context.Context, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "peekbt")
+	req.Header.Set("X-Peekbt-Event", event)
+	if w.opts.Secret != "" {
+		req.Header.Set(webhookSignatureHeader, signWebhook(w.opts.Secret, body))
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("server returned %s", resp.Status)
+	}
+	return false, fmt.Errorf("server returned %s", resp.Status)
+}
+
+// signWebhook は本文の HMAC-SHA256 を "sha256=<hex>" 形式で返します
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,186 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ozsys/peekbt/pkg/peekbt"
+)
+
+type hookRecorder struct {
+	mu     sync.Mutex
+	bodies []map[string]any
+	sigs   []string
+	raw    [][]byte
+}
+
+func (h *hookRecorder) handler(status func(n int) int) http.HandlerFunc {
+	n := 0
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		h.mu.Lock()
+		n++
+		code := status(n)
+		if code < 300 {
+			var m map[string]any
+			json.Unmarshal(b, &m)
+			h.bodies = append(h.bodies, m)
+			h.sigs = append(h.sigs, r.Header.Get(webhookSignatureHeader))
+			h.raw = append(h.raw, b)
+		}
+		h.mu.Unlock()
+		w.WriteHeader(code)
+	}
+}
+
+// feedWebhook は w が購読するイベントを渡す sinkFeeder を返します（TTL 1 分）
+func feedWebhook(w *webhookSink) *sinkFeeder {
+	return newSinkFeeder(peekbt.NewDeviceTable(time.Minute), w, w.eventTypes()...)
+}
+
+func testHookOptions(url string) webhookOptions {
+	return webhookOptions{URL: url, Events: webhookEvents, RSSI: -60, QueueSize: 16, Retries: 3, Timeout: time.Second}
+}
+
+/* ---------- 1. イベントの判定 ---------- */
+func TestWebhookSink_Events(t *testing.T) {
+	w, err := newWebhookSink(testHookOptions("http://localhost/hook"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := feedWebhook(w)
+	now := time.Now()
+	addr := net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}
+	f.adv(stubAdv{addr: addr, rssi: -70, name: "a"}, now)
+	f.adv(stubAdv{addr: addr, rssi: -72, name: "a"}, now) // 変化なし
+	f.adv(stubAdv{addr: addr, rssi: -55, name: "a"}, now) // 閾値を上回る
+	f.adv(stubAdv{addr: addr, rssi: -55, name: "b"}, now) // 内容の変化
+	f.expire(now.Add(2 * time.Minute))
+
+	var got []webhookPayload
+	for len(w.queue) > 0 {
+		got = append(got, <-w.queue)
+	}
+	want := []string{hookNew, hookRSSIThreshold, hookPayloadChanged, hookLost}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %v", len(got), want)
+	}
+	for i, p := range got {
+		if p.Event != want[i] {
+			t.Fatalf("event %d = %s, want %s", i, p.Event, want[i])
+		}
+	}
+	if got[1].Crossed != "above" || *got[1].Threshold != -60 {
+		t.Fatalf("unexpected threshold event: %+v", got[1])
+	}
+}
+
+/* ---------- 2. イベントの選択とキュー溢れ ---------- */
+func TestWebhookSink_SelectAndDrop(t *testing.T) {
+	opts := testHookOptions("http://localhost/hook")
+	opts.Events, opts.QueueSize = []string{hookNew}, 2
+	w, _ := newWebhookSink(opts)
+	w.onErr = func(error) {}
+	f := feedWebhook(w)
+	for i := 0; i < 5; i++ {
+		f.adv(stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 0, byte(i)}}, time.Now())
+	}
+	f.expire(time.Now().Add(time.Hour)) // lost は選択されていない
+	if len(w.queue) != 2 || w.dropped.Load() != 3 {
+		t.Fatalf("queue=%d dropped=%d", len(w.queue), w.dropped.Load())
+	}
+}
+
+/* ---------- 3. 送信・再送・署名 ---------- */
+func TestWebhookSink_DeliverRetry(t *testing.T) {
+	orig := webhookBackoff
+	defer func() { webhookBackoff = orig }()
+	webhookBackoff = time.Millisecond
+
+	rec := &hookRecorder{}
+	srv := httptest.NewServer(rec.handler(func(n int) int {
+		if n < 3 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusNoContent
+	}))
+	defer srv.Close()
+
+	opts := testHookOptions(srv.URL)
+	opts.Secret = "s3cret"
+	w, _ := newWebhookSink(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.run(ctx)
+
+	feedWebhook(w).adv(stubAdv{addr: net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}, name: "Tile"}, time.Now())
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		rec.mu.Lock()
+		n := len(rec.bodies)
+		rec.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("webhook not delivered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if rec.bodies[0]["event"] != hookNew || rec.bodies[0]["name"] != "Tile" {
+		t.Fatalf("unexpected body: %v", rec.bodies[0])
+	}
+	if rec.sigs[0] != signWebhook("s3cret", rec.raw[0]) {
+		t.Fatalf("bad signature %q", rec.sigs[0])
+	}
+}
+
+/* ---------- 4. 4xx は再送しない ---------- */
+func TestWebhookSink_NoRetryOnClientError(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	w, _ := newWebhookSink(testHookOptions(srv.URL))
+	err := w.deliver(context.Background(), w.payload(hookNew, peekbt.DeviceInfo{Address: "aa"}, time.Now()))
+	if err == nil || calls != 1 {
+		t.Fatalf("err=%v calls=%d", err, calls)
+	}
+}
+
+/* ---------- 5. 不正な指定 ---------- */
+func TestWebhookOptions_Validate(t *testing.T) {
+	bad := []webhookOptions{
+		{URL: "ftp://example.com", QueueSize: 1},
+		{URL: "http://example.com", Events: []string{"moved"}, QueueSize: 1},
+		{URL: "http://example.com", QueueSize: 0},
+		{URL: "http://example.com", QueueSize: 1, Retries: -1},
+	}
+	for i, o := range bad {
+		if err := o.validate(); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+	if err := testHookOptions("https://example.com/hook").validate(); err != nil {
+		t.Errorf("valid options rejected: %v", err)
+	}
+}
+
+/* ---------- 6. 署名 ---------- */
+func TestSignWebhook(t *testing.T) {
+	// echo -n '{}' | openssl dgst -sha256 -hmac key
+	want := "sha256=a777724d943eb48dc69bca8a4a6d57a04db3f9ec7e1de4e581e860265bdf3032"
+	if got := signWebhook("key", []byte("{}")); got != want {
+		t.Fatalf("signWebhook = %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package peekbt
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux/hci"
+)
+
+// AddrKind は LE Advertising Report の Address_Type に基づくアドレス種別
+type AddrKind int
+
+const (
+	AddrUnknown AddrKind = iota
+	AddrPublic
+	AddrRandom
+)
+
+// addressTyper は HCI レポートのアドレス種別を公開するアドバタイズ（linux の *hci.Advertisement）
+type addressTyper interface {
+	AddressType() uint8
+}
+
+// AddrKindOf はアドバタイズのアドレス種別を返します。
+// 先頭オクテットからの推測は行わず、HCI レポートの値（または go-ble の型）のみを根拠にします
+func AddrKindOf(a ble.Advertisement) AddrKind {
+	if t, ok := a.(addressTyper); ok {
+		// 0x00: Public, 0x01: Random, 0x02/0x03: 解決済み Identity (Public / Random)
+		switch t.AddressType() {
+		case 0x00, 0x02:
+			return AddrPublic
+		case 0x01, 0x03:
+			return AddrRandom
+		}
+		return AddrUnknown
+	}
+	switch a.Addr().(type) {
+	case hci.RandomAddress:
+		return AddrRandom
+	case net.HardwareAddr:
+		return AddrPublic
+	}
+	return AddrUnknown
+}
+
+// addrFirstOctet はアドレス先頭オクテットを返します。
+// linux の実装では net.HardwareAddr を直接参照して文字列分割を避けます
+func addrFirstOctet(addr ble.Addr) byte {
+	switch v := addr.(type) {
+	case net.HardwareAddr:
+		if len(v) > 0 {
+			return v[0]
+		}
+	case hci.RandomAddress:
+		if hw, ok := v.Addr.(net.HardwareAddr); ok && len(hw) > 0 {
+			return hw[0]
+		}
+	}
+	s := addr.String()
+	if len(s) < 2 {
+		return 0
+	}
+	b, _ := strconv.ParseUint(s[:2], 16, 8)
+	return byte(b)
+}
+
+// AddressType は HCI レポートのアドレス種別を元に表示用の種別を返します。
+// ランダムアドレスの場合のみ MSB 2 ビットでさらに分類します
+func AddressType(a ble.Advertisement) string {
+	switch AddrKindOf(a) {
+	case AddrPublic:
+		return "Public"
+	case AddrRandom:
+		switch addrFirstOctet(a.Addr()) & 0xC0 {
+		case 0x00:
+			return "Non-Resolvable Private"
+		case 0x40:
+			return "Resolvable Private"
+		case 0xC0:
+			return "Static Random"
+		default:
+			return "Random (Reserved)"
+		}
+	default:
+		return "Unknown"
+	}
+}
@@ -0,0 +1,80 @@
+package peekbt
+
+import (
+	"net"
+	"testing"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux/hci"
+)
+
+/* ---------- HCI の Address_Type を持つアドバタイズ ---------- */
+type typedAdv struct {
+	stubAdv
+	typ uint8
+}
+
+func (t typedAdv) AddressType() uint8 { return t.typ }
+
+/* ---------- 1. AddrKindOf ---------- */
+func TestAdvAddrKind(t *testing.T) {
+	hw := net.HardwareAddr{0xC0, 1, 2, 3, 4, 5}
+	cases := []struct {
+		adv  ble.Advertisement
+		want AddrKind
+	}{
+		{typedAdv{stubAdv{addr: hw}, 0x00}, AddrPublic},
+		{typedAdv{stubAdv{addr: hw}, 0x01}, AddrRandom},
+		{typedAdv{stubAdv{addr: hw}, 0x02}, AddrPublic},
+		{typedAdv{stubAdv{addr: hw}, 0x03}, AddrRandom},
+		{typedAdv{stubAdv{addr: hw}, 0xFF}, AddrUnknown},
+		{stubAdv{addr: hw}, AddrPublic},
+		{stubAdv{addr: hci.RandomAddress{Addr: hw}}, AddrRandom},
+		{stubAdv{addr: ble.NewAddr("c0:01:02:03:04:05")}, AddrUnknown},
+	}
+	for i, c := range cases {
+		if got := AddrKindOf(c.adv); got != c.want {
+			t.Errorf("case %d: got %d, want %d", i, got, c.want)
+		}
+	}
+}
+
+/* ---------- 2. 先頭オクテット ---------- */
+func TestAddrFirstOctet(t *testing.T) {
+	cases := []struct {
+		addr ble.Addr
+		want byte
+	}{
+		{net.HardwareAddr{0x4c, 0, 0, 0, 0, 0}, 0x4c},
+		{hci.RandomAddress{Addr: net.HardwareAddr{0xd3, 0, 0, 0, 0, 0}}, 0xd3},
+		{ble.NewAddr("AB:00:00:00:00:00"), 0xab},
+	}
+	for _, c := range cases {
+		if got := addrFirstOctet(c.addr); got != c.want {
+			t.Errorf("%s => %#x, want %#x", c.addr, got, c.want)
+		}
+	}
+}
+
+/* ---------- 3. AddressType ---------- */
+func TestAddressType(t *testing.T) {
+	hw := func(b0 byte) net.HardwareAddr { return net.HardwareAddr{b0, 0, 0, 0, 0, 0} }
+	cases := []struct {
+		adv  ble.Advertisement
+		want string
+	}{
+		// Public は先頭オクテットに関係なく Public
+		{typedAdv{stubAdv{addr: hw(0x00)}, 0x00}, "Public"},
+		{typedAdv{stubAdv{addr: hw(0xF4)}, 0x00}, "Public"},
+		{typedAdv{stubAdv{addr: hw(0x40)}, 0x01}, "Resolvable Private"},
+		{typedAdv{stubAdv{addr: hw(0x00)}, 0x01}, "Non-Resolvable Private"},
+		{typedAdv{stubAdv{addr: hw(0xC0)}, 0x01}, "Static Random"},
+		{typedAdv{stubAdv{addr: hw(0x80)}, 0x01}, "Random (Reserved)"},
+		{stubAdv{addr: ble.NewAddr("C0:00:00:00:00:00")}, "Unknown"},
+	}
+	for _, c := range cases {
+		if got := AddressType(c.adv); got != c.want {
+			t.Errorf("%s => %s, want %s", c.adv.Addr(), got, c.want)
+		}
+	}
+}
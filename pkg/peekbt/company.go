@@ -0,0 +1,67 @@
+package peekbt
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/go-ble/ble"
+)
+
+// companyNames は Bluetooth SIG の Company Identifiers のうち、よく見かけるものの抜粋。
+// 出典: Assigned Numbers の company_identifiers.yaml
+// (https://bitbucket.org/bluetooth-SIG/public/src/main/assigned_numbers/company_identifiers/)
+// 追加する場合は必ず一覧で ID を確認してください
+var companyNames = map[int]string{
+	0x0000: "Ericsson",
+	0x0001: "Nokia",
+	0x0002: "Intel",
+	0x0006: "Microsoft",
+	0x000A: "Qualcomm",
+	0x000D: "Texas Instruments",
+	0x000F: "Broadcom",
+	0x0030: "STMicroelectronics",
+	0x0046: "MediaTek",
+	0x004C: "Apple",
+	0x0059: "Nordic Semiconductor",
+	0x006B: "Polar Electro",
+	0x0075: "Samsung",
+	0x0087: "Garmin",
+	0x009E: "Bose",
+	0x00C4: "LG Electronics",
+	0x00D2: "Dialog Semiconductor",
+	0x00E0: "Google",
+	0x012D: "Sony",
+	0x0131: "Cypress Semiconductor",
+	0x0157: "Huami",
+	0x0171: "Amazon",
+	0x01DA: "Logitech",
+	0x022B: "Tesla",
+	0x02E5: "Espressif",
+	0x038F: "Xiaomi",
+	0x0499: "Ruuvi Innovations",
+	0x067C: "Tile",
+}
+
+// CompanyName は Company ID に対応する名前を返します（不明なら空文字）
+func CompanyName(id int) string {
+	return companyNames[id]
+}
+
+// CompanyIDByName は名前（大文字小文字無視）から Company ID を返します
+func CompanyIDByName(name string) (int, bool) {
+	for id, n := range companyNames {
+		if strings.EqualFold(n, name) {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// CompanyID は Manufacturer Specific Data 先頭 2 バイトの Company ID を返します（無ければ -1）
+func CompanyID(a ble.Advertisement) int {
+	md := a.ManufacturerData()
+	if len(md) < 2 {
+		return -1
+	}
+	return int(binary.LittleEndian.Uint16(md[:2]))
+}
@@ -0,0 +1,18 @@
+package peekbt
+
+import "testing"
+
+/* ---------- 1. 名前と ID ---------- */
+func TestCompanyName(t *testing.T) {
+	for id, want := range map[int]string{0x004C: "Apple", 0x0059: "Nordic Semiconductor", 0x006B: "Polar Electro", 0x067C: "Tile", 0x04F7: ""} {
+		if got := CompanyName(id); got != want {
+			t.Errorf("CompanyName(0x%04X) = %q, want %q", id, got, want)
+		}
+	}
+	if id, ok := CompanyIDByName("tile"); !ok || id != 0x067C {
+		t.Errorf("CompanyIDByName(tile) = 0x%04X, %v", id, ok)
+	}
+	if _, ok := CompanyIDByName("Unknown Corp"); ok {
+		t.Error("unknown company found")
+	}
+}
@@ -0,0 +1,74 @@
+package peekbt
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+// DeviceInfo はアドバタイズ 1 件から分かるデバイスの情報
+type DeviceInfo struct {
+	Address      string   `json:"address" yaml:"address"`
+	AddressType  string   `json:"addressType" yaml:"addressType"`
+	Name         string   `json:"name" yaml:"name"`
+	RSSI         int      `json:"rssi" yaml:"rssi"`
+	ServicesUUID []string `json:"serviceUUIDs" yaml:"serviceUUIDs"`
+	LastSeen     string   `json:"lastSeen" yaml:"lastSeen"`
+	Connectable  bool     `json:"connectable" yaml:"connectable"`
+
+	Sensor *SensorReading `json:"sensor,omitempty" yaml:"sensor,omitempty"`
+}
+
+// NewDeviceInfo は Advertisement から DeviceInfo を組み立てます（LastSeen は現在時刻）
+func NewDeviceInfo(a ble.Advertisement) DeviceInfo {
+	uuids := a.Services()
+	s := make([]string, len(uuids))
+	for i, u := range uuids {
+		s[i] = u.String()
+	}
+	return DeviceInfo{
+		Address:      a.Addr().String(),
+		AddressType:  AddressType(a),
+		Name:         a.LocalName(),
+		RSSI:         a.RSSI(),
+		ServicesUUID: s,
+		LastSeen:     time.Now().Format(time.RFC3339),
+		Connectable:  a.Connectable(),
+		Sensor:       DecodeSensor(a),
+	}
+}
+
+// Fields は表示用のラベルと値の組を順に返します
+func (d DeviceInfo) Fields() [][2]string {
+	f := [][2]string{
+		{"Address", d.Address},
+		{"Address Type", d.AddressType},
+		{"Name", d.Name},
+		{"RSSI", fmt.Sprintf("%d dBm", d.RSSI)},
+		{"Services UUIDs", fmt.Sprintf("%v", d.ServicesUUID)},
+		{"Last Seen", d.LastSeen},
+		{"Connectable", fmt.Sprintf("%t", d.Connectable)},
+	}
+	if s := d.Sensor; s != nil {
+		if s.Temperature != nil {
+			f = append(f, [2]string{"Temperature", fmt.Sprintf("%.2f °C", *s.Temperature)})
+		}
+		if s.Humidity != nil {
+			f = append(f, [2]string{"Humidity", fmt.Sprintf("%.2f %%", *s.Humidity)})
+		}
+		if s.Battery != nil {
+			f = append(f, [2]string{"Battery", fmt.Sprintf("%d %%", *s.Battery)})
+		}
+	}
+	return f
+}
+
+// Changed は prev から内容（アドレス・アドレス種別・名前・サービス・センサー値）が変わったかを返します。
+// 受信のたびに揺れる RSSI と LastSeen、ADV とスキャンレスポンスで異なる Connectable は比較しません
+func (d DeviceInfo) Changed(prev DeviceInfo) bool {
+	return d.Address != prev.Address || d.AddressType != prev.AddressType || d.Name != prev.Name ||
+		!slices.Equal(d.ServicesUUID, prev.ServicesUUID) || !reflect.DeepEqual(d.Sensor, prev.Sensor)
+}
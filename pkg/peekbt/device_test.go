@@ -0,0 +1,72 @@
+package peekbt
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-ble/ble"
+)
+
+/* ---------- テスト用アドバタイズ ---------- */
+type stubAdv struct {
+	addr ble.Addr
+	name string
+	rssi int
+}
+
+func (s stubAdv) Addr() ble.Addr                    { return s.addr }
+func (s stubAdv) RSSI() int                         { return s.rssi }
+func (s stubAdv) Services() []ble.UUID              { return nil }
+func (s stubAdv) LocalName() string                 { return s.name }
+func (s stubAdv) Connectable() bool                 { return true }
+func (s stubAdv) ManufacturerData() []byte          { return nil }
+func (s stubAdv) ServiceData() []ble.ServiceData    { return nil }
+func (s stubAdv) TxPowerLevel() int                 { return 0 }
+func (s stubAdv) SolicitedServiceUUIDs() []ble.UUID { return nil }
+func (s stubAdv) SolicitedService() []ble.UUID      { return nil }
+func (s stubAdv) OverflowService() []ble.UUID       { return nil }
+func (s stubAdv) Raw() []byte                       { return nil }
+
+/* ---------- 1. NewDeviceInfo ---------- */
+func TestNewDeviceInfo(t *testing.T) {
+	mac := "01:23:45:67:89:ab"
+	adv := stubAdv{addr: ble.NewAddr(mac), name: "dev", rssi: -50}
+	if got := NewDeviceInfo(adv); got.Address != mac || got.Name != "dev" || got.RSSI != -50 {
+		t.Errorf("unexpected NewDeviceInfo result: %+v", got)
+	}
+
+	// センサー値は JSON では sensor の下に入り、無ければ省略される
+	b, _ := json.Marshal(NewDeviceInfo(adv))
+	var m map[string]any
+	json.Unmarshal(b, &m)
+	if _, ok := m["sensor"]; ok || m["address"] != mac {
+		t.Errorf("unexpected JSON: %s", b)
+	}
+}
+
+/* ---------- 2. Fields / Changed ---------- */
+func TestDeviceInfo_FieldsChanged(t *testing.T) {
+	a := DeviceInfo{Address: "aa:bb:cc:dd:ee:ff", RSSI: -60, LastSeen: "t1", Sensor: &SensorReading{Battery: intPtr(90)}}
+	f := a.Fields()
+	if f[0] != [2]string{"Address", "aa:bb:cc:dd:ee:ff"} || f[len(f)-1] != [2]string{"Battery", "90 %"} {
+		t.Errorf("unexpected fields: %v", f)
+	}
+
+	b := a
+	b.LastSeen, b.RSSI, b.Connectable = "t2", -59, !a.Connectable
+	if b.Changed(a) {
+		t.Error("LastSeen, RSSI and Connectable alone should not count as a change")
+	}
+	for name, mod := range map[string]func(*DeviceInfo){
+		"name":     func(d *DeviceInfo) { d.Name = "renamed" },
+		"services": func(d *DeviceInfo) { d.ServicesUUID = []string{"180f"} },
+		"sensor":   func(d *DeviceInfo) { d.Sensor = &SensorReading{Battery: intPtr(80)} },
+		"addrType": func(d *DeviceInfo) { d.AddressType = "Public" },
+	} {
+		c := a
+		mod(&c)
+		if !c.Changed(a) {
+			t.Errorf("%s change not detected", name)
+		}
+	}
+}
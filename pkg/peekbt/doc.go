@@ -0,0 +1,16 @@
+// Package peekbt は peekbt のスキャン・デバイス表・アドバタイズのデコードを
+// 他の Go プログラムから使うためのライブラリです。
+// peekbt コマンドもこのパッケージの利用者の 1 つで、パッケージ変数による設定は持たず、
+// セッション毎の設定はすべて Config で明示的に渡します。
+//
+//	d, err := linux.NewDevice()
+//	if err != nil { … }
+//	ble.SetDefaultDevice(d)
+//
+//	s := peekbt.NewSession(peekbt.Config{TTL: 30 * time.Second})
+//	err = s.Run(ctx, func(ev peekbt.Event) {
+//		if ev.Type == peekbt.EventNew {
+//			fmt.Println(ev.Entry.Info.Address, ev.Entry.Info.Name)
+//		}
+//	})
+package peekbt
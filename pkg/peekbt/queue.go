@@ -0,0 +1,139 @@
+package peekbt
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+// DropPolicy はキューが一杯のときの扱い
+type DropPolicy int
+
+const (
+	// DropOldest は最も古いアドバタイズを捨てて新しいものを積みます
+	DropOldest DropPolicy = iota
+	// Coalesce は同じアドレスのアドバタイズが未処理で残っていれば最新のもので置き換えます。
+	// それでも一杯なら DropOldest と同じく最も古いものを捨てます
+	Coalesce
+)
+
+var dropPolicyNames = map[DropPolicy]string{DropOldest: "drop-oldest", Coalesce: "coalesce"}
+
+// String は CLI で使う名前を返します
+func (p DropPolicy) String() string {
+	if s, ok := dropPolicyNames[p]; ok {
+		return s
+	}
+	return fmt.Sprintf("DropPolicy(%d)", int(p))
+}
+
+// ParseDropPolicy は "drop-oldest" / "coalesce" を解釈します
+func ParseDropPolicy(s string) (DropPolicy, error) {
+	for p, name := range dropPolicyNames {
+		if s == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid drop policy %q (want drop-oldest or coalesce)", s)
+}
+
+// QueueStats はキューの状態
+type QueueStats struct {
+	Len       int    // 未処理のアドバタイズ数
+	Cap       int    // キューの大きさ
+	Dropped   uint64 // 捨てたアドバタイズ数
+	Coalesced uint64 // 同じアドレスの新しいアドバタイズで置き換えた数
+}
+
+// queued はキュー内の 1 件
+type queued struct {
+	adv  ble.Advertisement
+	at   time.Time
+	addr string
+}
+
+// advQueue は受信ハンドラと処理側の間の有界キュー。push はブロックしません
+type advQueue struct {
+	policy DropPolicy
+	ready  chan struct{} // 空でなくなったことの通知（容量 1）
+
+	mu    sync.Mutex
+	buf   []*queued // リングバッファ
+	head  int
+	n     int
+	index map[string]*queued // Coalesce 用の未処理のアドレス
+
+	dropped   atomic.Uint64
+	coalesced atomic.Uint64
+}
+
+func newAdvQueue(size int, policy DropPolicy) *advQueue {
+	return &advQueue{
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+		buf:    make([]*queued, size),
+		index:  make(map[string]*queued),
+	}
+}
+
+// push はアドバタイズを積みます。一杯なら policy に従って置き換えるか古いものを捨てます
+func (q *advQueue) push(a ble.Advertisement, at time.Time) {
+	addr := a.Addr().String()
+	q.mu.Lock()
+	if q.policy == Coalesce {
+		if it, ok := q.index[addr]; ok {
+			it.adv, it.at = a, at
+			q.mu.Unlock()
+			q.coalesced.Add(1)
+			return
+		}
+	}
+	if q.n == len(q.buf) {
+		q.removeHead()
+		q.dropped.Add(1)
+	}
+	it := &queued{adv: a, at: at, addr: addr}
+	q.buf[(q.head+q.n)%len(q.buf)] = it
+	q.n++
+	if q.policy == Coalesce {
+		q.index[addr] = it
+	}
+	q.mu.Unlock()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop は最も古い 1 件を取り出します（空なら nil）
+func (q *advQueue) pop() *queued {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.n == 0 {
+		return nil
+	}
+	return q.removeHead()
+}
+
+// removeHead は先頭を取り除いて返します（q.mu を保持して呼ぶ）
+func (q *advQueue) removeHead() *queued {
+	it := q.buf[q.head]
+	q.buf[q.head] = nil
+	q.head = (q.head + 1) % len(q.buf)
+	q.n--
+	if q.index[it.addr] == it {
+		delete(q.index, it.addr)
+	}
+	return it
+}
+
+func (q *advQueue) stats() QueueStats {
+	q.mu.Lock()
+	n := q.n
+	q.mu.Unlock()
+	return QueueStats{Len: n, Cap: len(q.buf), Dropped: q.dropped.Load(), Coalesced: q.coalesced.Load()}
+}
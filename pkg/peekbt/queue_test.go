@@ -0,0 +1,141 @@
+package peekbt
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+func addrN(i int) net.HardwareAddr {
+	return net.HardwareAddr{0, 0, 0, 0, byte(i >> 8), byte(i)}
+}
+
+// popAll はキューの中身をアドレスと RSSI の組で取り出します
+func popAll(q *advQueue) [][2]any {
+	var got [][2]any
+	for it := q.pop(); it != nil; it = q.pop() {
+		got = append(got, [2]any{it.addr, it.adv.RSSI()})
+	}
+	return got
+}
+
+/* ---------- 1. drop-oldest ---------- */
+func TestAdvQueue_DropOldest(t *testing.T) {
+	q := newAdvQueue(3, DropOldest)
+	now := time.Now()
+	for i := 1; i <= 5; i++ {
+		q.push(stubAdv{addr: addrN(1), rssi: -i}, now)
+	}
+	st := q.stats()
+	if st.Len != 3 || st.Cap != 3 || st.Dropped != 2 || st.Coalesced != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+	got := popAll(q)
+	if len(got) != 3 || got[0][1] != -3 || got[2][1] != -5 {
+		t.Fatalf("queue %v, want the newest 3 in order", got)
+	}
+}
+
+/* ---------- 2. coalesce ---------- */
+func TestAdvQueue_Coalesce(t *testing.T) {
+	q := newAdvQueue(2, Coalesce)
+	now := time.Now()
+	q.push(stubAdv{addr: addrN(1), rssi: -10}, now)
+	q.push(stubAdv{addr: addrN(2), rssi: -20}, now)
+	q.push(stubAdv{addr: addrN(1), rssi: -11}, now) // 位置はそのまま最新に置き換え
+	if st := q.stats(); st.Len != 2 || st.Coalesced != 1 || st.Dropped != 0 {
+		t.Fatalf("stats %+v", st)
+	}
+	// 別のアドレスで溢れたら最も古いものを捨てる
+	q.push(stubAdv{addr: addrN(3), rssi: -30}, now)
+	if st := q.stats(); st.Dropped != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+	got := popAll(q)
+	if len(got) != 2 || got[0][0] != "00:00:00:00:00:02" || got[1][1] != -30 {
+		t.Fatalf("queue %v", got)
+	}
+	// 取り出した後の同じアドレスは新しく積まれる
+	q.push(stubAdv{addr: addrN(3), rssi: -31}, now)
+	if st := q.stats(); st.Len != 1 || st.Coalesced != 1 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestParseDropPolicy(t *testing.T) {
+	for _, p := range []DropPolicy{DropOldest, Coalesce} {
+		got, err := ParseDropPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseDropPolicy(%q) = %v, %v", p, got, err)
+		}
+	}
+	if _, err := ParseDropPolicy("block"); err == nil {
+		t.Error("want error for unknown policy")
+	}
+}
+
+// burstScanner は advs を一気に流してすぐに戻ります
+type burstScanner struct {
+	advs []ble.Advertisement
+}
+
+func (b burstScanner) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler, flt ble.AdvFilter) error {
+	for _, a := range b.advs {
+		h(a)
+	}
+	return nil
+}
+
+/* ---------- 3. 処理が遅くても受信は待たされない ---------- */
+func TestSession_Queue(t *testing.T) {
+	var advs []ble.Advertisement
+	for i := 0; i < 100; i++ {
+		advs = append(advs, stubAdv{addr: addrN(i % 4), rssi: -i})
+	}
+	s := NewSession(Config{Scanner: burstScanner{advs}, TTL: time.Hour, QueueSize: 8, DropPolicy: Coalesce})
+
+	// 最初のイベントで処理を止めておき、その間に全件受信させる
+	release := make(chan struct{})
+	var got []Event
+	done := make(chan error)
+	go func() {
+		done <- s.Run(context.Background(), func(ev Event) {
+			if len(got) == 0 {
+				<-release
+			}
+			got = append(got, ev)
+		})
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if st := s.QueueStats(); st.Coalesced+uint64(st.Len) >= 99 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// スキャンの終了後も残りを処理してから戻る
+	st := s.QueueStats()
+	if st.Len != 0 || st.Dropped != 0 || st.Coalesced == 0 {
+		t.Fatalf("stats %+v", st)
+	}
+	var last Event
+	for _, ev := range got {
+		if ev.Type == EventAdv && ev.Entry.Info.Address == "00:00:00:00:00:03" {
+			last = ev
+		}
+	}
+	if last.Adv == nil || last.Adv.RSSI() != -99 {
+		t.Errorf("latest advertisement of a coalesced device lost: %+v", last)
+	}
+	if s.Table().Len() != 4 {
+		t.Errorf("%d devices, want 4", s.Table().Len())
+	}
+}
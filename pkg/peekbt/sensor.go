@@ -0,0 +1,148 @@
+package peekbt
+
+import (
+	"encoding/binary"
+
+	"github.com/go-ble/ble"
+)
+
+// 対応するセンサーのアドバタイズ形式（SensorReading.Format の値）
+const (
+	SensorBTHome = "bthome" // BTHome v2（暗号化なし）
+	SensorATC    = "atc"    // ATC1441 カスタムファームウェア
+	SensorPVVX   = "pvvx"   // pvvx カスタムファームウェア
+	SensorGovee  = "govee"  // Govee H5075 / H5072
+)
+
+var (
+	uuidBTHome = ble.UUID16(0xFCD2)
+	uuidESS    = ble.UUID16(0x181A) // Environmental Sensing（ATC / pvvx が流用）
+)
+
+// goveeCompanyID は Govee の温湿度計が Manufacturer Data に載せる ID
+const goveeCompanyID = 0xEC88
+
+// SensorReading はアドバタイズから読み取ったセンサー値。読み取れなかった値は nil
+type SensorReading struct {
+	Format      string   `json:"format" yaml:"format"`
+	Temperature *float64 `json:"temperature,omitempty" yaml:"temperature,omitempty"` // ℃
+	Humidity    *float64 `json:"humidity,omitempty" yaml:"humidity,omitempty"`       // %
+	Battery     *int     `json:"battery,omitempty" yaml:"battery,omitempty"`         // %
+}
+
+// DecodeSensor は既知の形式のセンサー値を読み取ります（該当しなければ nil）
+func DecodeSensor(a ble.Advertisement) *SensorReading {
+	for _, sd := range a.ServiceData() {
+		var r *SensorReading
+		switch {
+		case sd.UUID.Equal(uuidBTHome):
+			r = decodeBTHome(sd.Data)
+		case sd.UUID.Equal(uuidESS):
+			r = decodeESS(sd.Data)
+		}
+		if r != nil {
+			return r
+		}
+	}
+	if CompanyID(a) == goveeCompanyID {
+		return decodeGovee(a.ManufacturerData()[2:])
+	}
+	return nil
+}
+
+// bthomeSizes は BTHome v2 のオブジェクト ID 毎のデータ長。
+// 未知の ID 以降は長さが分からないため読み取りを打ち切ります
+var bthomeSizes = func() map[byte]int {
+	m := map[byte]int{
+		0x00: 1, 0x01: 1, 0x02: 2, 0x03: 2, 0x04: 3, 0x05: 3, 0x06: 2, 0x07: 2,
+		0x08: 2, 0x09: 1, 0x0A: 3, 0x0B: 3, 0x0C: 2, 0x0D: 2, 0x0E: 2, 0x12: 2,
+		0x13: 2, 0x14: 2, 0x2E: 1, 0x2F: 1, 0x3A: 1, 0x3C: 2, 0x3D: 2, 0x3E: 4,
+		0x3F: 2, 0x40: 2, 0x41: 2, 0x42: 3, 0x43: 2, 0x44: 2, 0x45: 2, 0x46: 1,
+		0x47: 2, 0x48: 2, 0x49: 2, 0x4A: 2, 0x4B: 3, 0x4C: 4, 0x4D: 4, 0x4E: 4,
+		0x4F: 4, 0x50: 4, 0x51: 2, 0x52: 2, 0x57: 1, 0x58: 1,
+	}
+	for id := byte(0x0F); id <= 0x2D; id++ { // 2 値センサー
+		if id != 0x12 && id != 0x13 && id != 0x14 {
+			m[id] = 1
+		}
+	}
+	return m
+}()
+
+// decodeBTHome は BTHome v2 のサービスデータを読み取ります
+func decodeBTHome(b []byte) *SensorReading {
+	if len(b) < 1 || b[0]&0x01 != 0 || b[0]>>5 != 2 { // 暗号化あり / v2 以外
+		return nil
+	}
+	r := &SensorReading{Format: SensorBTHome}
+	for p := b[1:]; len(p) > 0; {
+		n, ok := bthomeSizes[p[0]]
+		if !ok || len(p) < 1+n {
+			break
+		}
+		v := p[1 : 1+n]
+		switch p[0] {
+		case 0x01:
+			r.Battery = intPtr(int(v[0]))
+		case 0x02:
+			r.Temperature = floatPtr(float64(int16(binary.LittleEndian.Uint16(v))) / 100)
+		case 0x45:
+			r.Temperature = floatPtr(float64(int16(binary.LittleEndian.Uint16(v))) / 10)
+		case 0x57:
+			r.Temperature = floatPtr(float64(int8(v[0])))
+		case 0x03:
+			r.Humidity = floatPtr(float64(binary.LittleEndian.Uint16(v)) / 100)
+		case 0x2E:
+			r.Humidity = floatPtr(float64(v[0]))
+		}
+		p = p[1+n:]
+	}
+	if r.Temperature == nil && r.Humidity == nil && r.Battery == nil {
+		return nil
+	}
+	return r
+}
+
+// decodeESS は Xiaomi 温湿度計のカスタムファームウェア（ATC1441 / pvvx）形式を読み取ります
+func decodeESS(b []byte) *SensorReading {
+	switch len(b) {
+	case 13: // ATC1441: MAC(6) 温度(BE, 0.1℃) 湿度(%) 電池(%) 電圧(mV) カウンタ
+		return &SensorReading{
+			Format:      SensorATC,
+			Temperature: floatPtr(float64(int16(binary.BigEndian.Uint16(b[6:]))) / 10),
+			Humidity:    floatPtr(float64(b[8])),
+			Battery:     intPtr(int(b[9])),
+		}
+	case 15: // pvvx: MAC(6, LE) 温度(LE, 0.01℃) 湿度(LE, 0.01%) 電圧(mV) 電池(%) カウンタ フラグ
+		return &SensorReading{
+			Format:      SensorPVVX,
+			Temperature: floatPtr(float64(int16(binary.LittleEndian.Uint16(b[6:]))) / 100),
+			Humidity:    floatPtr(float64(binary.LittleEndian.Uint16(b[8:])) / 100),
+			Battery:     intPtr(int(b[12])),
+		}
+	}
+	return nil
+}
+
+// decodeGovee は Govee H5075 形式（Company ID に続く 24bit 値と電池残量）を読み取ります
+func decodeGovee(b []byte) *SensorReading {
+	if len(b) < 5 {
+		return nil
+	}
+	v := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+	neg := v&0x800000 != 0
+	v &^= 0x800000
+	temp := float64(v/1000) / 10
+	if neg {
+		temp = -temp
+	}
+	return &SensorReading{
+		Format:      SensorGovee,
+		Temperature: floatPtr(temp),
+		Humidity:    floatPtr(float64(v%1000) / 10),
+		Battery:     intPtr(int(b[4])),
+	}
+}
+
+func floatPtr(v float64) *float64 { return &v }
+func intPtr(v int) *int           { return &v }
@@ -0,0 +1,82 @@
+package peekbt
+
+import (
+	"testing"
+
+	"github.com/go-ble/ble"
+)
+
+type sensorAdv struct {
+	stubAdv
+	sd  []ble.ServiceData
+	mfg []byte
+}
+
+func (s sensorAdv) ServiceData() []ble.ServiceData { return s.sd }
+func (s sensorAdv) ManufacturerData() []byte       { return s.mfg }
+
+func checkReading(t *testing.T, r *SensorReading, format string, temp, hum float64, batt int) {
+	t.Helper()
+	if r == nil {
+		t.Fatalf("no reading decoded")
+	}
+	if r.Format != format || r.Temperature == nil || *r.Temperature != temp ||
+		r.Humidity == nil || *r.Humidity != hum || r.Battery == nil || *r.Battery != batt {
+		t.Fatalf("got %s %v %v %v", r.Format, deref(r.Temperature), deref(r.Humidity), r.Battery)
+	}
+}
+
+func deref(f *float64) any {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
+
+/* ---------- 1. BTHome v2 ---------- */
+func TestDecodeSensor_BTHome(t *testing.T) {
+	// packet id, battery 97%, temperature 23.45, humidity 41.5
+	data := []byte{0x40, 0x00, 0x12, 0x01, 0x61, 0x02, 0x29, 0x09, 0x03, 0x36, 0x10}
+	a := sensorAdv{sd: []ble.ServiceData{{UUID: ble.UUID16(0xFCD2), Data: data}}}
+	checkReading(t, DecodeSensor(a), SensorBTHome, 23.45, 41.5, 97)
+
+	// 暗号化されたものは読まない
+	enc := append([]byte{0x41}, data[1:]...)
+	if r := DecodeSensor(sensorAdv{sd: []ble.ServiceData{{UUID: ble.UUID16(0xFCD2), Data: enc}}}); r != nil {
+		t.Fatalf("encrypted payload decoded: %+v", r)
+	}
+	// 未知のオブジェクト以降は読み飛ばす
+	partial := []byte{0x40, 0x01, 0x50, 0xF0, 0x01, 0x02, 0x03, 0x10, 0x27}
+	r := decodeBTHome(partial)
+	if r == nil || r.Battery == nil || *r.Battery != 80 || r.Humidity != nil {
+		t.Fatalf("unexpected partial decode: %+v", r)
+	}
+}
+
+/* ---------- 2. ATC1441 / pvvx ---------- */
+func TestDecodeSensor_ESS(t *testing.T) {
+	atc := []byte{0xa4, 0xc1, 0x38, 1, 2, 3, 0x00, 0xD7, 55, 88, 0x0B, 0xB8, 7}
+	checkReading(t, DecodeSensor(sensorAdv{sd: []ble.ServiceData{{UUID: ble.UUID16(0x181A), Data: atc}}}), SensorATC, 21.5, 55, 88)
+
+	pvvx := []byte{3, 2, 1, 0x38, 0xc1, 0xa4, 0x18, 0xFC, 0x6E, 0x11, 0xB8, 0x0B, 76, 1, 0}
+	checkReading(t, DecodeSensor(sensorAdv{sd: []ble.ServiceData{{UUID: ble.UUID16(0x181A), Data: pvvx}}}), SensorPVVX, -10, 44.62, 76)
+}
+
+/* ---------- 3. Govee ---------- */
+func TestDecodeSensor_Govee(t *testing.T) {
+	a := sensorAdv{mfg: []byte{0x88, 0xEC, 0x00, 0x03, 0xA5, 0x1F, 0x64, 0x00}}
+	checkReading(t, DecodeSensor(a), SensorGovee, 23.8, 87.9, 100)
+
+	neg := sensorAdv{mfg: []byte{0x88, 0xEC, 0x00, 0x80, 0x13, 0x88, 0x50, 0x00}}
+	checkReading(t, DecodeSensor(neg), SensorGovee, -0.5, 0, 80)
+}
+
+/* ---------- 4. 非対応 ---------- */
+func TestDecodeSensor_None(t *testing.T) {
+	if r := DecodeSensor(stubAdv{}); r != nil {
+		t.Fatalf("unexpected reading: %+v", r)
+	}
+	if r := DecodeSensor(sensorAdv{sd: []ble.ServiceData{{UUID: ble.UUID16(0x181A), Data: []byte{1, 2}}}}); r != nil {
+		t.Fatalf("unexpected reading: %+v", r)
+	}
+}
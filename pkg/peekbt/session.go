@@ -0,0 +1,205 @@
+package peekbt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+// DefaultTTL は Config.TTL を省略した場合にデバイスを消すまでの時間
+const DefaultTTL = 10 * time.Second
+
+// EventType はセッションが通知するイベントの種類
+type EventType string
+
+const (
+	EventAdv    EventType = "adv"    // アドバタイズを受信した（毎回）
+	EventNew    EventType = "new"    // 初めて見えたデバイス
+	EventUpdate EventType = "update" // LastSeen 以外の情報が変わった
+	EventLost   EventType = "lost"   // TTL の間見えなかった
+)
+
+// Event はセッションが通知するイベント
+type Event struct {
+	Type  EventType
+	Time  time.Time
+	Entry Entry             // デバイス表の状態（EventLost では最後の状態）
+	Adv   ble.Advertisement // 受信したアドバタイズ（EventLost では nil）
+}
+
+// Scanner はアドバタイズの供給元。ble.Device や記録ファイルの再生などを差し替えられます
+type Scanner interface {
+	Scan(ctx context.Context, allowDup bool, h ble.AdvHandler, f ble.AdvFilter) error
+}
+
+// Config はセッションの設定
+type Config struct {
+	// Scanner はアドバタイズの供給元（nil なら ble.SetDefaultDevice で設定したデバイス）
+	Scanner Scanner
+	// Duplicates が true なら同じデバイスのアドバタイズも毎回受け取ります
+	Duplicates bool
+	// Filter を満たすアドバタイズのみを扱います（nil なら全件）
+	Filter ble.AdvFilter
+	// TTL はこの間見えなかったデバイスを lost にするまでの時間（0 なら DefaultTTL）
+	TTL time.Duration
+	// Now は時刻の取得元（nil なら time.Now）
+	Now func() time.Time
+	// MaxDevices が正なら、デバイス表にこれを超えるデバイスがあると
+	// 最も長く見えていないものから EventLost として消します（0 なら無制限）
+	MaxDevices int
+	// QueueSize が正なら、受信ハンドラとイベント処理の間にこの大きさの有界キューを挟みます。
+	// 処理が追いつかなくても受信（HCI）側は待たされず、溢れた分は DropPolicy に従って捨てます。
+	// 0 なら受信ハンドラの中で処理します
+	QueueSize int
+	// DropPolicy はキューが一杯のときの扱い
+	DropPolicy DropPolicy
+}
+
+// Session は 1 回分のスキャン。受信したアドバタイズでデバイス表を更新し、変化をイベントとして通知します
+type Session struct {
+	cfg   Config
+	table *DeviceTable
+	queue *advQueue  // QueueSize が 0 なら nil
+	mu    sync.Mutex // ハンドラを逐次呼ぶためのロック
+}
+
+// NewSession は cfg に従うセッションを作ります
+func NewSession(cfg Config) *Session {
+	if cfg.Scanner == nil {
+		cfg.Scanner = defaultScanner{}
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = DefaultTTL
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	s := &Session{cfg: cfg, table: NewDeviceTable(cfg.TTL)}
+	s.table.SetMaxDevices(cfg.MaxDevices)
+	if cfg.QueueSize > 0 {
+		s.queue = newAdvQueue(cfg.QueueSize, cfg.DropPolicy)
+	}
+	return s
+}
+
+// QueueStats はキューの状態を返します（キューを使わない場合はゼロ値）
+func (s *Session) QueueStats() QueueStats {
+	if s.queue == nil {
+		return QueueStats{}
+	}
+	return s.queue.stats()
+}
+
+// Table はセッションのデバイス表を返します
+func (s *Session) Table() *DeviceTable {
+	return s.table
+}
+
+// Run は ctx が終わるかスキャンが終わるまでスキャンし、イベントを h に渡します。
+// h は同時に複数呼ばれることはなく、1 件のアドバタイズに対して
+// EventAdv の後に必要なら EventNew / EventUpdate が続きます
+func (s *Session) Run(ctx context.Context, h func(Event)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(s.cfg.TTL / 4)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				s.expire(h)
+			}
+		}
+	}()
+
+	if s.queue == nil {
+		err := s.cfg.Scanner.Scan(ctx, s.cfg.Duplicates, func(a ble.Advertisement) {
+			s.Handle(a, h)
+		}, s.cfg.Filter)
+		cancel()
+		<-done
+		return err
+	}
+
+	// 受信ハンドラはキューに積むだけにして、処理は別の goroutine で行う
+	scanned := make(chan struct{})
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		s.drain(ctx, scanned, h)
+	}()
+	err := s.cfg.Scanner.Scan(ctx, s.cfg.Duplicates, func(a ble.Advertisement) {
+		s.queue.push(a, s.cfg.Now())
+	}, s.cfg.Filter)
+	close(scanned)
+	<-drained
+	cancel()
+	<-done
+	return err
+}
+
+// drain はキューからアドバタイズを取り出して処理します。
+// スキャンが終わったら（scanned が閉じたら）残りを処理してから戻ります
+func (s *Session) drain(ctx context.Context, scanned <-chan struct{}, h func(Event)) {
+	for {
+		for it := s.queue.pop(); it != nil; it = s.queue.pop() {
+			if ctx.Err() != nil {
+				return
+			}
+			s.handleAt(it.adv, it.at, h)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-scanned:
+			if s.queue.stats().Len == 0 {
+				return
+			}
+		case <-s.queue.ready:
+		}
+	}
+}
+
+// Handle は 1 件のアドバタイズを処理します。Run を使わずに独自の受信処理から呼ぶこともできます
+func (s *Session) Handle(a ble.Advertisement, h func(Event)) {
+	s.handleAt(a, s.cfg.Now(), h)
+}
+
+// handleAt は now に受信したものとしてアドバタイズを処理します
+func (s *Session) handleAt(a ble.Advertisement, now time.Time, h func(Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, typ := s.table.Update(a, now)
+	h(Event{Type: EventAdv, Time: now, Entry: e, Adv: a})
+	if typ != "" {
+		h(Event{Type: typ, Time: now, Entry: e, Adv: a})
+	}
+	// 上限を超えた分と TTL 切れをすぐに消す（消すものが無ければ O(1)）。
+	// 受信時刻で判定するため、Now が記録の時刻を返す再生では最大速度でも記録時と同じ時刻に lost になる
+	for _, e := range s.table.Expire(now) {
+		h(Event{Type: EventLost, Time: now, Entry: e})
+	}
+}
+
+// expire は TTL を過ぎたデバイスを表から消して EventLost を通知します
+func (s *Session) expire(h func(Event)) {
+	now := s.cfg.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.table.Expire(now) {
+		h(Event{Type: EventLost, Time: now, Entry: e})
+	}
+}
+
+type defaultScanner struct{}
+
+func (defaultScanner) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler, f ble.AdvFilter) error {
+	return ble.Scan(ctx, allowDup, h, f)
+}
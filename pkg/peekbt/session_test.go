@@ -0,0 +1,100 @@
+package peekbt
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+// fakeScanner は advs を順に流してから ctx の終了を待ちます
+type fakeScanner struct {
+	advs    []ble.Advertisement
+	allowed bool
+}
+
+func (f *fakeScanner) Scan(ctx context.Context, allowDup bool, h ble.AdvHandler, flt ble.AdvFilter) error {
+	f.allowed = allowDup
+	for _, a := range f.advs {
+		if flt == nil || flt(a) {
+			h(a)
+		}
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+/* ---------- 1. イベントの順序とフィルタ ---------- */
+func TestSession_Events(t *testing.T) {
+	a1 := net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	a2 := net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	sc := &fakeScanner{advs: []ble.Advertisement{
+		stubAdv{addr: a1, name: "one", rssi: -70},
+		stubAdv{addr: a1, name: "one", rssi: -60}, // RSSI だけの変化は update にならない
+		stubAdv{addr: a1, name: "uno", rssi: -60},
+		stubAdv{addr: a2, name: "skip", rssi: -50},
+	}}
+	s := NewSession(Config{
+		Scanner:    sc,
+		Duplicates: true,
+		Filter:     func(a ble.Advertisement) bool { return a.LocalName() != "skip" },
+		TTL:        time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var got []EventType
+	err := s.Run(ctx, func(ev Event) {
+		got = append(got, ev.Type)
+		if len(got) == 5 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("Run: %v", err)
+	}
+	want := []EventType{EventAdv, EventNew, EventAdv, EventAdv, EventUpdate}
+	if len(got) != len(want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events %v, want %v", got, want)
+		}
+	}
+	if !sc.allowed || s.Table().Len() != 1 {
+		t.Errorf("allowDup=%v devices=%d", sc.allowed, s.Table().Len())
+	}
+}
+
+/* ---------- 2. TTL 切れで lost ---------- */
+func TestSession_Lost(t *testing.T) {
+	var mu sync.Mutex
+	now := time.Now()
+	clock := func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		now = now.Add(time.Second)
+		return now
+	}
+	sc := &fakeScanner{advs: []ble.Advertisement{stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 0, 1}}}}
+	s := NewSession(Config{Scanner: sc, TTL: 20 * time.Millisecond, Now: clock})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var lost *Event
+	s.Run(ctx, func(ev Event) {
+		if ev.Type == EventLost {
+			lost = &ev
+			cancel()
+		}
+	})
+	if lost == nil || lost.Entry.Info.Address != "00:00:00:00:00:01" || lost.Adv != nil {
+		t.Fatalf("unexpected lost event: %+v", lost)
+	}
+	if s.Table().Len() != 0 {
+		t.Errorf("device not removed from the table")
+	}
+}
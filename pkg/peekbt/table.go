@@ -0,0 +1,309 @@
+package peekbt
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+// DeviceStats はデバイス毎の受信統計
+type DeviceStats struct {
+	FirstSeen string  `json:"firstSeen" yaml:"firstSeen"`
+	Count     int     `json:"count" yaml:"count"`
+	RSSIMin   int     `json:"rssiMin" yaml:"rssiMin"`
+	RSSIMax   int     `json:"rssiMax" yaml:"rssiMax"`
+	RSSIAvg   float64 `json:"rssiAvg" yaml:"rssiAvg"`
+}
+
+// DeviceRecord はデバイス表の 1 行。DeviceInfo のフィールドはフラットに展開されます
+type DeviceRecord struct {
+	DeviceInfo `yaml:",inline"`
+	Vendor     string      `json:"vendor,omitempty" yaml:"vendor,omitempty"`
+	Stats      DeviceStats `json:"stats" yaml:"stats"`
+}
+
+// Entry はデバイス表の 1 台分の状態。DeviceTable が返すのは常にコピーです
+type Entry struct {
+	Info      DeviceInfo
+	CompanyID int               // 最後に受信した Company ID（一度も無ければ -1）
+	Vendor    string            // CompanyID から引いた名前（不明なら空）
+	Adv       ble.Advertisement // フィルタ式の評価用に最後のアドバタイズを保持
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Count     int
+	RSSIMin   int
+	RSSIMax   int
+	rssiSum   int
+}
+
+// RSSIAvg は受信したアドバタイズの RSSI の平均を返します
+func (e *Entry) RSSIAvg() float64 {
+	if e.Count == 0 {
+		return 0
+	}
+	return float64(e.rssiSum) / float64(e.Count)
+}
+
+// Record は JSON / YAML 出力用の行を返します
+func (e *Entry) Record() DeviceRecord {
+	return DeviceRecord{
+		DeviceInfo: e.Info,
+		Vendor:     e.Vendor,
+		Stats: DeviceStats{
+			FirstSeen: e.FirstSeen.Format(time.RFC3339),
+			Count:     e.Count,
+			RSSIMin:   e.RSSIMin,
+			RSSIMax:   e.RSSIMax,
+			RSSIAvg:   e.RSSIAvg(),
+		},
+	}
+}
+
+// DeviceTable は現在見えているデバイスの表。ttl を過ぎたデバイスは Expire で消えます。
+// デバイスは最後に見えた順のリストでも管理しており、更新は O(1)、Expire は消すデバイス数に比例します。
+// SetMaxDevices で上限を決めると、超えた分は最も長く見えていないデバイスから Expire で消えます。
+// 複数の goroutine から同時に使えます
+type DeviceTable struct {
+	mu      sync.RWMutex
+	ttl     time.Duration
+	max     int                      // 0 なら無制限
+	entries map[string]*list.Element // 値は *Entry
+	recent  *list.List               // LastSeen の新しい順
+}
+
+// NewDeviceTable は ttl だけ見えなければ消えるデバイス表を作ります
+func NewDeviceTable(ttl time.Duration) *DeviceTable {
+	return &DeviceTable{ttl: ttl, entries: make(map[string]*list.Element), recent: list.New()}
+}
+
+// TTL はデバイスを消すまでの時間を返します
+func (t *DeviceTable) TTL() time.Duration {
+	return t.ttl
+}
+
+// SetMaxDevices は保持するデバイス数の上限を設定します（0 なら無制限）
+func (t *DeviceTable) SetMaxDevices(n int) {
+	t.mu.Lock()
+	t.max = max(n, 0)
+	t.mu.Unlock()
+}
+
+// Update はアドバタイズで表を更新し、更新後の状態と変化の種類
+// （EventNew / EventUpdate、LastSeen 以外に変化が無ければ ""）を返します。
+// 上限を超えても Update では消さないため、続けて Expire を呼んでください
+func (t *DeviceTable) Update(a ble.Advertisement, now time.Time) (Entry, EventType) {
+	info := NewDeviceInfo(a)
+	info.LastSeen = now.Format(time.RFC3339)
+	id := CompanyID(a)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var e *Entry
+	el, ok := t.entries[info.Address]
+	if ok {
+		e = el.Value.(*Entry)
+		t.touch(el, now)
+	} else {
+		e = &Entry{CompanyID: -1, FirstSeen: now, RSSIMin: info.RSSI, RSSIMax: info.RSSI}
+		el = t.recent.PushFront(e)
+		t.entries[info.Address] = el
+		t.touch(el, now)
+	}
+	// 名前・サービス・センサー値・Company ID は空で上書きしない（ADV とスキャンレスポンスの片方にしか載らない場合がある）
+	if info.Name == "" {
+		info.Name = e.Info.Name
+	}
+	if len(info.ServicesUUID) == 0 {
+		info.ServicesUUID = e.Info.ServicesUUID
+	}
+	if info.Sensor == nil {
+		info.Sensor = e.Info.Sensor
+	}
+	if id >= 0 {
+		e.CompanyID, e.Vendor = id, CompanyName(id)
+	}
+	var event EventType
+	switch {
+	case !ok:
+		event = EventNew
+	case info.Changed(e.Info):
+		event = EventUpdate
+	}
+	e.Info, e.Adv, e.LastSeen = info, a, now
+	e.Count++
+	e.rssiSum += info.RSSI
+	e.RSSIMin = min(e.RSSIMin, info.RSSI)
+	e.RSSIMax = max(e.RSSIMax, info.RSSI)
+	return *e, event
+}
+
+// touch は el を LastSeen の順に並ぶ位置へ移します。
+// 受信時刻は通常単調なので先頭への移動で済みます（t.mu を保持して呼ぶ）
+func (t *DeviceTable) touch(el *list.Element, now time.Time) {
+	at := t.recent.Front()
+	for at != nil && (at == el || at.Value.(*Entry).LastSeen.After(now)) {
+		at = at.Next()
+	}
+	if at == nil {
+		t.recent.MoveToBack(el)
+	} else {
+		t.recent.MoveBefore(el, at)
+	}
+}
+
+// Expire は ttl を過ぎたデバイスと上限を超えた分のデバイスを消し、消したデバイスの最後の状態を返します。
+// 最も長く見えていないデバイスから調べるため、消すデバイスが無ければ O(1) です
+func (t *DeviceTable) Expire(now time.Time) []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var lost []Entry
+	for el := t.recent.Back(); el != nil; el = t.recent.Back() {
+		e := el.Value.(*Entry)
+		if now.Sub(e.LastSeen) <= t.ttl && (t.max == 0 || t.recent.Len() <= t.max) {
+			break
+		}
+		t.recent.Remove(el)
+		delete(t.entries, e.Info.Address)
+		lost = append(lost, *e)
+	}
+	return lost
+}
+
+// LastSeen は表の中で最後にアドバタイズを受信した時刻を返します（表が空ならゼロ値）
+func (t *DeviceTable) LastSeen() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if el := t.recent.Front(); el != nil {
+		return el.Value.(*Entry).LastSeen
+	}
+	return time.Time{}
+}
+
+// Get は 1 台分の状態を返します（addr は小文字の aa:bb:cc:dd:ee:ff 形式）
+func (t *DeviceTable) Get(addr string) (Entry, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	el, ok := t.entries[addr]
+	if !ok {
+		return Entry{}, false
+	}
+	return *el.Value.(*Entry), true
+}
+
+// List は match を満たすデバイスの状態のコピーを最後に見えた順（新しい順）に返します（match が nil なら全件）
+func (t *DeviceTable) List(match func(e *Entry) bool) []Entry {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	out := make([]Entry, 0, len(t.entries))
+	for el := t.recent.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*Entry); match == nil || match(e) {
+			out = append(out, *e)
+		}
+	}
+	return out
+}
+
+// Sorted は match を満たすデバイスを cmp の順に並べ、先頭の limit 件のコピーと
+// match を満たす件数を返します（limit が負なら全件）。cmp が等しいものは最後に見えた順です。
+// limit が小さければ全件を並べ替えずに上位 limit 件だけを選ぶため O(n log limit) で済みます
+func (t *DeviceTable) Sorted(match func(e *Entry) bool, cmp func(a, b *Entry) int, limit int) ([]Entry, int) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var top []*Entry
+	var total int
+	if limit < 0 || limit >= len(t.entries) {
+		top = make([]*Entry, 0, len(t.entries))
+		for el := t.recent.Front(); el != nil; el = el.Next() {
+			if e := el.Value.(*Entry); match == nil || match(e) {
+				top = append(top, e)
+			}
+		}
+		slices.SortStableFunc(top, cmp)
+		total = len(top)
+		if limit >= 0 && limit < total {
+			top = top[:limit]
+		}
+	} else {
+		top, total = topEntries(t.recent, match, cmp, limit)
+	}
+	out := make([]Entry, len(top))
+	for i, e := range top {
+		out[i] = *e
+	}
+	return out, total
+}
+
+// rankedEntry は並べ替えの途中の 1 件。seq は最後に見えた順の位置（同順位の比較用）
+type rankedEntry struct {
+	e   *Entry
+	seq int
+}
+
+// topEntries は l のうち match を満たすものから cmp で上位 k 件を選んで並べ、match を満たす件数と共に返します。
+// 上位 k 件の中で最も順位の低いものを根に置くヒープを使います
+func topEntries(l *list.List, match func(e *Entry) bool, cmp func(a, b *Entry) int, k int) ([]*Entry, int) {
+	less := func(a, b rankedEntry) bool { // a が b より上位か
+		if c := cmp(a.e, b.e); c != 0 {
+			return c < 0
+		}
+		return a.seq < b.seq
+	}
+	h := make([]rankedEntry, 0, k)
+	down := func(i int) {
+		for {
+			worst, l, r := i, 2*i+1, 2*i+2
+			if l < len(h) && less(h[worst], h[l]) {
+				worst = l
+			}
+			if r < len(h) && less(h[worst], h[r]) {
+				worst = r
+			}
+			if worst == i {
+				return
+			}
+			h[i], h[worst] = h[worst], h[i]
+			i = worst
+		}
+	}
+	seq := 0
+	for el := l.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*Entry)
+		if match != nil && !match(e) {
+			continue
+		}
+		it := rankedEntry{e: e, seq: seq}
+		seq++
+		switch {
+		case k == 0:
+		case len(h) < k:
+			h = append(h, it)
+			for i := len(h) - 1; i > 0 && less(h[(i-1)/2], h[i]); i = (i - 1) / 2 {
+				h[i], h[(i-1)/2] = h[(i-1)/2], h[i]
+			}
+		case less(it, h[0]):
+			h[0] = it
+			down(0)
+		}
+	}
+	slices.SortFunc(h, func(a, b rankedEntry) int {
+		if less(a, b) {
+			return -1
+		}
+		return 1
+	})
+	out := make([]*Entry, len(h))
+	for i, it := range h {
+		out[i] = it.e
+	}
+	return out, seq
+}
+
+// Len はデバイス数を返します
+func (t *DeviceTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.entries)
+}
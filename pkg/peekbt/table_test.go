@@ -0,0 +1,203 @@
+package peekbt
+
+import (
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+/* ---------- 1. 統計と名前の保持 ---------- */
+func TestDeviceTable_Stats(t *testing.T) {
+	tb := NewDeviceTable(time.Minute)
+	now := time.Now()
+	addr := net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}
+	if _, ev := tb.Update(sensorAdv{stubAdv: stubAdv{addr: addr, name: "Tile", rssi: -70}, mfg: []byte{0x4c, 0x00}}, now); ev != EventNew {
+		t.Fatalf("first advertisement: event %q", ev)
+	}
+	// RSSI だけの変化は update にせず、名前は空で上書きしない
+	if _, ev := tb.Update(stubAdv{addr: addr, rssi: -50}, now.Add(time.Second)); ev != "" {
+		t.Fatalf("changed RSSI only: event %q", ev)
+	}
+	if _, ev := tb.Update(stubAdv{addr: addr, name: "Tile Pro", rssi: -50}, now.Add(2*time.Second)); ev != EventUpdate {
+		t.Fatalf("changed name: event %q", ev)
+	}
+
+	e, ok := tb.Get("a4:c1:38:01:02:03")
+	if !ok {
+		t.Fatal("device not found")
+	}
+	rec := e.Record()
+	st := rec.Stats
+	if rec.Name != "Tile Pro" || rec.Vendor != "Apple" || rec.RSSI != -50 ||
+		st.Count != 3 || st.RSSIMin != -70 || st.RSSIMax != -50 || st.RSSIAvg != -170.0/3 {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+}
+
+/* ---------- 2. TTL ---------- */
+func TestDeviceTable_Expire(t *testing.T) {
+	tb := NewDeviceTable(time.Minute)
+	now := time.Now()
+	tb.Update(stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 0, 1}}, now.Add(-2*time.Minute))
+	tb.Update(stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 0, 2}}, now)
+
+	lost := tb.Expire(now)
+	if len(lost) != 1 || lost[0].Info.Address != "00:00:00:00:00:01" || tb.Len() != 1 {
+		t.Fatalf("lost=%v len=%d", lost, tb.Len())
+	}
+	// 遅れて届いた受信があっても最後の受信時刻は変わらない
+	tb.Update(stubAdv{addr: net.HardwareAddr{0, 0, 0, 0, 0, 3}}, now.Add(-30*time.Second))
+	if !tb.LastSeen().Equal(now) {
+		t.Errorf("LastSeen %v, want %v", tb.LastSeen(), now)
+	}
+	if !NewDeviceTable(time.Minute).LastSeen().IsZero() {
+		t.Error("empty table has LastSeen")
+	}
+}
+
+/* ---------- 3. センサー値の保持 ---------- */
+func TestDeviceTable_SensorCarriedForward(t *testing.T) {
+	tb := NewDeviceTable(time.Minute)
+	now := time.Now()
+	addr := net.HardwareAddr{0xa4, 0xc1, 0x38, 1, 2, 3}
+	tb.Update(sensorAdv{stubAdv: stubAdv{addr: addr}, mfg: []byte{0x88, 0xEC, 0x00, 0x03, 0xA5, 0x1F, 0x64, 0x00}}, now)
+
+	// センサー値を含まないスキャンレスポンス
+	e, ev := tb.Update(stubAdv{addr: addr, name: "GVH5075"}, now.Add(time.Second))
+	if ev != EventUpdate || e.Info.Sensor == nil || e.Info.Sensor.Temperature == nil {
+		t.Fatalf("sensor not carried forward: event %q, %+v", ev, e.Info)
+	}
+}
+
+/* ---------- 3. 上限と最後に見えた順 ---------- */
+func TestDeviceTable_MaxDevices(t *testing.T) {
+	tb := NewDeviceTable(time.Hour)
+	tb.SetMaxDevices(3)
+	now := time.Now()
+	for i := 1; i <= 4; i++ {
+		tb.Update(stubAdv{addr: addrN(i)}, now.Add(time.Duration(i)*time.Second))
+	}
+	// 1 番目を見直すと、最も長く見えていないのは 2 番目になる
+	tb.Update(stubAdv{addr: addrN(1)}, now.Add(5*time.Second))
+	if tb.Len() != 4 {
+		t.Fatalf("Update must not evict: len %d", tb.Len())
+	}
+	lost := tb.Expire(now.Add(5 * time.Second))
+	if len(lost) != 1 || lost[0].Info.Address != "00:00:00:00:00:02" || tb.Len() != 3 {
+		t.Fatalf("lost %v, len %d", lost, tb.Len())
+	}
+
+	var order []string
+	for _, e := range tb.List(nil) {
+		order = append(order, e.Info.Address[15:])
+	}
+	if want := []string{"01", "04", "03"}; !slices.Equal(order, want) {
+		t.Errorf("List order %v, want %v", order, want)
+	}
+}
+
+func TestDeviceTable_OutOfOrder(t *testing.T) {
+	tb := NewDeviceTable(time.Minute)
+	now := time.Now()
+	tb.Update(stubAdv{addr: addrN(1)}, now)
+	tb.Update(stubAdv{addr: addrN(2)}, now.Add(-2*time.Minute)) // 遅れて届いた古い受信
+	tb.Update(stubAdv{addr: addrN(3)}, now.Add(-30*time.Second))
+	lost := tb.Expire(now)
+	if len(lost) != 1 || lost[0].Info.Address != "00:00:00:00:00:02" || tb.Len() != 2 {
+		t.Fatalf("lost %v, len %d", lost, tb.Len())
+	}
+}
+
+/* ---------- 4. 並べ替え ---------- */
+func TestDeviceTable_Sorted(t *testing.T) {
+	tb := NewDeviceTable(time.Minute)
+	now := time.Now()
+	for i, rssi := range []int{-70, -40, -90, -40} {
+		tb.Update(stubAdv{addr: addrN(i), rssi: rssi}, now.Add(time.Duration(i)*time.Second))
+	}
+	byRSSI := func(a, b *Entry) int { return b.Info.RSSI - a.Info.RSSI }
+	got, total := tb.Sorted(func(e *Entry) bool { return e.Info.RSSI > -80 }, byRSSI, 2)
+	// 同じ RSSI は最後に見えた順
+	if len(got) != 2 || total != 3 || got[0].Info.Address != "00:00:00:00:00:03" || got[1].Info.Address != "00:00:00:00:00:01" {
+		t.Fatalf("Sorted %v", got)
+	}
+	if all, _ := tb.Sorted(nil, byRSSI, -1); len(all) != 4 || all[3].Info.RSSI != -90 {
+		t.Fatalf("Sorted without limit %v", all)
+	}
+
+	// 上位だけを選ぶ場合も全件の並べ替えの先頭と一致する（同順位が多い場合も）
+	for i := 0; i < 500; i++ {
+		tb.Update(stubAdv{addr: addrN(100 + i), rssi: -40 - (i*7)%13}, now.Add(time.Duration(i)*time.Millisecond))
+	}
+	all, _ := tb.Sorted(nil, byRSSI, -1)
+	for _, k := range []int{0, 1, 7, 100, 503} {
+		top, total := tb.Sorted(nil, byRSSI, k)
+		if len(top) != k || total != 504 {
+			t.Fatalf("limit %d: %d entries of %d", k, len(top), total)
+		}
+		for i := range top {
+			if top[i].Info.Address != all[i].Info.Address {
+				t.Fatalf("limit %d: entry %d is %s, want %s", k, i, top[i].Info.Address, all[i].Info.Address)
+			}
+		}
+	}
+}
+
+/* ---------- ベンチマーク ---------- */
+
+// benchAdvs は devices 台が順に送るアドバタイズを作ります
+func benchAdvs(devices int) []ble.Advertisement {
+	advs := make([]ble.Advertisement, devices)
+	for i := range advs {
+		advs[i] = stubAdv{addr: addrN(i), name: "dev", rssi: -40 - i%50}
+	}
+	return advs
+}
+
+// BenchmarkDeviceTable_Update は混雑した場所（1 万台）での更新と期限切れの処理。
+// Pi 3 で 10k adv/s を捌けるかは adv/s の値で確認します
+func BenchmarkDeviceTable_Update(b *testing.B) {
+	advs := benchAdvs(10000)
+	tb := NewDeviceTable(10 * time.Second)
+	tb.SetMaxDevices(5000)
+	now := time.Now()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		at := now.Add(time.Duration(i) * 100 * time.Microsecond) // 10k adv/s
+		tb.Update(advs[i%len(advs)], at)
+		tb.Expire(at)
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "adv/s")
+}
+
+// BenchmarkSession_Handle はセッション全体（表の更新とイベントの生成）の処理
+func BenchmarkSession_Handle(b *testing.B) {
+	advs := benchAdvs(10000)
+	s := NewSession(Config{TTL: 10 * time.Second, MaxDevices: 5000})
+	h := func(Event) {}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Handle(advs[i%len(advs)], h)
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "adv/s")
+}
+
+// BenchmarkDeviceTable_Sorted は 1 万台から RSSI の上位 50 台を取り出す（REST API の /devices 相当）
+func BenchmarkDeviceTable_Sorted(b *testing.B) {
+	tb := NewDeviceTable(time.Hour)
+	now := time.Now()
+	for i, a := range benchAdvs(10000) {
+		tb.Update(a, now.Add(time.Duration(i)*time.Millisecond))
+	}
+	byRSSI := func(a, b *Entry) int { return b.Info.RSSI - a.Info.RSSI }
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tb.Sorted(nil, byRSSI, 50)
+	}
+}